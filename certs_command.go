@@ -0,0 +1,60 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"tealfs/pkg/certs"
+	"tealfs/pkg/disk"
+	"tealfs/pkg/mgr"
+)
+
+func printCertsUsage() {
+	fmt.Fprintln(os.Stderr, os.Args[0], "certs ca <ca dir>")
+	fmt.Fprintln(os.Stderr, os.Args[0], "certs node <ca dir> <storage path> <tls dir>")
+}
+
+func certsCommand(args []string) int {
+	if len(args) < 1 {
+		printCertsUsage()
+		return 1
+	}
+
+	switch {
+	case args[0] == "ca" && len(args) == 2:
+		err := certs.NewCa(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error creating ca:", err)
+			return 1
+		}
+		return 0
+	case args[0] == "node" && len(args) == 4:
+		nodeId, err := mgr.ReadNodeId(args[2], &disk.DiskFileOps{})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error reading node id:", err)
+			return 1
+		}
+		err = certs.NewNodeCert(args[1], args[3], nodeId)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error creating node certificate:", err)
+			return 1
+		}
+		return 0
+	default:
+		printCertsUsage()
+		return 1
+	}
+}
@@ -22,6 +22,9 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"tealfs/pkg/conns"
+	"tealfs/pkg/disk"
+	"tealfs/pkg/mgr"
 	"testing"
 	"time"
 )
@@ -39,7 +42,7 @@ func TestOneNodeCluster(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go startTealFs(storagePath, webdavAddress, uiAddress, nodeAddress, 1, ctx)
+	go startTealFs(storagePath, webdavAddress, uiAddress, nodeAddress, 1, &conns.TcpConnectionProvider{}, ctx)
 	time.Sleep(time.Second)
 
 	resp, ok := putFile(ctx, webdavUrl, "text/plain", fileContents, t)
@@ -89,11 +92,17 @@ func TestTwoNodeCluster(t *testing.T) {
 	defer cancel1()
 	defer cancel2()
 
-	go startTealFs(storagePath1, webdavAddress1, uiAddress1, nodeAddress1, 1, ctx1)
-	go startTealFs(storagePath2, webdavAddress2, uiAddress2, nodeAddress2, 1, ctx2)
+	go startTealFs(storagePath1, webdavAddress1, uiAddress1, nodeAddress1, 1, &conns.TcpConnectionProvider{}, ctx1)
+	go startTealFs(storagePath2, webdavAddress2, uiAddress2, nodeAddress2, 1, &conns.TcpConnectionProvider{}, ctx2)
 
 	time.Sleep(time.Second)
 
+	token, ok := newJoinToken(ctx2, uiAddress2, storagePath2, t)
+	if !ok {
+		return
+	}
+	connectToContents += "&joinToken=" + url.QueryEscape(token)
+
 	resp, ok := putFile(ctx1, connectToUrl, "application/x-www-form-urlencoded", connectToContents, t)
 	if !ok {
 		t.Error("error response", resp.Status)
@@ -156,7 +165,7 @@ func TestTwoNodeCluster(t *testing.T) {
 	ctx1, cancel1 = context.WithCancel(context.Background())
 	defer cancel1()
 
-	go startTealFs(storagePath1, webdavAddress1, uiAddress1, nodeAddress1, 1, ctx1)
+	go startTealFs(storagePath1, webdavAddress1, uiAddress1, nodeAddress1, 1, &conns.TcpConnectionProvider{}, ctx1)
 
 	time.Sleep(time.Second)
 
@@ -242,10 +251,16 @@ func TestTwoNodeOneStorageCluster(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go startTealFs(storagePath1, webdavAddress1, uiAddress1, nodeAddress1, 0, ctx)
-	go startTealFs(storagePath2, webdavAddress2, uiAddress2, nodeAddress2, 1, ctx)
+	go startTealFs(storagePath1, webdavAddress1, uiAddress1, nodeAddress1, 0, &conns.TcpConnectionProvider{}, ctx)
+	go startTealFs(storagePath2, webdavAddress2, uiAddress2, nodeAddress2, 1, &conns.TcpConnectionProvider{}, ctx)
 	time.Sleep(time.Second)
 
+	token, ok := newJoinToken(ctx, uiAddress2, storagePath2, t)
+	if !ok {
+		return
+	}
+	connectToContents += "&joinToken=" + url.QueryEscape(token)
+
 	resp, ok := putFile(ctx, connectToUrl, "application/x-www-form-urlencoded", connectToContents, t)
 	if !ok {
 		t.Error("error response", resp.Status)
@@ -331,6 +346,39 @@ func getFile(ctx context.Context, url string, t *testing.T) (string, bool) {
 	return body, true
 }
 
+func newJoinToken(ctx context.Context, uiAddress string, storagePath string, t *testing.T) (string, bool) {
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlFor(uiAddress, "/join-token"), nil)
+	if err != nil {
+		t.Error("error creating request", err)
+		return "", false
+	}
+	password, err := mgr.ReadAdminPassword(storagePath, &disk.DiskFileOps{})
+	if err != nil {
+		t.Error("error reading admin password", err)
+		return "", false
+	}
+	req.SetBasicAuth("admin", password)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Error("error executing request", err)
+		return "", false
+	}
+
+	if resp.StatusCode >= 300 {
+		t.Error("error response with status", resp.Status)
+		return "", false
+	}
+
+	token, err := readAllToString(resp.Body)
+	if err != nil {
+		t.Error("error reading body", err)
+		return "", false
+	}
+	return token, true
+}
+
 func putFile(ctx context.Context, url string, contentType string, contents string, t *testing.T) (*http.Response, bool) {
 	client := http.Client{}
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewBufferString(contents))
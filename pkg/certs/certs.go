@@ -0,0 +1,161 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"tealfs/pkg/model"
+	"time"
+)
+
+const (
+	CaCertFile   = "ca.crt"
+	CaKeyFile    = "ca.key"
+	NodeCertFile = "node.crt"
+	NodeKeyFile  = "node.key"
+)
+
+const caValidity = 10 * 365 * 24 * time.Hour
+const nodeValidity = 2 * 365 * 24 * time.Hour
+
+// NewCa creates a self signed cluster certificate authority in caDir
+func NewCa(caDir string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "tealfs cluster ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	return writeCertAndKey(caDir, CaCertFile, CaKeyFile, der, key)
+}
+
+// NewNodeCert issues a certificate for nodeId signed by the ca in caDir
+// and writes it, along with a copy of the ca certificate, to nodeDir
+func NewNodeCert(caDir string, nodeDir string, nodeId model.NodeId) error {
+	caCert, caKey, err := loadCa(caDir)
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: string(nodeId)},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(nodeValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	err = writeCertAndKey(nodeDir, NodeCertFile, NodeKeyFile, der, key)
+	if err != nil {
+		return err
+	}
+	rawCa, err := os.ReadFile(filepath.Join(caDir, CaCertFile))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(nodeDir, CaCertFile), rawCa, 0644)
+}
+
+// NodeIdForCert returns the node id a certificate was issued for
+func NodeIdForCert(cert *x509.Certificate) model.NodeId {
+	return model.NodeId(cert.Subject.CommonName)
+}
+
+func loadCa(caDir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	rawCert, err := os.ReadFile(filepath.Join(caDir, CaCertFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(rawCert)
+	if certBlock == nil {
+		return nil, nil, errors.New("invalid ca certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	rawKey, err := os.ReadFile(filepath.Join(caDir, CaKeyFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(rawKey)
+	if keyBlock == nil {
+		return nil, nil, errors.New("invalid ca key")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writeCertAndKey(dir string, certFile string, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey})
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, certFile), certPem, 0644)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, keyFile), keyPem, 0600)
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
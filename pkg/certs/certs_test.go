@@ -0,0 +1,93 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package certs_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"tealfs/pkg/certs"
+	"tealfs/pkg/model"
+	"testing"
+)
+
+func TestNodeCert(t *testing.T) {
+	caDir := t.TempDir()
+	nodeDir := t.TempDir()
+	nodeId := model.NewNodeId()
+
+	err := certs.NewCa(caDir)
+	if err != nil {
+		t.Error("error creating ca", err)
+		return
+	}
+	err = certs.NewNodeCert(caDir, nodeDir, nodeId)
+	if err != nil {
+		t.Error("error creating node cert", err)
+		return
+	}
+
+	caCert := readCert(t, filepath.Join(nodeDir, certs.CaCertFile))
+	nodeCert := readCert(t, filepath.Join(nodeDir, certs.NodeCertFile))
+	if caCert == nil || nodeCert == nil {
+		return
+	}
+
+	if certs.NodeIdForCert(nodeCert) != nodeId {
+		t.Error("expected node id", nodeId, "got", certs.NodeIdForCert(nodeCert))
+		return
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	_, err = nodeCert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		t.Error("node cert should be signed by the ca", err)
+		return
+	}
+
+	info, err := os.Stat(filepath.Join(nodeDir, certs.NodeKeyFile))
+	if err != nil {
+		t.Error("error stat-ing node key", err)
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		t.Error("node key should only be readable by its owner", info.Mode())
+		return
+	}
+}
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Error("error reading cert", err)
+		return nil
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		t.Error("invalid pem in", path)
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Error("error parsing cert", err)
+		return nil
+	}
+	return cert
+}
@@ -24,10 +24,12 @@ type ConnectionProvider interface {
 	GetListener(address string) (net.Listener, error)
 }
 
+const dialTimeout = 10 * time.Second
+
 type TcpConnectionProvider struct{}
 
 func (r *TcpConnectionProvider) GetConnection(address string) (net.Conn, error) {
-	return net.Dial("tcp", address)
+	return net.DialTimeout("tcp", address, dialTimeout)
 }
 
 func (r *TcpConnectionProvider) GetListener(address string) (net.Listener, error) {
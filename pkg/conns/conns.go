@@ -22,13 +22,16 @@ import (
 )
 
 type Conns struct {
-	netConns      map[model.ConnId]net.Conn
+	netConns      map[model.ConnId]*sendQueue
 	nextId        model.ConnId
 	acceptedConns chan AcceptedConns
+	dialResults   chan dialResult
+	closedConns   chan model.ConnId
 	outStatuses   chan<- model.NetConnectionStatus
 	outReceives   chan<- model.ConnsMgrReceive
 	inConnectTo   <-chan model.MgrConnsConnectTo
 	inSends       <-chan model.MgrConnsSend
+	inDisconnects <-chan model.MgrConnsDisconnect
 	Address       string
 	provider      ConnectionProvider
 	nodeId        model.NodeId
@@ -40,6 +43,7 @@ func NewConns(
 	outReceives chan<- model.ConnsMgrReceive,
 	inConnectTo <-chan model.MgrConnsConnectTo,
 	inSends <-chan model.MgrConnsSend,
+	inDisconnects <-chan model.MgrConnsDisconnect,
 	provider ConnectionProvider,
 	address string,
 	nodeId model.NodeId,
@@ -50,13 +54,16 @@ func NewConns(
 		panic(err)
 	}
 	c := Conns{
-		netConns:      make(map[model.ConnId]net.Conn, 3),
+		netConns:      make(map[model.ConnId]*sendQueue, 3),
 		nextId:        model.ConnId(0),
 		acceptedConns: make(chan AcceptedConns),
+		dialResults:   make(chan dialResult),
+		closedConns:   make(chan model.ConnId),
 		outStatuses:   outStatuses,
 		outReceives:   outReceives,
 		inConnectTo:   inConnectTo,
 		inSends:       inSends,
+		inDisconnects: inDisconnects,
 		provider:      provider,
 		nodeId:        nodeId,
 		listener:      listener,
@@ -68,53 +75,96 @@ func NewConns(
 	return c
 }
 
+type dialResult struct {
+	id      model.ConnId
+	address string
+	netConn net.Conn
+	err     error
+}
+
 func (c *Conns) consumeChannels(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			c.listener.Close()
+			for id, q := range c.netConns {
+				_ = q.netConn.Close()
+				q.close()
+				delete(c.netConns, id)
+			}
 			return
 		case acceptedConn := <-c.acceptedConns:
-			id := c.saveNetConn(acceptedConn.netConn)
+			id := c.newConnId()
+			c.saveNetConn(ctx, id, acceptedConn.netConn)
 			c.outStatuses <- model.NetConnectionStatus{
 				Type: model.Connected,
 				Msg:  "Success",
 				Id:   id,
 			}
-			go c.consumeData(id)
+			go c.consumeData(ctx, id, acceptedConn.netConn)
 		case connectTo := <-c.inConnectTo:
-			// Todo: this needs to be non blocking
-			id, err := c.connectTo(connectTo.Address)
-			if err == nil {
+			go c.dial(ctx, c.newConnId(), connectTo.Address)
+		case result := <-c.dialResults:
+			if result.err == nil {
+				c.saveNetConn(ctx, result.id, result.netConn)
 				c.outStatuses <- model.NetConnectionStatus{
-					Type: model.Connected,
-					Msg:  "Success",
-					Id:   id,
+					Type:    model.Connected,
+					Msg:     "Success",
+					Id:      result.id,
+					Address: result.address,
 				}
-				go c.consumeData(id)
+				go c.consumeData(ctx, result.id, result.netConn)
 			} else {
 				c.outStatuses <- model.NetConnectionStatus{
-					Type: model.NotConnected,
-					Msg:  "Failure connecting",
-					Id:   id,
+					Type:    model.NotConnected,
+					Msg:     "Failure connecting",
+					Id:      result.id,
+					Address: result.address,
 				}
 			}
+		case id := <-c.closedConns:
+			if q, ok := c.netConns[id]; ok {
+				q.close()
+				delete(c.netConns, id)
+			}
+		case disconnect := <-c.inDisconnects:
+			if q, ok := c.netConns[disconnect.ConnId]; ok {
+				_ = q.netConn.Close()
+			}
 		case sendReq := <-c.inSends:
-			_, ok := c.netConns[sendReq.ConnId]
+			q, ok := c.netConns[sendReq.ConnId]
 			if !ok {
-				c.handleSendFailure(sendReq)
-			} else {
-				//Todo maybe this should be async
-				err := tnet.SendPayload(c.netConns[sendReq.ConnId], sendReq.Payload.ToBytes())
-				if err != nil {
-					c.handleSendFailure(sendReq)
+				c.handleSendFailure(ctx, sendReq)
+			} else if !q.offer(sendReq) {
+				c.outStatuses <- model.NetConnectionStatus{
+					Type: model.Congested,
+					Msg:  "send queue full, dropped payload",
+					Id:   sendReq.ConnId,
 				}
+				c.handleSendFailure(ctx, sendReq)
 			}
 		}
 	}
 }
 
-func (c *Conns) handleSendFailure(sendReq model.MgrConnsSend) {
+func (c *Conns) dial(ctx context.Context, id model.ConnId, address string) {
+	netConn, err := c.provider.GetConnection(address)
+	result := dialResult{
+		id:      id,
+		address: address,
+		netConn: netConn,
+		err:     err,
+	}
+	select {
+	case c.dialResults <- result:
+	case <-ctx.Done():
+		if err == nil {
+			_ = netConn.Close()
+		}
+	}
+}
+
+func (c *Conns) handleSendFailure(ctx context.Context, sendReq model.MgrConnsSend) {
 	payload := sendReq.Payload
 	switch p := payload.(type) {
 	case *model.ReadRequest:
@@ -124,23 +174,42 @@ func (c *Conns) handleSendFailure(sendReq model.MgrConnsSend) {
 				Caller:  p.Caller,
 				Ptrs:    ptrs,
 				BlockId: p.BlockId,
+				ReqId:   p.ReqId,
 			}
 
-			c.outReceives <- model.ConnsMgrReceive{
+			c.bounce(ctx, model.ConnsMgrReceive{
 				ConnId:  sendReq.ConnId,
 				Payload: &rr,
-			}
+				Bounced: true,
+			})
 		} else {
-			c.outReceives <- model.ConnsMgrReceive{
-				ConnId: sendReq.ConnId,
+			c.bounce(ctx, model.ConnsMgrReceive{
+				ConnId:  sendReq.ConnId,
+				Bounced: true,
 				Payload: &model.ReadResult{
 					Ok:      false,
 					Message: "no pointers in read request",
 					Caller:  p.Caller,
 					BlockId: p.BlockId,
+					ReqId:   p.ReqId,
 				},
-			}
+			})
 		}
+	case *model.WriteRequest:
+		c.bounce(ctx, model.ConnsMgrReceive{
+			ConnId:  sendReq.ConnId,
+			Payload: p,
+			Bounced: true,
+		})
+	}
+}
+
+// bounce hands an undelivered send back to mgr unless we're shutting down,
+// when nobody is reading anymore
+func (c *Conns) bounce(ctx context.Context, receive model.ConnsMgrReceive) {
+	select {
+	case c.outReceives <- receive:
+	case <-ctx.Done():
 	}
 }
 
@@ -160,21 +229,20 @@ type AcceptedConns struct {
 	netConn net.Conn
 }
 
-func (c *Conns) consumeData(conn model.ConnId) {
+func (c *Conns) consumeData(ctx context.Context, conn model.ConnId, netConn net.Conn) {
 	for {
-		netConn := c.netConns[conn]
 		bytes, err := tnet.ReadPayload(netConn)
 		if err != nil {
-			_ = netConn.Close()
-			delete(c.netConns, conn)
-			c.outStatuses <- model.NetConnectionStatus{
-				Type: model.NotConnected,
-				Msg:  "Connection closed",
-				Id:   conn,
-			}
+			c.closeConn(ctx, conn, netConn, "Connection closed")
 			return
 		}
 		payload := model.ToPayload(bytes)
+		if iam, ok := payload.(*model.IAm); ok {
+			if certNodeId, isTls := peerNodeId(netConn); isTls && certNodeId != iam.NodeId {
+				c.closeConn(ctx, conn, netConn, "node id does not match certificate")
+				return
+			}
+		}
 		c.outReceives <- model.ConnsMgrReceive{
 			ConnId:  conn,
 			Payload: payload,
@@ -182,18 +250,28 @@ func (c *Conns) consumeData(conn model.ConnId) {
 	}
 }
 
-func (c *Conns) connectTo(address string) (model.ConnId, error) {
-	netConn, err := c.provider.GetConnection(address)
-	if err != nil {
-		return 0, err
+func (c *Conns) closeConn(ctx context.Context, conn model.ConnId, netConn net.Conn, msg string) {
+	_ = netConn.Close()
+	select {
+	case c.closedConns <- conn:
+	case <-ctx.Done():
+		return
+	}
+	c.outStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Msg:  msg,
+		Id:   conn,
 	}
-	id := c.saveNetConn(netConn)
-	return id, nil
 }
 
-func (c *Conns) saveNetConn(netConn net.Conn) model.ConnId {
+func (c *Conns) newConnId() model.ConnId {
 	id := c.nextId
 	c.nextId++
-	c.netConns[id] = netConn
 	return id
 }
+
+func (c *Conns) saveNetConn(ctx context.Context, id model.ConnId, netConn net.Conn) {
+	c.netConns[id] = newSendQueue(netConn, func(sendReq model.MgrConnsSend) {
+		c.handleSendFailure(ctx, sendReq)
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"tealfs/pkg/model"
 	"testing"
+	"time"
 )
 
 func TestAcceptConn(t *testing.T) {
@@ -103,6 +104,10 @@ func TestSendReadRequestNoConnected(t *testing.T) {
 		t.Error("Expected ConnId to be 0")
 		return
 	}
+	if !outReceive.Bounced {
+		t.Error("expected the read request to be marked as bounced")
+		return
+	}
 	switch p := outReceive.Payload.(type) {
 	case *model.ReadRequest:
 		if p.BlockId != readRequest.BlockId || p.Caller != readRequest.Caller {
@@ -119,6 +124,32 @@ func TestSendReadRequestNoConnected(t *testing.T) {
 	}
 }
 
+func TestSendWriteRequestNoConnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, outReceives, _, inSend, _ := newConnsTest(ctx)
+	writeRequest := model.WriteRequest{
+		Caller: "caller1",
+		Data: model.RawData{
+			Ptr:  model.DiskPointer{NodeId: "nodeId1", FileName: "filename1"},
+			Data: []byte{1},
+		},
+		ReqId: "reqId1",
+	}
+	inSend <- model.MgrConnsSend{
+		ConnId:  0,
+		Payload: &writeRequest,
+	}
+	outReceive := <-outReceives
+	if p, ok := outReceive.Payload.(*model.WriteRequest); !ok || !p.Equal(&writeRequest) {
+		t.Error("expected the write request back", outReceive.Payload)
+		return
+	}
+	if !outReceive.Bounced {
+		t.Error("expected the write request to be marked as bounced")
+	}
+}
+
 func TestSendReadRequestSendFailure(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -148,6 +179,10 @@ func TestSendReadRequestSendFailure(t *testing.T) {
 		t.Error("Expected ConnId to be 0")
 		return
 	}
+	if !outReceive.Bounced {
+		t.Error("expected the read request to be marked as bounced")
+		return
+	}
 	switch p := outReceive.Payload.(type) {
 	case *model.ReadRequest:
 		if p.BlockId != readRequest.BlockId || p.Caller != readRequest.Caller {
@@ -164,6 +199,61 @@ func TestSendReadRequestSendFailure(t *testing.T) {
 	}
 }
 
+func TestSendQueueFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, outStatus, outReceives, inConnectTo, inSend, _ := newConnsTest(ctx)
+	status := connectTo("address:123", outStatus, inConnectTo)
+	readRequest := model.ReadRequest{
+		Caller: "caller1",
+		Ptrs: []model.DiskPointer{
+			{NodeId: "nodeId1", FileName: "filename1"},
+			{NodeId: "nodeId2", FileName: "filename2"},
+		},
+		BlockId: "blockId1",
+	}
+
+	// Nothing reads from the mock connection so the first send blocks
+	// the writer and the rest fill up the queue
+	for range sendQueueSize + 1 {
+		inSend <- model.MgrConnsSend{ConnId: status.Id, Payload: &readRequest}
+	}
+	inSend <- model.MgrConnsSend{ConnId: status.Id, Payload: &readRequest}
+
+	congested := <-outStatus
+	if congested.Type != model.Congested || congested.Id != status.Id {
+		t.Error("expected congested status", congested)
+		return
+	}
+	outReceive := <-outReceives
+	if rr, ok := outReceive.Payload.(*model.ReadRequest); !ok || len(rr.Ptrs) != 1 {
+		t.Error("expected dropped read request to move on to the next pointer", outReceive.Payload)
+		return
+	}
+
+	// A stuck connection shouldn't stop us from making new ones
+	second := connectTo("address:234", outStatus, inConnectTo)
+	if second.Type != model.Connected || second.Id == status.Id {
+		t.Error("expected a new connection", second)
+		return
+	}
+}
+
+func TestSendQueueCloseStopsWriter(t *testing.T) {
+	netConn := &MockConn{dataToRead: make(chan []byte), dataWritten: make(chan []byte)}
+	q := newSendQueue(netConn, func(model.MgrConnsSend) {})
+	q.offer(model.MgrConnsSend{ConnId: 1, Payload: &model.ReadRequest{Caller: "caller1"}})
+	<-netConn.dataWritten
+	<-netConn.dataWritten
+
+	q.close()
+	select {
+	case <-q.done:
+	case <-time.After(time.Second):
+		t.Error("expected the writer to stop once the queue is closed")
+	}
+}
+
 func TestConnectionError(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -232,7 +322,8 @@ func newConnsTest(ctx context.Context) (Conns, chan model.NetConnectionStatus, c
 	outReceives := make(chan model.ConnsMgrReceive)
 	inConnectTo := make(chan model.MgrConnsConnectTo)
 	inSends := make(chan model.MgrConnsSend)
+	inDisconnects := make(chan model.MgrConnsDisconnect)
 	provider := NewMockConnectionProvider()
-	c := NewConns(outStatuses, outReceives, inConnectTo, inSends, &provider, "dummyAddress:123", model.NewNodeId(), ctx)
+	c := NewConns(outStatuses, outReceives, inConnectTo, inSends, inDisconnects, &provider, "dummyAddress:123", model.NewNodeId(), ctx)
 	return c, outStatuses, outReceives, inConnectTo, inSends, &provider
 }
@@ -0,0 +1,68 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package conns
+
+import (
+	"net"
+	"tealfs/pkg/model"
+	"tealfs/pkg/tnet"
+)
+
+const sendQueueSize = 64
+
+// sendQueue owns the writing side of a connection. Payloads are written
+// by their own goroutine so a slow peer only holds up its own queue.
+type sendQueue struct {
+	netConn net.Conn
+	queue   chan model.MgrConnsSend
+	// done is closed once the writer has stopped
+	done chan struct{}
+}
+
+func newSendQueue(netConn net.Conn, onFailure func(model.MgrConnsSend)) *sendQueue {
+	q := sendQueue{
+		netConn: netConn,
+		queue:   make(chan model.MgrConnsSend, sendQueueSize),
+		done:    make(chan struct{}),
+	}
+	go q.write(onFailure)
+	return &q
+}
+
+// offer queues the payload without blocking and reports whether there was room
+func (q *sendQueue) offer(sendReq model.MgrConnsSend) bool {
+	select {
+	case q.queue <- sendReq:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *sendQueue) close() {
+	close(q.queue)
+}
+
+func (q *sendQueue) write(onFailure func(model.MgrConnsSend)) {
+	defer close(q.done)
+	for sendReq := range q.queue {
+		err := tnet.SendPayload(q.netConn, sendReq.Payload.ToBytes())
+		if err != nil {
+			// the reader will notice the closed connection and report it
+			_ = q.netConn.Close()
+			onFailure(sendReq)
+		}
+	}
+}
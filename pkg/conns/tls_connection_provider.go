@@ -0,0 +1,108 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package conns
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"tealfs/pkg/certs"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// TlsConnectionProvider only talks to nodes holding a certificate
+// issued by the cluster ca. Peers are dialed by address rather than
+// by name, so the peer certificate is checked against the ca here and
+// its node id is checked against the peer's IAm in Conns.
+type TlsConnectionProvider struct {
+	config *tls.Config
+	// dialTimeout covers the handshake too, so a peer that accepts and then
+	// goes quiet can't hold up the dial
+	dialTimeout time.Duration
+}
+
+func NewTlsConnectionProvider(certDir string) (*TlsConnectionProvider, error) {
+	cert, err := tls.LoadX509KeyPair(
+		filepath.Join(certDir, certs.NodeCertFile),
+		filepath.Join(certDir, certs.NodeKeyFile),
+	)
+	if err != nil {
+		return nil, err
+	}
+	rawCa, err := os.ReadFile(filepath.Join(certDir, certs.CaCertFile))
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(rawCa) {
+		return nil, errors.New("invalid ca certificate")
+	}
+
+	return &TlsConnectionProvider{
+		config: &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			ClientAuth:         tls.RequireAndVerifyClientCert,
+			ClientCAs:          caPool,
+			InsecureSkipVerify: true,
+			VerifyConnection:   verifyPeerAgainstCa(caPool),
+			MinVersion:         tls.VersionTLS13,
+		},
+		dialTimeout: dialTimeout,
+	}, nil
+}
+
+func (t *TlsConnectionProvider) GetConnection(address string) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: t.dialTimeout}, "tcp", address, t.config)
+}
+
+func (t *TlsConnectionProvider) GetListener(address string) (net.Listener, error) {
+	return tls.Listen("tcp", address, t.config)
+}
+
+func verifyPeerAgainstCa(caPool *x509.CertPool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no peer certificate")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         caPool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		return err
+	}
+}
+
+// peerNodeId returns the node id from the peer's certificate if the
+// connection is a tls connection
+func peerNodeId(netConn net.Conn) (model.NodeId, bool) {
+	tlsConn, ok := netConn.(*tls.Conn)
+	if !ok {
+		return "", false
+	}
+	peerCerts := tlsConn.ConnectionState().PeerCertificates
+	if len(peerCerts) == 0 {
+		return "", false
+	}
+	return certs.NodeIdForCert(peerCerts[0]), true
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package conns
+
+import (
+	"net"
+	"tealfs/pkg/certs"
+	"tealfs/pkg/model"
+	"testing"
+	"time"
+)
+
+func TestTlsPeerNodeId(t *testing.T) {
+	caDir := newCaDir(t)
+	serverNode := model.NewNodeId()
+	clientNode := model.NewNodeId()
+	server := tlsProviderForNode(t, caDir, serverNode)
+	client := tlsProviderForNode(t, caDir, clientNode)
+
+	listener, err := server.GetListener("127.0.0.1:0")
+	if err != nil {
+		t.Error("error listening", err)
+		return
+	}
+	defer listener.Close()
+
+	accepted := make(chan model.NodeId)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- ""
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1)
+		_, _ = conn.Read(buf)
+		id, _ := peerNodeId(conn)
+		accepted <- id
+	}()
+
+	conn, err := client.GetConnection(listener.Addr().String())
+	if err != nil {
+		t.Error("error connecting", err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte{1})
+	if err != nil {
+		t.Error("error writing", err)
+		return
+	}
+
+	id, ok := peerNodeId(conn)
+	if !ok || id != serverNode {
+		t.Error("expected server node id", serverNode, "got", id)
+		return
+	}
+	if id := <-accepted; id != clientNode {
+		t.Error("expected client node id", clientNode, "got", id)
+		return
+	}
+}
+
+func TestTlsRejectsOtherCa(t *testing.T) {
+	server := tlsProviderForNode(t, newCaDir(t), model.NewNodeId())
+	client := tlsProviderForNode(t, newCaDir(t), model.NewNodeId())
+
+	listener, err := server.GetListener("127.0.0.1:0")
+	if err != nil {
+		t.Error("error listening", err)
+		return
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			buf := make([]byte, 1)
+			_, _ = conn.Read(buf)
+			conn.Close()
+		}
+	}()
+
+	conn, err := client.GetConnection(listener.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Error("should not connect to a node from another cluster")
+	}
+}
+
+func TestTlsDialGivesUpOnSilentPeer(t *testing.T) {
+	client := tlsProviderForNode(t, newCaDir(t), model.NewNodeId())
+	client.dialTimeout = 100 * time.Millisecond
+
+	// accepts the connection but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error("error listening", err)
+		return
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			time.Sleep(time.Second)
+			conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	conn, err := client.GetConnection(listener.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Error("should not connect to a peer that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Error("expected the dial to give up after its timeout, took", elapsed)
+	}
+}
+
+func tlsProviderForNode(t *testing.T, caDir string, nodeId model.NodeId) *TlsConnectionProvider {
+	nodeDir := t.TempDir()
+	if err := certs.NewNodeCert(caDir, nodeDir, nodeId); err != nil {
+		t.Fatal("error creating node cert", err)
+	}
+	provider, err := NewTlsConnectionProvider(nodeDir)
+	if err != nil {
+		t.Fatal("error creating provider", err)
+	}
+	return provider
+}
+
+func newCaDir(t *testing.T) string {
+	caDir := t.TempDir()
+	if err := certs.NewCa(caDir); err != nil {
+		t.Fatal("error creating ca", err)
+	}
+	return caDir
+}
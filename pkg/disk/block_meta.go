@@ -0,0 +1,61 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package disk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"tealfs/pkg/model"
+)
+
+// blocks are saved with a sidecar file holding metadata about the data,
+// blocks written before the sidecar existed simply don't have one
+const metaSuffix = ".meta"
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+var ErrStaleVersion = errors.New("a newer version of the block is already saved")
+
+type blockMeta struct {
+	checksum []byte
+	// version is zero for blocks saved before versions existed
+	version model.Version
+}
+
+func newBlockMeta(data []byte, version model.Version) blockMeta {
+	sum := sha256.Sum256(data)
+	return blockMeta{checksum: sum[:], version: version}
+}
+
+func (m *blockMeta) toBytes() []byte {
+	return append(model.BytesToBytes(m.checksum), model.Int64ToBytes(int64(m.version))...)
+}
+
+func blockMetaFromBytes(raw []byte) (blockMeta, error) {
+	if len(raw) < 4 {
+		return blockMeta{}, errors.New("invalid block metadata")
+	}
+	checksum, remainder := model.BytesFromBytes(raw)
+	version, _ := model.Int64FromBytes(remainder)
+	return blockMeta{checksum: checksum, version: model.Version(version)}, nil
+}
+
+func (m *blockMeta) verify(data []byte) error {
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[:], m.checksum) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
@@ -16,11 +16,16 @@ package disk
 
 import (
 	"errors"
+	"fmt"
 	"io/fs"
 	"path/filepath"
+	"strings"
 	"tealfs/pkg/model"
+	"tealfs/pkg/set"
 )
 
+var ErrBlockNotFound = errors.New("block not found")
+
 type Path struct {
 	raw string
 	ops FileOps
@@ -30,44 +35,68 @@ func New(path Path, id model.NodeId,
 	mgrDiskWrites chan model.WriteRequest,
 	mgrDiskReads chan model.ReadRequest,
 	diskMgrWrites chan model.WriteResult,
-	diskMgrReads chan model.ReadResult) Disk {
+	diskMgrReads chan model.ReadResult,
+	mgrDiskInventories chan model.InventoryRequest,
+	diskMgrInventories chan model.Inventory,
+	mgrDiskHints chan model.HintsRequest,
+	diskMgrHints chan model.Hints,
+	mgrDiskHintDeletes chan model.DeleteHint) Disk {
 	p := Disk{
-		path:      path,
-		id:        id,
-		inWrites:  mgrDiskWrites,
-		inReads:   mgrDiskReads,
-		outReads:  diskMgrReads,
-		outWrites: diskMgrWrites,
+		path:           path,
+		id:             id,
+		inWrites:       mgrDiskWrites,
+		inReads:        mgrDiskReads,
+		inInventories:  mgrDiskInventories,
+		outReads:       diskMgrReads,
+		outWrites:      diskMgrWrites,
+		outInventories: diskMgrInventories,
+		inHints:        mgrDiskHints,
+		outHints:       diskMgrHints,
+		inHintDeletes:  mgrDiskHintDeletes,
 	}
 	go p.consumeChannels()
 	return p
 }
 
 type Disk struct {
-	path      Path
-	id        model.NodeId
-	outReads  chan model.ReadResult
-	outWrites chan model.WriteResult
-	inWrites  chan model.WriteRequest
-	inReads   chan model.ReadRequest
+	path           Path
+	id             model.NodeId
+	outReads       chan model.ReadResult
+	outWrites      chan model.WriteResult
+	inWrites       chan model.WriteRequest
+	inReads        chan model.ReadRequest
+	inInventories  chan model.InventoryRequest
+	outInventories chan model.Inventory
+	inHints        chan model.HintsRequest
+	outHints       chan model.Hints
+	inHintDeletes  chan model.DeleteHint
 }
 
 func (d *Disk) consumeChannels() {
 	for {
 		select {
 		case s := <-d.inWrites:
-			err := d.path.Save(s.Data)
+			var err error
+			if s.Conditional {
+				err = d.path.SaveIfUnchanged(s.Data, s.Expected)
+			} else {
+				err = d.path.Save(s.Data)
+			}
 			if err == nil {
 				d.outWrites <- model.WriteResult{
 					Ok:     true,
 					Caller: s.Caller,
 					Ptr:    s.Data.Ptr,
+					ReqId:  s.ReqId,
 				}
 			} else {
 				d.outWrites <- model.WriteResult{
-					Ok:      false,
-					Message: err.Error(),
-					Caller:  s.Caller,
+					Ok:       false,
+					Message:  err.Error(),
+					Caller:   s.Caller,
+					Ptr:      s.Data.Ptr,
+					ReqId:    s.ReqId,
+					Conflict: errors.Is(err, model.ErrVersionConflict),
 				}
 			}
 		case r := <-d.inReads:
@@ -78,7 +107,9 @@ func (d *Disk) consumeChannels() {
 					Caller:  r.Caller,
 					Ptrs:    r.Ptrs,
 					BlockId: r.BlockId,
+					ReqId:   r.ReqId,
 				}
+				continue
 			}
 			data, err := d.path.Read(r.Ptrs[0])
 			if err == nil {
@@ -88,32 +119,196 @@ func (d *Disk) consumeChannels() {
 					Data:    data,
 					Ptrs:    r.Ptrs[1:],
 					BlockId: r.BlockId,
+					ReqId:   r.ReqId,
 				}
 			} else {
 				d.outReads <- model.ReadResult{
-					Ok:      false,
-					Message: err.Error(),
-					Caller:  r.Caller,
-					Ptrs:    r.Ptrs[1:],
-					BlockId: r.BlockId,
+					Ok:       false,
+					Message:  err.Error(),
+					Caller:   r.Caller,
+					Ptrs:     r.Ptrs[1:],
+					BlockId:  r.BlockId,
+					ReqId:    r.ReqId,
+					NotFound: errors.Is(err, ErrBlockNotFound),
 				}
 			}
+		case i := <-d.inInventories:
+			blocks, err := d.path.Inventory()
+			d.outInventories <- model.Inventory{
+				ReqId:  i.ReqId,
+				Blocks: blocks,
+				Err:    err,
+			}
+		case h := <-d.inHints:
+			if h.Owner == "" {
+				d.allHints(h.ReqId)
+				continue
+			}
+			blocks, err := d.path.Hints(h.Owner)
+			d.outHints <- model.Hints{
+				ReqId:  h.ReqId,
+				Owner:  h.Owner,
+				Blocks: blocks,
+				Err:    err,
+			}
+		case h := <-d.inHintDeletes:
+			err := d.path.DeleteHint(h.Ptr)
+			if err != nil {
+				fmt.Println("error deleting hint", h.Ptr.FileName+":", err)
+			}
 		}
 	}
 }
 
+// allHints sends the hints kept here for each node, one reply per node
+func (d *Disk) allHints(reqId model.ReqId) {
+	owners, err := d.path.HintOwners()
+	if err != nil {
+		fmt.Println("error listing hints:", err)
+		return
+	}
+	for _, owner := range owners {
+		blocks, err := d.path.Hints(owner)
+		d.outHints <- model.Hints{ReqId: reqId, Owner: owner, Blocks: blocks, Err: err}
+	}
+}
+
+// Save writes a block unless the disk already holds a newer version of it
 func (p *Path) Save(rawData model.RawData) error {
 	filePath := filepath.Join(p.raw, rawData.Ptr.FileName)
-	return p.ops.WriteFile(filePath, rawData.Data)
+	rawMeta, err := p.ops.ReadFile(filePath + metaSuffix)
+	if err == nil {
+		existing, err := blockMetaFromBytes(rawMeta)
+		if err == nil && existing.version > rawData.Version {
+			return ErrStaleVersion
+		}
+	}
+	err = p.ops.WriteFile(filePath, rawData.Data)
+	if err != nil {
+		return err
+	}
+	meta := newBlockMeta(rawData.Data, rawData.Version)
+	return p.ops.WriteFile(filePath+metaSuffix, meta.toBytes())
+}
+
+// SaveIfUnchanged writes a block unless the disk holds a version of it
+// newer than the one the write was based on
+func (p *Path) SaveIfUnchanged(rawData model.RawData, expected model.Version) error {
+	filePath := filepath.Join(p.raw, rawData.Ptr.FileName)
+	rawMeta, err := p.ops.ReadFile(filePath + metaSuffix)
+	if err == nil {
+		existing, err := blockMetaFromBytes(rawMeta)
+		if err == nil && existing.version > expected {
+			return model.ErrVersionConflict
+		}
+	}
+	return p.Save(rawData)
 }
 
 func (p *Path) Read(ptr model.DiskPointer) (model.RawData, error) {
 	filePath := filepath.Join(p.raw, ptr.FileName)
 	result, err := p.ops.ReadFile(filePath)
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		return model.RawData{Ptr: ptr, Data: []byte{}}, nil
+		return model.RawData{Ptr: ptr}, ErrBlockNotFound
+	}
+	if err != nil {
+		return model.RawData{Ptr: ptr, Data: result}, err
+	}
+	rawMeta, err := p.ops.ReadFile(filePath + metaSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return model.RawData{Ptr: ptr, Data: result}, nil
+	}
+	if err != nil {
+		return model.RawData{Ptr: ptr}, err
+	}
+	meta, err := blockMetaFromBytes(rawMeta)
+	if err != nil {
+		return model.RawData{Ptr: ptr}, err
+	}
+	if err = meta.verify(result); err != nil {
+		return model.RawData{Ptr: ptr}, err
+	}
+	return model.RawData{Ptr: ptr, Data: result, Version: meta.version}, nil
+}
+
+// Inventory summarizes the blocks saved with a metadata sidecar
+func (p *Path) Inventory() ([]model.BlockSummary, error) {
+	names, err := p.ops.ReadDir(p.raw)
+	if err != nil {
+		return nil, err
+	}
+	blocks := []model.BlockSummary{}
+	for _, name := range names {
+		blockName, isMeta := strings.CutSuffix(name, metaSuffix)
+		if !isMeta || model.IsHint(blockName) {
+			continue
+		}
+		rawMeta, err := p.ops.ReadFile(filepath.Join(p.raw, name))
+		if err != nil {
+			return nil, err
+		}
+		meta, err := blockMetaFromBytes(rawMeta)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, model.BlockSummary{
+			BlockId:  model.BlockId(blockName),
+			Checksum: meta.checksum,
+			Version:  meta.version,
+		})
+	}
+	return blocks, nil
+}
+
+// HintOwners lists the nodes there are hints kept for
+func (p *Path) HintOwners() ([]model.NodeId, error) {
+	names, err := p.ops.ReadDir(p.raw)
+	if err != nil {
+		return nil, err
+	}
+	owners := set.NewSet[model.NodeId]()
+	for _, name := range names {
+		if hinted, ok := model.HintedPointer(name); ok {
+			owners.Add(hinted.NodeId)
+		}
+	}
+	return owners.GetValues(), nil
+}
+
+// Hints reads the hints kept for owner
+func (p *Path) Hints(owner model.NodeId) ([]model.RawData, error) {
+	names, err := p.ops.ReadDir(p.raw)
+	if err != nil {
+		return nil, err
+	}
+	hints := []model.RawData{}
+	for _, name := range names {
+		hinted, ok := model.HintedPointer(name)
+		if !ok || hinted.NodeId != owner || strings.HasSuffix(name, metaSuffix) {
+			continue
+		}
+		data, err := p.Read(model.DiskPointer{FileName: name})
+		if err != nil {
+			// a hint that can't be read can't be replayed either
+			fmt.Println("skipping hint", name+":", err)
+			continue
+		}
+		hints = append(hints, data)
+	}
+	return hints, nil
+}
+
+// DeleteHint removes a hint that was delivered
+func (p *Path) DeleteHint(ptr model.DiskPointer) error {
+	if !model.IsHint(ptr.FileName) {
+		return errors.New("not a hint")
+	}
+	filePath := filepath.Join(p.raw, ptr.FileName)
+	err := p.ops.Remove(filePath + metaSuffix)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
 	}
-	return model.RawData{Ptr: ptr, Data: result}, err
+	return p.ops.Remove(filePath)
 }
 
 func NewPath(rawPath string, ops FileOps) Path {
@@ -16,7 +16,9 @@ package disk_test
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"tealfs/pkg/disk"
 	"tealfs/pkg/model"
@@ -24,7 +26,7 @@ import (
 )
 
 func TestWriteData(t *testing.T) {
-	f, path, nodeId, mgrDiskWrites, _, diskMgrWrites, _, _ := newDiskService()
+	f, path, nodeId, mgrDiskWrites, _, diskMgrWrites, _, _, _, _ := newDiskService()
 	blockId := model.NewBlockId()
 	data := []byte{0, 1, 2, 3, 4, 5}
 	expectedPath := filepath.Join(path.String(), string(blockId))
@@ -55,7 +57,7 @@ func TestWriteData(t *testing.T) {
 }
 
 func TestReadData(t *testing.T) {
-	f, path, _, _, mgrDiskReads, _, diskMgrReads, _ := newDiskService()
+	f, path, _, _, mgrDiskReads, _, diskMgrReads, _, _, _ := newDiskService()
 	blockId := model.NewBlockId()
 	caller := model.NewNodeId()
 	data := []byte{0, 1, 2, 3, 4, 5}
@@ -82,7 +84,7 @@ func TestReadData(t *testing.T) {
 }
 
 func TestReadNewFile(t *testing.T) {
-	f, path, _, _, mgrDiskReads, _, diskMgrReads, _ := newDiskService()
+	f, path, _, _, mgrDiskReads, _, diskMgrReads, _, _, _ := newDiskService()
 	blockId := model.NewBlockId()
 	caller := model.NewNodeId()
 	data := []byte{0, 1, 2, 3, 4, 5}
@@ -99,17 +101,226 @@ func TestReadNewFile(t *testing.T) {
 		},
 	}
 	result := <-diskMgrReads
-	if !result.Ok {
+	if result.Ok || !result.NotFound {
+		t.Error("expected a missing block to be reported as not found", result)
+		return
+	}
+}
+
+func TestReadChecksumMismatch(t *testing.T) {
+	f, path, nodeId, mgrDiskWrites, mgrDiskReads, diskMgrWrites, diskMgrReads, _, _, _ := newDiskService()
+	blockId := model.NewBlockId()
+	ptr := model.DiskPointer{
+		NodeId:   nodeId,
+		FileName: string(blockId),
+	}
+	mgrDiskWrites <- model.WriteRequest{
+		Caller: nodeId,
+		Data:   model.RawData{Ptr: ptr, Data: []byte{0, 1, 2, 3}},
+	}
+	if result := <-diskMgrWrites; !result.Ok {
 		t.Error("Bad write result")
 		return
 	}
-	if !bytes.Equal(result.Data.Data, []byte{}) {
-		t.Error("Written data is wrong")
+
+	_ = f.WriteFile(filepath.Join(path.String(), string(blockId)), []byte{0, 1, 2, 4})
+	mgrDiskReads <- model.ReadRequest{
+		Caller:  nodeId,
+		Ptrs:    []model.DiskPointer{ptr},
+		BlockId: blockId,
+	}
+	result := <-diskMgrReads
+	if result.Ok {
+		t.Error("corrupted block should not read ok")
+		return
+	}
+	if result.Message != disk.ErrChecksumMismatch.Error() {
+		t.Error("unexpected message", result.Message)
+		return
+	}
+}
+
+func TestSaveRejectsOlderVersion(t *testing.T) {
+	_, _, nodeId, mgrDiskWrites, mgrDiskReads, diskMgrWrites, diskMgrReads, _, _, _ := newDiskService()
+	ptr := model.DiskPointer{NodeId: nodeId, FileName: string(model.NewBlockId())}
+	write := func(data []byte, version model.Version) model.WriteResult {
+		mgrDiskWrites <- model.WriteRequest{
+			Caller: nodeId,
+			Data:   model.RawData{Ptr: ptr, Data: data, Version: version},
+		}
+		return <-diskMgrWrites
+	}
+
+	if result := write([]byte{2}, 2); !result.Ok {
+		t.Error("Bad write result", result.Message)
+		return
+	}
+	if result := write([]byte{1}, 1); result.Ok || result.Message != disk.ErrStaleVersion.Error() {
+		t.Error("older version should be rejected", result)
+		return
+	}
+	if result := write([]byte{2}, 2); !result.Ok {
+		t.Error("rewriting the same version should be allowed", result.Message)
+		return
+	}
+
+	mgrDiskReads <- model.ReadRequest{Caller: nodeId, Ptrs: []model.DiskPointer{ptr}}
+	result := <-diskMgrReads
+	if !result.Ok || !bytes.Equal(result.Data.Data, []byte{2}) || result.Data.Version != 2 {
+		t.Error("expected the newer version", result)
+		return
+	}
+}
+
+func TestConditionalSave(t *testing.T) {
+	_, _, nodeId, mgrDiskWrites, _, diskMgrWrites, _, _, _, _ := newDiskService()
+	ptr := model.DiskPointer{NodeId: nodeId, FileName: string(model.NewBlockId())}
+	write := func(version model.Version, expected model.Version) model.WriteResult {
+		mgrDiskWrites <- model.WriteRequest{
+			Caller:      nodeId,
+			Data:        model.RawData{Ptr: ptr, Data: []byte{byte(version)}, Version: version},
+			Conditional: true,
+			Expected:    expected,
+		}
+		return <-diskMgrWrites
+	}
+
+	if result := write(2, 0); !result.Ok {
+		t.Error("a new block should be written", result.Message)
+		return
+	}
+	if result := write(3, 0); result.Ok || !result.Conflict {
+		t.Error("a write based on a missing block should conflict", result)
+		return
+	}
+	if result := write(3, 2); !result.Ok {
+		t.Error("a write based on the saved version should be written", result.Message)
+		return
+	}
+	if result := write(4, 2); result.Ok || !result.Conflict {
+		t.Error("a write based on an older version should conflict", result)
+		return
+	}
+}
+
+func TestInventory(t *testing.T) {
+	f, path, nodeId, mgrDiskWrites, _, diskMgrWrites, _, _, mgrDiskInventories, diskMgrInventories := newDiskService()
+	blockId := model.NewBlockId()
+	mgrDiskWrites <- model.WriteRequest{
+		Caller: nodeId,
+		Data: model.RawData{
+			Ptr:  model.DiskPointer{NodeId: nodeId, FileName: string(blockId)},
+			Data: []byte{0, 1, 2},
+		},
+	}
+	<-diskMgrWrites
+	_ = f.WriteFile(filepath.Join(path.String(), "cluster.json"), []byte{})
+
+	mgrDiskInventories <- model.InventoryRequest{ReqId: "reqId"}
+	inventory := <-diskMgrInventories
+	if inventory.Err != nil || inventory.ReqId != "reqId" {
+		t.Error("unexpected inventory", inventory)
+		return
+	}
+	sum := sha256.Sum256([]byte{0, 1, 2})
+	if len(inventory.Blocks) != 1 || inventory.Blocks[0].BlockId != blockId || !bytes.Equal(inventory.Blocks[0].Checksum, sum[:]) {
+		t.Error("expected only the block in the inventory", inventory.Blocks)
+		return
+	}
+}
+
+func TestHints(t *testing.T) {
+	f := disk.MockFileOps{}
+	path := disk.NewPath("/some/fake/path", &f)
+	holder := model.NewNodeId()
+	owner := model.DiskPointer{NodeId: model.NewNodeId(), FileName: string(model.NewBlockId())}
+	other := model.DiskPointer{NodeId: model.NewNodeId(), FileName: string(model.NewBlockId())}
+	for _, ptr := range []model.DiskPointer{owner, other} {
+		err := path.Save(model.RawData{Ptr: model.HintPointer(holder, ptr), Data: []byte{1, 2}, Version: 3})
+		if err != nil {
+			t.Error("error saving hint", err)
+			return
+		}
+	}
+
+	blocks, err := path.Inventory()
+	if err != nil || len(blocks) != 0 {
+		t.Error("expected hints to stay out of the inventory", blocks, err)
+		return
+	}
+	hints, err := path.Hints(owner.NodeId)
+	if err != nil || len(hints) != 1 || hints[0].Version != 3 || !bytes.Equal(hints[0].Data, []byte{1, 2}) {
+		t.Error("expected the owner's hint", hints, err)
+		return
+	}
+	hinted, ok := model.HintedPointer(hints[0].Ptr.FileName)
+	if !ok || hinted != owner {
+		t.Error("expected the hint to name its owner", hints[0].Ptr)
 		return
 	}
+
+	err = path.DeleteHint(hints[0].Ptr)
+	if err != nil {
+		t.Error("error deleting hint", err)
+		return
+	}
+	hints, err = path.Hints(owner.NodeId)
+	if err != nil || len(hints) != 0 {
+		t.Error("expected the hint to be gone", hints, err)
+		return
+	}
+	if hints, _ = path.Hints(other.NodeId); len(hints) != 1 {
+		t.Error("expected the other hint to stay", hints)
+	}
+}
+
+func TestAllHints(t *testing.T) {
+	f := disk.MockFileOps{}
+	path := disk.NewPath("/some/fake/path", &f)
+	owner := model.DiskPointer{NodeId: model.NewNodeId(), FileName: string(model.NewBlockId())}
+	err := path.Save(model.RawData{Ptr: model.HintPointer(model.NewNodeId(), owner), Data: []byte{1, 2}, Version: 3})
+	if err != nil {
+		t.Error("error saving hint", err)
+		return
+	}
+	inHints := make(chan model.HintsRequest)
+	outHints := make(chan model.Hints)
+	_ = disk.New(path, model.NewNodeId(), make(chan model.WriteRequest), make(chan model.ReadRequest),
+		make(chan model.WriteResult), make(chan model.ReadResult), make(chan model.InventoryRequest), make(chan model.Inventory),
+		inHints, outHints, make(chan model.DeleteHint))
+
+	req := model.HintsRequest{ReqId: model.NewReqId()}
+	inHints <- req
+	hints := <-outHints
+	if hints.ReqId != req.ReqId || hints.Owner != owner.NodeId || len(hints.Blocks) != 1 || hints.Err != nil {
+		t.Error("expected the hints kept for the owner", hints)
+	}
+}
+
+func TestWriteSecretFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "secret")
+	ops := disk.DiskFileOps{}
+	err := ops.WriteFile(name, []byte("old"))
+	if err != nil {
+		t.Error("error writing file", err)
+		return
+	}
+	err = ops.WriteSecretFile(name, []byte("secret"))
+	if err != nil {
+		t.Error("error writing secret", err)
+		return
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Error("error reading secret", err)
+		return
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("expected only the owner to be able to read the secret", info.Mode())
+	}
 }
 
-func newDiskService() (*disk.MockFileOps, disk.Path, model.NodeId, chan model.WriteRequest, chan model.ReadRequest, chan model.WriteResult, chan model.ReadResult, disk.Disk) {
+func newDiskService() (*disk.MockFileOps, disk.Path, model.NodeId, chan model.WriteRequest, chan model.ReadRequest, chan model.WriteResult, chan model.ReadResult, disk.Disk, chan model.InventoryRequest, chan model.Inventory) {
 	f := disk.MockFileOps{}
 	path := disk.NewPath("/some/fake/path", &f)
 	id := model.NewNodeId()
@@ -117,6 +328,9 @@ func newDiskService() (*disk.MockFileOps, disk.Path, model.NodeId, chan model.Wr
 	mgrDiskReads := make(chan model.ReadRequest)
 	diskMgrWrites := make(chan model.WriteResult)
 	diskMgrReads := make(chan model.ReadResult)
-	d := disk.New(path, id, mgrDiskWrites, mgrDiskReads, diskMgrWrites, diskMgrReads)
-	return &f, path, id, mgrDiskWrites, mgrDiskReads, diskMgrWrites, diskMgrReads, d
+	mgrDiskInventories := make(chan model.InventoryRequest)
+	diskMgrInventories := make(chan model.Inventory)
+	d := disk.New(path, id, mgrDiskWrites, mgrDiskReads, diskMgrWrites, diskMgrReads, mgrDiskInventories, diskMgrInventories,
+		make(chan model.HintsRequest), make(chan model.Hints), make(chan model.DeleteHint))
+	return &f, path, id, mgrDiskWrites, mgrDiskReads, diskMgrWrites, diskMgrReads, d, mgrDiskInventories, diskMgrInventories
 }
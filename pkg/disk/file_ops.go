@@ -14,11 +14,19 @@
 
 package disk
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+	"slices"
+)
 
 type FileOps interface {
 	ReadFile(name string) ([]byte, error)
 	WriteFile(name string, data []byte) error
+	// WriteSecretFile is WriteFile for files only this user may read
+	WriteSecretFile(name string, data []byte) error
+	ReadDir(name string) ([]string, error)
+	Remove(name string) error
 }
 
 type DiskFileOps struct{}
@@ -31,6 +39,34 @@ func (d *DiskFileOps) WriteFile(name string, data []byte) error {
 	return os.WriteFile(name, data, 0644)
 }
 
+func (d *DiskFileOps) WriteSecretFile(name string, data []byte) error {
+	err := os.WriteFile(name, data, 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of a file that's already there
+	return os.Chmod(name, 0600)
+}
+
+func (d *DiskFileOps) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// ReadDir returns the names of the files in a directory
+func (d *DiskFileOps) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
 type MockFileOps struct {
 	ReadError  error
 	WriteError error
@@ -60,3 +96,32 @@ func (m *MockFileOps) WriteFile(name string, data []byte) error {
 	m.mockFS[name] = data
 	return nil
 }
+
+func (m *MockFileOps) WriteSecretFile(name string, data []byte) error {
+	return m.WriteFile(name, data)
+}
+
+func (m *MockFileOps) Remove(name string) error {
+	if m.WriteError != nil {
+		return m.WriteError
+	}
+	if _, ok := m.mockFS[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.mockFS, name)
+	return nil
+}
+
+func (m *MockFileOps) ReadDir(name string) ([]string, error) {
+	if m.ReadError != nil {
+		return nil, m.ReadError
+	}
+	names := []string{}
+	for path := range m.mockFS {
+		if filepath.Dir(path) == filepath.Clean(name) {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"slices"
+	"strings"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// blocks are split into ranges by the hash of their id, only the ranges
+// whose hashes differ between two nodes are listed in full
+const antiEntropyRanges = 64
+
+// antiEntropySession is one exchange with a peer. Both sides keep the
+// blocks they should share with the other until the lists of the ranges
+// that differ have been swapped.
+type antiEntropySession struct {
+	peer      model.NodeId
+	initiator bool
+	// remoteHashes are the peer's range hashes when the peer started it
+	remoteHashes [][]byte
+	blocks       []model.BlockSummary
+	deadline     time.Time
+}
+
+func blockRange(id model.BlockId) uint32 {
+	sum := sha256.Sum256([]byte(id))
+	return uint32(sum[0]) % antiEntropyRanges
+}
+
+func rangeHashes(blocks []model.BlockSummary) [][]byte {
+	byRange := make([][]model.BlockSummary, antiEntropyRanges)
+	for _, b := range blocks {
+		r := blockRange(b.BlockId)
+		byRange[r] = append(byRange[r], b)
+	}
+	hashes := make([][]byte, antiEntropyRanges)
+	for i, inRange := range byRange {
+		slices.SortFunc(inRange, func(a model.BlockSummary, b model.BlockSummary) int {
+			return strings.Compare(string(a.BlockId), string(b.BlockId))
+		})
+		h := sha256.New()
+		for _, b := range inRange {
+			h.Write(b.ToBytes())
+		}
+		hashes[i] = h.Sum(nil)
+	}
+	return hashes
+}
+
+func differingRanges(local [][]byte, remote [][]byte) []uint32 {
+	result := []uint32{}
+	for i := range local {
+		if i >= len(remote) || !bytes.Equal(local[i], remote[i]) {
+			result = append(result, uint32(i))
+		}
+	}
+	return result
+}
+
+func blocksInRanges(blocks []model.BlockSummary, ranges []uint32) []model.BlockSummary {
+	result := []model.BlockSummary{}
+	for _, b := range blocks {
+		if slices.Contains(ranges, blockRange(b.BlockId)) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// outdatedIn returns the blocks in the given ranges that remote is missing
+// or holds an older version of
+func outdatedIn(local []model.BlockSummary, remote []model.BlockSummary, ranges []uint32) []model.BlockId {
+	versions := make(map[model.BlockId]model.Version)
+	for _, b := range remote {
+		versions[b.BlockId] = b.Version
+	}
+	result := []model.BlockId{}
+	for _, b := range blocksInRanges(local, ranges) {
+		if v, has := versions[b.BlockId]; !has || v < b.Version {
+			result = append(result, b.BlockId)
+		}
+	}
+	return result
+}
+
+type antiEntropySessions map[model.ReqId]*antiEntropySession
+
+func (a antiEntropySessions) expire(now time.Time) {
+	for id, s := range a {
+		if now.After(s.deadline) {
+			delete(a, id)
+		}
+	}
+}
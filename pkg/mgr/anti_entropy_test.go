@@ -0,0 +1,55 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"slices"
+	"tealfs/pkg/model"
+	"testing"
+)
+
+func TestRangeHashes(t *testing.T) {
+	b1 := model.BlockSummary{BlockId: model.NewBlockId(), Checksum: []byte{1}}
+	b2 := model.BlockSummary{BlockId: model.NewBlockId(), Checksum: []byte{2}}
+	changed := model.BlockSummary{BlockId: b2.BlockId, Checksum: []byte{3}}
+
+	local := rangeHashes([]model.BlockSummary{b1, b2})
+	if len(differingRanges(local, rangeHashes([]model.BlockSummary{b2, b1}))) != 0 {
+		t.Error("order of blocks should not matter")
+		return
+	}
+	ranges := differingRanges(local, rangeHashes([]model.BlockSummary{b1, changed}))
+	if !slices.Equal(ranges, []uint32{blockRange(b2.BlockId)}) {
+		t.Error("expected only the range of the changed block to differ", ranges)
+		return
+	}
+	ranges = differingRanges(local, rangeHashes([]model.BlockSummary{b1}))
+	missing := outdatedIn([]model.BlockSummary{b1, b2}, blocksInRanges([]model.BlockSummary{b1}, ranges), ranges)
+	if !slices.Equal(missing, []model.BlockId{b2.BlockId}) {
+		t.Error("expected the block to be missing", missing)
+		return
+	}
+
+	newer := model.BlockSummary{BlockId: b2.BlockId, Checksum: []byte{3}, Version: 2}
+	ranges = differingRanges(rangeHashes([]model.BlockSummary{newer}), rangeHashes([]model.BlockSummary{b2}))
+	if outdated := outdatedIn([]model.BlockSummary{newer}, []model.BlockSummary{b2}, ranges); !slices.Equal(outdated, []model.BlockId{b2.BlockId}) {
+		t.Error("expected the older version to be outdated", outdated)
+		return
+	}
+	if outdated := outdatedIn([]model.BlockSummary{b2}, []model.BlockSummary{newer}, ranges); len(outdated) != 0 {
+		t.Error("a newer version should not be overwritten", outdated)
+		return
+	}
+}
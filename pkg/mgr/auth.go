@@ -0,0 +1,168 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"tealfs/pkg/disk"
+	"tealfs/pkg/model"
+	"time"
+)
+
+const secretSize = 32
+const joinTokenLifetime = time.Hour
+
+type joinToken struct {
+	id     string
+	secret []byte
+}
+
+func newJoinToken() (joinToken, error) {
+	id, err := randomBytes(8)
+	if err != nil {
+		return joinToken{}, err
+	}
+	secret, err := randomBytes(secretSize)
+	if err != nil {
+		return joinToken{}, err
+	}
+	return joinToken{id: hex.EncodeToString(id), secret: secret}, nil
+}
+
+func (j *joinToken) String() string {
+	return j.id + "." + hex.EncodeToString(j.secret)
+}
+
+func parseJoinToken(value string) (joinToken, error) {
+	id, rawSecret, found := strings.Cut(strings.TrimSpace(value), ".")
+	if !found || id == "" {
+		return joinToken{}, errors.New("invalid join token")
+	}
+	secret, err := hex.DecodeString(rawSecret)
+	if err != nil || len(secret) != secretSize {
+		return joinToken{}, errors.New("invalid join token")
+	}
+	return joinToken{id: id, secret: secret}, nil
+}
+
+type issuedJoinToken struct {
+	secret  []byte
+	expires time.Time
+}
+
+// pendingAuth tracks a connection from the time the peer sends IAm until
+// it has proven it knows the cluster secret or a join token
+type pendingAuth struct {
+	iam          *model.IAm
+	nonce        []byte
+	peerNonce    []byte
+	joinToken    *joinToken
+	stashedProof *model.AuthProof
+}
+
+func readClusterSecret(savePath string, fileOps disk.FileOps) ([]byte, error) {
+	data, err := fileOps.ReadFile(filepath.Join(savePath, "cluster_secret"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			secret, err := randomBytes(secretSize)
+			if err != nil {
+				return nil, err
+			}
+			err = saveClusterSecret(savePath, fileOps, secret)
+			if err != nil {
+				return nil, err
+			}
+			return secret, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func saveClusterSecret(savePath string, fileOps disk.FileOps, secret []byte) error {
+	return fileOps.WriteSecretFile(filepath.Join(savePath, "cluster_secret"), secret)
+}
+
+// ReadAdminPassword reads the password the ui asks for before it hands out
+// join tokens, and webdav before it changes snapshots, versions or the
+// trash. A new one is made the first time and printed once so the operator
+// can log in, after that it's only in <savePath>/admin_password
+func ReadAdminPassword(savePath string, fileOps disk.FileOps) (string, error) {
+	data, err := fileOps.ReadFile(filepath.Join(savePath, "admin_password"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			raw, err := randomBytes(16)
+			if err != nil {
+				return "", err
+			}
+			password := hex.EncodeToString(raw)
+			path := filepath.Join(savePath, "admin_password")
+			err = fileOps.WriteSecretFile(path, []byte(password))
+			if err != nil {
+				return "", err
+			}
+			fmt.Println("generated admin password", password, "- it is kept in", path)
+			return password, nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// authMac is the responder's answer to the challenger's nonce. Both ids are
+// covered so a proof can't be bounced back to the node that asked for it.
+func authMac(key []byte, nonce []byte, challenger model.NodeId, responder model.NodeId) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("tealfs auth"))
+	mac.Write(nonce)
+	mac.Write(model.StringToBytes(string(challenger)))
+	mac.Write(model.StringToBytes(string(responder)))
+	return mac.Sum(nil)
+}
+
+func validAuthMac(key []byte, nonce []byte, challenger model.NodeId, responder model.NodeId, proof []byte) bool {
+	return hmac.Equal(authMac(key, nonce, challenger, responder), proof)
+}
+
+// wrapSecret xors the cluster secret with a key only holders of the join
+// token can derive. Unwrapping is the same operation.
+func wrapSecret(secret []byte, tokenSecret []byte, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, tokenSecret)
+	mac.Write([]byte("tealfs join"))
+	mac.Write(nonce)
+	key := mac.Sum(nil)
+	result := make([]byte, len(secret))
+	for i := range secret {
+		result[i] = secret[i] ^ key[i%len(key)]
+	}
+	return result
+}
+
+func randomBytes(size int) ([]byte, error) {
+	result := make([]byte, size)
+	_, err := rand.Read(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
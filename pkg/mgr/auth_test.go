@@ -0,0 +1,316 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"bytes"
+	"path/filepath"
+	"tealfs/pkg/disk"
+	"tealfs/pkg/model"
+	"testing"
+)
+
+func TestJoinTokenRoundTrip(t *testing.T) {
+	token, err := newJoinToken()
+	if err != nil {
+		t.Error("error creating token", err)
+		return
+	}
+	parsed, err := parseJoinToken(token.String())
+	if err != nil {
+		t.Error("error parsing token", err)
+		return
+	}
+	if parsed.id != token.id || !bytes.Equal(parsed.secret, token.secret) {
+		t.Error("parsed token is different")
+		return
+	}
+	_, err = parseJoinToken("not-a-token")
+	if err == nil {
+		t.Error("should not parse an invalid token")
+		return
+	}
+}
+
+func TestWrapSecret(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	tokenSecret := []byte("some token secret")
+	nonce := []byte("some nonce")
+	wrapped := wrapSecret(secret, tokenSecret, nonce)
+	if bytes.Equal(wrapped, secret) {
+		t.Error("secret should be wrapped")
+		return
+	}
+	if !bytes.Equal(wrapSecret(wrapped, tokenSecret, nonce), secret) {
+		t.Error("unwrapped secret is different")
+		return
+	}
+}
+
+func TestAcceptJoinToken(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{}, 0, t)
+	resp := make(chan string)
+	m.UiMgrJoinTokens <- model.UiMgrJoinToken{Resp: resp}
+	token, err := parseJoinToken(<-resp)
+	if err != nil {
+		t.Error("error parsing issued token", err)
+		return
+	}
+
+	joiner := model.NewNodeId()
+	nonce := startHandshake(m, 1, joiner)
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: 1,
+		Payload: &model.AuthProof{
+			NodeId:  joiner,
+			TokenId: token.id,
+			Mac:     authMac(token.secret, nonce, m.NodeId, joiner),
+		},
+	}
+
+	send := <-m.MgrConnsSends
+	accept, ok := send.Payload.(*model.JoinAccept)
+	if !ok {
+		t.Error("expected join accept", send.Payload)
+		return
+	}
+	if !bytes.Equal(wrapSecret(accept.WrappedSecret, token.secret, nonce), m.clusterSecret) {
+		t.Error("join accept should carry the cluster secret")
+		return
+	}
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Connected || status.Id != joiner {
+		t.Error("expected joiner to be connected", status)
+		return
+	}
+	<-m.MgrConnsSends
+
+	// tokens only work once
+	second := model.NewNodeId()
+	nonce = startHandshake(m, 2, second)
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: 2,
+		Payload: &model.AuthProof{
+			NodeId:  second,
+			TokenId: token.id,
+			Mac:     authMac(token.secret, nonce, m.NodeId, second),
+		},
+	}
+	status = <-m.MgrUiStatuses
+	if status.Type != model.Refused {
+		t.Error("expected reused token to be refused", status)
+		return
+	}
+	<-m.MgrConnsDisconnects
+}
+
+func TestRefuseWrongSecret(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{}, 0, t)
+	remote := model.NewNodeId()
+	nonce := startHandshake(m, 1, remote)
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: 1,
+		Payload: &model.AuthProof{
+			NodeId: remote,
+			Mac:    authMac([]byte("wrong secret"), nonce, m.NodeId, remote),
+		},
+	}
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Refused {
+		t.Error("expected connection to be refused", status)
+		return
+	}
+	disconnect := <-m.MgrConnsDisconnects
+	if disconnect.ConnId != 1 {
+		t.Error("expected conn 1 to be disconnected")
+		return
+	}
+}
+
+func TestRefusePeerClaimingOurNodeId(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{}, 0, t)
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{Type: model.Connected, Id: 1}
+	<-m.MgrConnsSends
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: 1,
+		Payload: &model.IAm{
+			NodeId:      m.NodeId,
+			Address:     "remote:123",
+			FreeBytes:   1,
+			ProtocolMin: model.ProtocolVersionMin,
+			ProtocolMax: model.ProtocolVersionMax,
+			Features:    model.SupportedFeatures,
+		},
+	}
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Refused {
+		t.Error("expected connection to be refused", status)
+		return
+	}
+	<-m.MgrConnsDisconnects
+}
+
+func TestRefuseReflectedProof(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{}, 0, t)
+	remote := model.NewNodeId()
+	nonce := startHandshake(m, 1, remote)
+
+	// the peer hands our own challenge back to get it signed
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  1,
+		Payload: &model.AuthChallenge{Nonce: nonce},
+	}
+	signed := (<-m.MgrConnsSends).Payload.(*model.AuthProof)
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  1,
+		Payload: &model.AuthProof{NodeId: remote, Mac: signed.Mac},
+	}
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Refused {
+		t.Error("expected connection to be refused", status)
+		return
+	}
+	<-m.MgrConnsDisconnects
+}
+
+func TestJoinWithToken(t *testing.T) {
+	const remoteAddress = "remote:123"
+	m := NewWithChanSize(0, "dummyAddress", "dummyPath", &disk.MockFileOps{}, model.Mirrored, 1)
+	m.Config.HeartbeatInterval = 0
+	err := startMgr(m)
+	if err != nil {
+		t.Error("Error starting", err)
+		return
+	}
+	token, _ := newJoinToken()
+	remoteSecret := []byte("0123456789abcdef0123456789abcdef")
+	remote := model.NewNodeId()
+
+	m.UiMgrConnectTos <- model.UiMgrConnectTo{Address: remoteAddress, JoinToken: token.String()}
+	<-m.MgrConnsConnectTos
+	nonce := startHandshakeWithAddress(m, 1, remote, remoteAddress)
+
+	remoteNonce := []byte("remote nonce")
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  1,
+		Payload: &model.AuthChallenge{Nonce: remoteNonce},
+	}
+	send := <-m.MgrConnsSends
+	proof, ok := send.Payload.(*model.AuthProof)
+	if !ok || proof.TokenId != token.id || !validAuthMac(token.secret, remoteNonce, remote, m.NodeId, proof.Mac) {
+		t.Error("expected proof made with the join token", send.Payload)
+		return
+	}
+
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: 1,
+		Payload: &model.AuthProof{
+			NodeId: remote,
+			Mac:    authMac(remoteSecret, nonce, m.NodeId, remote),
+		},
+	}
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  1,
+		Payload: &model.JoinAccept{WrappedSecret: wrapSecret(remoteSecret, token.secret, remoteNonce)},
+	}
+
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Connected || status.Id != remote {
+		t.Error("expected remote to be connected", status)
+		return
+	}
+	if !bytes.Equal(m.clusterSecret, remoteSecret) {
+		t.Error("should have adopted the cluster secret")
+		return
+	}
+}
+
+func TestJoinRefusesPeerThatCannotProveSecret(t *testing.T) {
+	const remoteAddress = "remote:123"
+	fileOps := &disk.MockFileOps{}
+	m := NewWithChanSize(0, "dummyAddress", "dummyPath", fileOps, model.Mirrored, 1)
+	m.Config.HeartbeatInterval = 0
+	err := startMgr(m)
+	if err != nil {
+		t.Error("Error starting", err)
+		return
+	}
+	ourSecret := m.clusterSecret
+	token, _ := newJoinToken()
+	remote := model.NewNodeId()
+
+	m.UiMgrConnectTos <- model.UiMgrConnectTo{Address: remoteAddress, JoinToken: token.String()}
+	<-m.MgrConnsConnectTos
+	nonce := startHandshakeWithAddress(m, 1, remote, remoteAddress)
+	remoteNonce := []byte("remote nonce")
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  1,
+		Payload: &model.AuthChallenge{Nonce: remoteNonce},
+	}
+	<-m.MgrConnsSends
+
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: 1,
+		Payload: &model.AuthProof{
+			NodeId: remote,
+			Mac:    authMac([]byte("some other secret"), nonce, m.NodeId, remote),
+		},
+	}
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  1,
+		Payload: &model.JoinAccept{WrappedSecret: wrapSecret([]byte("0123456789abcdef0123456789abcdef"), token.secret, remoteNonce)},
+	}
+
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Refused {
+		t.Error("expected connection to be refused", status)
+		return
+	}
+	if !bytes.Equal(m.clusterSecret, ourSecret) {
+		t.Error("should have kept our cluster secret")
+		return
+	}
+	saved, err := fileOps.ReadFile(filepath.Join("dummyPath", "cluster_secret"))
+	if err == nil && !bytes.Equal(saved, ourSecret) {
+		t.Error("should not have saved the unproven secret")
+		return
+	}
+}
+
+func startHandshake(m *Mgr, c model.ConnId, remote model.NodeId) []byte {
+	return startHandshakeWithAddress(m, c, remote, "remote:"+string(remote))
+}
+
+func startHandshakeWithAddress(m *Mgr, c model.ConnId, remote model.NodeId, address string) []byte {
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type:    model.Connected,
+		Id:      c,
+		Address: address,
+	}
+	<-m.MgrConnsSends
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: c,
+		Payload: &model.IAm{
+			NodeId:      remote,
+			Address:     address,
+			FreeBytes:   1,
+			ProtocolMin: model.ProtocolVersionMin,
+			ProtocolMax: model.ProtocolVersionMax,
+			Features:    model.SupportedFeatures,
+		},
+	}
+	challenge := <-m.MgrConnsSends
+	return challenge.Payload.(*model.AuthChallenge).Nonce
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"tealfs/pkg/model"
+	"time"
+)
+
+// versions keep 16 bits below the wall clock for a counter
+const clockLogicalBits = 16
+
+// hybridClock hands out block versions that follow wall time, never go
+// backwards and move past any version seen from other nodes
+type hybridClock struct {
+	last model.Version
+	now  func() time.Time
+}
+
+func newHybridClock() hybridClock {
+	return hybridClock{now: time.Now}
+}
+
+func (c *hybridClock) next() model.Version {
+	wall := model.Version(c.now().UnixMilli()) << clockLogicalBits
+	if wall > c.last {
+		c.last = wall
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+func (c *hybridClock) observe(v model.Version) {
+	if v > c.last {
+		c.last = v
+	}
+}
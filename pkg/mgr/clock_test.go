@@ -0,0 +1,45 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHybridClock(t *testing.T) {
+	now := time.UnixMilli(1000)
+	c := hybridClock{now: func() time.Time { return now }}
+
+	v1 := c.next()
+	v2 := c.next()
+	if v2 <= v1 {
+		t.Error("versions should increase within the same millisecond", v1, v2)
+		return
+	}
+
+	// a node whose clock is ahead wrote the block
+	c.observe(v2 + 1000)
+	if v3 := c.next(); v3 <= v2+1000 {
+		t.Error("versions should move past observed versions", v3)
+		return
+	}
+
+	now = time.UnixMilli(5000)
+	if v4 := c.next(); v4 != 5000<<clockLogicalBits {
+		t.Error("versions should follow the wall clock", v4)
+		return
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import "time"
+
+type Config struct {
+	// HeartbeatInterval is how often peers are sent a heartbeat, zero turns them off
+	HeartbeatInterval time.Duration
+	// SuspectPhi is the phi at which a quiet peer is reported as suspect
+	SuspectPhi float64
+	// DeadPhi is the phi at which a quiet peer is reported dead and disconnected
+	DeadPhi float64
+	// ReconnectBase is the backoff before the first attempt to redial a lost peer
+	ReconnectBase time.Duration
+	// ReconnectMax caps the backoff between redial attempts
+	ReconnectMax time.Duration
+	// RequestTimeout is how long a replica has to answer a block read or write
+	// before the read moves on to the next replica or the write fails
+	RequestTimeout time.Duration
+	// HedgeDelay is how long a block read waits before also asking a second
+	// replica, until there is enough history to use the replica's p95
+	// latency instead. Zero turns hedged reads off
+	HedgeDelay time.Duration
+	// WriteQuorum is how many replicas must be durable before a block write
+	// succeeds, the others finish in the background. Zero means half of
+	// them rounded up, so a write goes through while a replica is down and
+	// the copy meant for it is kept as a hint. Conditional writes always
+	// wait for a majority, half is enough for plain writes only because the
+	// newest version wins wherever they meet
+	WriteQuorum int
+	// ReadQuorum is how many replicas a block read waits for, the highest
+	// version among their answers is returned. Zero means enough to overlap
+	// the write quorum, so a read sees the latest write
+	ReadQuorum int
+	// ReadRepairChance is the fraction of block reads that also check the
+	// other replicas and rewrite any that are missing or differ. Replicas
+	// that fail a read are always rewritten
+	ReadRepairChance float64
+	// AntiEntropyInterval is how often this node compares the blocks it
+	// holds with a peer and pushes the ones the peer is missing. Zero turns
+	// it off
+	AntiEntropyInterval time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		HeartbeatInterval:   time.Second,
+		SuspectPhi:          5,
+		DeadPhi:             12,
+		ReconnectBase:       500 * time.Millisecond,
+		ReconnectMax:        time.Minute,
+		RequestTimeout:      10 * time.Second,
+		HedgeDelay:          50 * time.Millisecond,
+		ReadRepairChance:    0.1,
+		AntiEntropyInterval: time.Minute,
+	}
+}
+
+func (c Config) writeQuorum(replicas int) int {
+	if c.WriteQuorum <= 0 {
+		return max(1, (replicas+1)/2)
+	}
+	return min(c.WriteQuorum, replicas)
+}
+
+// conditionalWriteQuorum is a strict majority of the replicas, or the write
+// quorum if that's larger. Each replica accepts only one of the writes based
+// on the same version, so two of them can't both reach a majority
+func (c Config) conditionalWriteQuorum(replicas int) int {
+	return max(c.writeQuorum(replicas), replicas/2+1)
+}
+
+func (c Config) readQuorum(replicas int) int {
+	if c.ReadQuorum <= 0 {
+		return max(1, replicas-c.writeQuorum(replicas)+1)
+	}
+	return min(c.ReadQuorum, replicas)
+}
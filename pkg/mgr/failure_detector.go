@@ -0,0 +1,146 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"math"
+	"tealfs/pkg/model"
+	"time"
+)
+
+type peerState int
+
+const (
+	alive peerState = iota
+	suspect
+	dead
+)
+
+const arrivalWindowSize = 100
+
+// failureDetector is a phi accrual failure detector. Rather than a yes or
+// no answer it tracks how surprising the silence from each peer is given
+// the heartbeat intervals seen so far.
+type failureDetector struct {
+	interval time.Duration
+	peers    map[model.NodeId]*arrivalWindow
+}
+
+type arrivalWindow struct {
+	last      time.Time
+	intervals []float64
+	state     peerState
+}
+
+func newFailureDetector(interval time.Duration) failureDetector {
+	return failureDetector{
+		interval: interval,
+		peers:    make(map[model.NodeId]*arrivalWindow),
+	}
+}
+
+func (f *failureDetector) add(node model.NodeId, now time.Time) {
+	f.peers[node] = &arrivalWindow{last: now, state: alive}
+}
+
+func (f *failureDetector) remove(node model.NodeId) {
+	delete(f.peers, node)
+}
+
+// heartbeat records that we heard from a peer and reports whether that
+// brought it back from being suspected
+func (f *failureDetector) heartbeat(node model.NodeId, now time.Time) bool {
+	w, ok := f.peers[node]
+	if !ok {
+		return false
+	}
+	interval := now.Sub(w.last).Seconds()
+	w.last = now
+	w.intervals = append(w.intervals, interval)
+	if len(w.intervals) > arrivalWindowSize {
+		w.intervals = w.intervals[1:]
+	}
+	recovered := w.state == suspect
+	w.state = alive
+	return recovered
+}
+
+func (f *failureDetector) phi(node model.NodeId, now time.Time) float64 {
+	w, ok := f.peers[node]
+	if !ok {
+		return 0
+	}
+	mean, stdDev := f.stats(w)
+	elapsed := now.Sub(w.last).Seconds()
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1.0 + e))
+	}
+	return -math.Log10(1.0 - 1.0/(1.0+e))
+}
+
+// stats falls back to the configured interval until there is some history
+// and keeps the deviation from collapsing on a very regular network
+func (f *failureDetector) stats(w *arrivalWindow) (float64, float64) {
+	expected := f.interval.Seconds()
+	if len(w.intervals) == 0 {
+		return expected, expected / 2
+	}
+	sum := 0.0
+	for _, i := range w.intervals {
+		sum += i
+	}
+	mean := sum / float64(len(w.intervals))
+	variance := 0.0
+	for _, i := range w.intervals {
+		variance += (i - mean) * (i - mean)
+	}
+	stdDev := math.Sqrt(variance / float64(len(w.intervals)))
+	return mean, max(stdDev, expected/2)
+}
+
+type stateChange struct {
+	node  model.NodeId
+	state peerState
+	phi   float64
+}
+
+// check moves peers between alive, suspect and dead and returns the ones that changed
+func (f *failureDetector) check(now time.Time, suspectPhi float64, deadPhi float64) []stateChange {
+	changes := []stateChange{}
+	for node, w := range f.peers {
+		phi := f.phi(node, now)
+		newState := w.state
+		switch {
+		case phi >= deadPhi:
+			newState = dead
+		case phi >= suspectPhi && w.state == alive:
+			newState = suspect
+		}
+		if newState != w.state {
+			w.state = newState
+			changes = append(changes, stateChange{node: node, state: newState, phi: phi})
+		}
+	}
+	return changes
+}
+
+func (f *failureDetector) state(node model.NodeId) peerState {
+	if w, ok := f.peers[node]; ok {
+		return w.state
+	}
+	return dead
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"tealfs/pkg/model"
+	"testing"
+	"time"
+)
+
+func TestFailureDetector(t *testing.T) {
+	interval := time.Second
+	f := newFailureDetector(interval)
+	node := model.NewNodeId()
+	now := time.Unix(1000, 0)
+	f.add(node, now)
+
+	for range 10 {
+		now = now.Add(interval)
+		f.heartbeat(node, now)
+	}
+	if changes := f.check(now.Add(interval), 5, 12); len(changes) != 0 {
+		t.Error("peer on schedule should stay alive", changes)
+		return
+	}
+
+	changes := f.check(now.Add(7*interval/2), 5, 12)
+	if len(changes) != 1 || changes[0].state != suspect {
+		t.Error("expected peer to be suspect", changes)
+		return
+	}
+	if !f.heartbeat(node, now.Add(7*interval/2)) {
+		t.Error("heartbeat should recover a suspect peer")
+		return
+	}
+	if f.state(node) != alive {
+		t.Error("expected peer to be alive")
+		return
+	}
+
+	now = now.Add(7 * interval / 2)
+	changes = f.check(now.Add(10*interval), 5, 12)
+	if len(changes) != 1 || changes[0].state != dead {
+		t.Error("expected peer to be dead", changes)
+		return
+	}
+	if changes := f.check(now.Add(20*interval), 5, 12); len(changes) != 0 {
+		t.Error("dead peer should only be reported once", changes)
+		return
+	}
+}
+
+func TestFailureDetectorUnknownPeer(t *testing.T) {
+	f := newFailureDetector(time.Second)
+	node := model.NewNodeId()
+	if f.heartbeat(node, time.Now()) {
+		t.Error("unknown peer should not recover")
+	}
+	if f.state(node) != dead {
+		t.Error("unknown peer should be treated as dead")
+	}
+}
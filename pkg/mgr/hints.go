@@ -0,0 +1,142 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"tealfs/pkg/disk"
+	"tealfs/pkg/model"
+	"tealfs/pkg/set"
+)
+
+// handOff keeps the write for an offline replica as a hint on another node
+// that's up, which replays it to the replica's node once that's back
+func (m *Mgr) handOff(data model.RawData) error {
+	holder, ok := m.hintHolder(data.Ptr)
+	if !ok {
+		return errors.New("no node to keep a hint on")
+	}
+	w := model.WriteRequest{
+		Caller: m.NodeId,
+		Data: model.RawData{
+			Ptr:     model.HintPointer(holder, data.Ptr),
+			Data:    data.Data,
+			Version: data.Version,
+		},
+		ReqId: model.NewReqId(),
+	}
+	if holder == m.NodeId {
+		m.MgrDiskWrites <- w
+		return nil
+	}
+	c, ok := m.nodeConnMap.Get1(holder)
+	if !ok {
+		return errors.New("no connection to hint holder " + string(holder))
+	}
+	m.MgrConnsSends <- model.MgrConnsSend{ConnId: c, Payload: &w}
+	return nil
+}
+
+// hintHolder picks the node to keep a hint for ptr on. A connected node
+// that holds no replica of the block comes first, so losing one node
+// doesn't lose both, then this node, then any other connected node.
+func (m *Mgr) hintHolder(ptr model.DiskPointer) (model.NodeId, bool) {
+	replicas := set.NewSet[model.NodeId]()
+	for _, p := range m.mirrorDistributer.PointersForId(model.BlockId(ptr.FileName)) {
+		replicas.Add(p.NodeId)
+	}
+	candidates := []model.NodeId{}
+	for node := range m.nodesAddressMap {
+		c, ok := m.nodeConnMap.Get1(node)
+		if ok && node != ptr.NodeId && m.connProtocols[c].Features.Has(model.FeatureHints) {
+			candidates = append(candidates, node)
+		}
+	}
+	slices.Sort(candidates)
+	for _, node := range candidates {
+		if !replicas.Contains(node) {
+			return node, true
+		}
+	}
+	if ptr.NodeId != m.NodeId {
+		return m.NodeId, true
+	}
+	if len(candidates) > 0 {
+		return candidates[0], true
+	}
+	return "", false
+}
+
+// hintKept notes a hint saved on this node, it's replayed right away when
+// its owner is connected
+func (m *Mgr) hintKept(ptr model.DiskPointer) {
+	hinted, ok := model.HintedPointer(ptr.FileName)
+	if !ok {
+		return
+	}
+	m.hintOwners[hinted.NodeId] = true
+	if _, connected := m.nodeConnMap.Get1(hinted.NodeId); connected {
+		m.replayHints(hinted.NodeId)
+	}
+}
+
+func (m *Mgr) replayHints(node model.NodeId) {
+	m.MgrDiskHints <- model.HintsRequest{ReqId: model.NewReqId(), Owner: node}
+}
+
+func (m *Mgr) handleHints(h model.Hints) {
+	if h.Err != nil {
+		fmt.Println("error reading hints for", h.Owner+":", h.Err)
+		return
+	}
+	if len(h.Blocks) == 0 {
+		delete(m.hintOwners, h.Owner)
+		return
+	}
+	m.hintOwners[h.Owner] = true
+	c, ok := m.nodeConnMap.Get1(h.Owner)
+	if !ok {
+		return
+	}
+	for _, block := range h.Blocks {
+		hinted, ok := model.HintedPointer(block.Ptr.FileName)
+		if !ok {
+			continue
+		}
+		id := model.NewReqId()
+		m.hintReplays[id] = model.DiskPointer{NodeId: m.NodeId, FileName: block.Ptr.FileName}
+		m.MgrConnsSends <- model.MgrConnsSend{
+			ConnId: c,
+			Payload: &model.WriteRequest{
+				Caller: m.NodeId,
+				Data:   model.RawData{Ptr: hinted, Data: block.Data, Version: block.Version},
+				ReqId:  id,
+			},
+		}
+	}
+}
+
+// hintReplayed drops a hint its owner now has, or that the owner already
+// has a newer version for
+func (m *Mgr) hintReplayed(r model.WriteResult, hint model.DiskPointer) {
+	delete(m.hintReplays, r.ReqId)
+	if !r.Ok && r.Message != disk.ErrStaleVersion.Error() {
+		fmt.Println("replaying hint to", r.Ptr.NodeId, "failed:", r.Message)
+		return
+	}
+	m.MgrDiskHintDeletes <- model.DeleteHint{Ptr: hint}
+}
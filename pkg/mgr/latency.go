@@ -0,0 +1,85 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"slices"
+	"tealfs/pkg/model"
+	"time"
+)
+
+const (
+	latencyWindowSize = 100
+	// a node needs this many samples before its percentiles are trusted
+	minLatencySamples = 20
+	// latencies closer together than this are treated as equal when
+	// ordering replicas so noise doesn't shuffle them around
+	latencyBucket = time.Millisecond
+)
+
+// latencyStats keeps recent block read latencies for each node
+type latencyStats struct {
+	samples map[model.NodeId][]time.Duration
+}
+
+func newLatencyStats() latencyStats {
+	return latencyStats{
+		samples: make(map[model.NodeId][]time.Duration),
+	}
+}
+
+func (l *latencyStats) record(node model.NodeId, d time.Duration) {
+	s := append(l.samples[node], d)
+	if len(s) > latencyWindowSize {
+		s = s[1:]
+	}
+	l.samples[node] = s
+}
+
+// quantile returns the q quantile of the node's recent latencies and false
+// if there aren't enough samples yet
+func (l *latencyStats) quantile(node model.NodeId, q float64) (time.Duration, bool) {
+	s := l.samples[node]
+	if len(s) < minLatencySamples {
+		return 0, false
+	}
+	sorted := slices.Clone(s)
+	slices.Sort(sorted)
+	i := int(q * float64(len(sorted)-1))
+	return sorted[i], true
+}
+
+// order sorts replicas fastest first. Nodes without enough history go after
+// the measured ones, a node that just came back may still be catching up,
+// and they get measured by hedged and quorum reads in the meantime.
+func (l *latencyStats) order(ptrs []model.DiskPointer) []model.DiskPointer {
+	bucket := func(ptr model.DiskPointer) (time.Duration, bool) {
+		median, ok := l.quantile(ptr.NodeId, 0.5)
+		return median / latencyBucket, ok
+	}
+	sorted := slices.Clone(ptrs)
+	slices.SortStableFunc(sorted, func(a, b model.DiskPointer) int {
+		bucketA, measuredA := bucket(a)
+		bucketB, measuredB := bucket(b)
+		switch {
+		case measuredA && !measuredB:
+			return -1
+		case !measuredA && measuredB:
+			return 1
+		}
+		return int(bucketA - bucketB)
+	})
+	return sorted
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"tealfs/pkg/model"
+	"testing"
+	"time"
+)
+
+func TestLatencyQuantile(t *testing.T) {
+	l := newLatencyStats()
+	node := model.NewNodeId()
+	for i := 1; i < minLatencySamples; i++ {
+		l.record(node, time.Duration(i)*time.Millisecond)
+	}
+	if _, ok := l.quantile(node, 0.95); ok {
+		t.Error("should need more samples")
+		return
+	}
+	l.record(node, minLatencySamples*time.Millisecond)
+	p95, ok := l.quantile(node, 0.95)
+	if !ok || p95 != 19*time.Millisecond {
+		t.Error("unexpected p95", p95)
+		return
+	}
+	for i := 0; i < latencyWindowSize; i++ {
+		l.record(node, time.Millisecond)
+	}
+	if p95, _ := l.quantile(node, 0.95); p95 != time.Millisecond {
+		t.Error("old samples should age out", p95)
+	}
+}
+
+func TestLatencyOrder(t *testing.T) {
+	l := newLatencyStats()
+	slow := model.DiskPointer{NodeId: model.NewNodeId(), FileName: "slow"}
+	fast := model.DiskPointer{NodeId: model.NewNodeId(), FileName: "fast"}
+	unknown := model.DiskPointer{NodeId: model.NewNodeId(), FileName: "unknown"}
+	for i := 0; i < minLatencySamples; i++ {
+		l.record(slow.NodeId, 50*time.Millisecond)
+		l.record(fast.NodeId, 5*time.Millisecond)
+	}
+	ordered := l.order([]model.DiskPointer{slow, fast, unknown})
+	if ordered[0] != fast || ordered[1] != slow || ordered[2] != unknown {
+		t.Error("unexpected order", ordered)
+	}
+}
@@ -15,80 +15,136 @@
 package mgr
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"slices"
 	"tealfs/pkg/disk"
 	"tealfs/pkg/disk/dist"
 	"tealfs/pkg/model"
 	"tealfs/pkg/set"
+	"time"
 )
 
 type Mgr struct {
-	UiMgrConnectTos    chan model.UiMgrConnectTo
-	ConnsMgrStatuses   chan model.NetConnectionStatus
-	ConnsMgrReceives   chan model.ConnsMgrReceive
-	DiskMgrReads       chan model.ReadResult
-	DiskMgrWrites      chan model.WriteResult
-	WebdavMgrGets      chan model.BlockId
-	WebdavMgrPuts      chan model.Block
-	MgrConnsConnectTos chan model.MgrConnsConnectTo
-	MgrConnsSends      chan model.MgrConnsSend
-	MgrDiskWrites      chan model.WriteRequest
-	MgrDiskReads       chan model.ReadRequest
-	MgrUiStatuses      chan model.UiConnectionStatus
-	MgrWebdavGets      chan model.BlockResponse
-	MgrWebdavPuts      chan model.BlockIdResponse
-
-	nodesAddressMap    map[model.NodeId]string
-	nodeConnMap        set.Bimap[model.NodeId, model.ConnId]
-	NodeId             model.NodeId
-	connAddress        map[model.ConnId]string
-	mirrorDistributer  dist.MirrorDistributer
-	xorDistributer     dist.XorDistributer
-	blockType          model.BlockType
-	nodeAddress        string
-	savePath           string
-	fileOps            disk.FileOps
-	pendingBlockWrites pendingBlockWrites
-	freeBytes          uint32
+	UiMgrConnectTos     chan model.UiMgrConnectTo
+	UiMgrJoinTokens     chan model.UiMgrJoinToken
+	ConnsMgrStatuses    chan model.NetConnectionStatus
+	ConnsMgrReceives    chan model.ConnsMgrReceive
+	DiskMgrReads        chan model.ReadResult
+	DiskMgrWrites       chan model.WriteResult
+	DiskMgrInventories  chan model.Inventory
+	DiskMgrHints        chan model.Hints
+	WebdavMgrGets       chan model.GetBlockReq
+	WebdavMgrPuts       chan model.PutBlockReq
+	MgrConnsConnectTos  chan model.MgrConnsConnectTo
+	MgrConnsSends       chan model.MgrConnsSend
+	MgrConnsDisconnects chan model.MgrConnsDisconnect
+	MgrDiskWrites       chan model.WriteRequest
+	MgrDiskInventories  chan model.InventoryRequest
+	MgrDiskHints        chan model.HintsRequest
+	MgrDiskHintDeletes  chan model.DeleteHint
+	MgrDiskReads        chan model.ReadRequest
+	MgrUiStatuses       chan model.UiConnectionStatus
+	MgrWebdavGets       chan model.BlockResponse
+	MgrWebdavPuts       chan model.BlockIdResponse
+
+	nodesAddressMap     map[model.NodeId]string
+	nodeConnMap         set.Bimap[model.NodeId, model.ConnId]
+	NodeId              model.NodeId
+	connAddress         map[model.ConnId]string
+	connProtocols       map[model.ConnId]model.Protocol
+	pendingAuths        map[model.ConnId]*pendingAuth
+	clusterSecret       []byte
+	issuedJoinTokens    map[string]issuedJoinToken
+	heldJoinTokens      map[string]joinToken
+	mirrorDistributer   dist.MirrorDistributer
+	xorDistributer      dist.XorDistributer
+	blockType           model.BlockType
+	nodeAddress         string
+	savePath            string
+	fileOps             disk.FileOps
+	pendingBlockWrites  pendingBlockWrites
+	pendingBlockReads   pendingBlockReads
+	latencies           latencyStats
+	hintOwners          map[model.NodeId]bool
+	hintReplays         map[model.ReqId]model.DiskPointer
+	clock               hybridClock
+	repairChecks        repairChecks
+	antiEntropySessions antiEntropySessions
+	antiEntropyPushes   map[model.ReqId]model.DiskPointer
+	hedgesDue           chan model.ReqId
+	freeBytes           uint32
+	failureDetector     failureDetector
+	reconnects          reconnectScheduler
+	reconnectsDue       chan string
+	Config              Config
 }
 
 func NewWithChanSize(chanSize int, nodeAddress string, savePath string, fileOps disk.FileOps, blockType model.BlockType, freeBytes uint32) *Mgr {
-	nodeId, err := readNodeId(savePath, fileOps)
+	nodeId, err := ReadNodeId(savePath, fileOps)
+	if err != nil {
+		panic(err)
+	}
+	clusterSecret, err := readClusterSecret(savePath, fileOps)
 	if err != nil {
 		panic(err)
 	}
 
 	mgr := Mgr{
-		UiMgrConnectTos:    make(chan model.UiMgrConnectTo, chanSize),
-		ConnsMgrStatuses:   make(chan model.NetConnectionStatus, chanSize),
-		ConnsMgrReceives:   make(chan model.ConnsMgrReceive, chanSize),
-		DiskMgrWrites:      make(chan model.WriteResult),
-		DiskMgrReads:       make(chan model.ReadResult, chanSize),
-		WebdavMgrGets:      make(chan model.BlockId, chanSize),
-		WebdavMgrPuts:      make(chan model.Block, chanSize),
-		MgrConnsConnectTos: make(chan model.MgrConnsConnectTo, chanSize),
-		MgrConnsSends:      make(chan model.MgrConnsSend, chanSize),
-		MgrDiskWrites:      make(chan model.WriteRequest, chanSize),
-		MgrDiskReads:       make(chan model.ReadRequest, chanSize),
-		MgrUiStatuses:      make(chan model.UiConnectionStatus, chanSize),
-		MgrWebdavGets:      make(chan model.BlockResponse, chanSize),
-		MgrWebdavPuts:      make(chan model.BlockIdResponse, chanSize),
-		nodesAddressMap:    make(map[model.NodeId]string),
-		NodeId:             nodeId,
-		connAddress:        make(map[model.ConnId]string),
-		nodeConnMap:        set.NewBimap[model.NodeId, model.ConnId](),
-		mirrorDistributer:  dist.NewMirrorDistributer(),
-		xorDistributer:     dist.NewXorDistributer(),
-		blockType:          blockType,
-		nodeAddress:        nodeAddress,
-		savePath:           savePath,
-		fileOps:            fileOps,
-		pendingBlockWrites: newPendingBlockWrites(),
-		freeBytes:          freeBytes,
+		UiMgrConnectTos:     make(chan model.UiMgrConnectTo, chanSize),
+		UiMgrJoinTokens:     make(chan model.UiMgrJoinToken, chanSize),
+		ConnsMgrStatuses:    make(chan model.NetConnectionStatus, chanSize),
+		ConnsMgrReceives:    make(chan model.ConnsMgrReceive, chanSize),
+		DiskMgrWrites:       make(chan model.WriteResult, chanSize),
+		DiskMgrInventories:  make(chan model.Inventory, chanSize),
+		DiskMgrHints:        make(chan model.Hints, chanSize),
+		DiskMgrReads:        make(chan model.ReadResult, chanSize),
+		WebdavMgrGets:       make(chan model.GetBlockReq, chanSize),
+		WebdavMgrPuts:       make(chan model.PutBlockReq, chanSize),
+		MgrConnsConnectTos:  make(chan model.MgrConnsConnectTo, chanSize),
+		MgrConnsSends:       make(chan model.MgrConnsSend, chanSize),
+		MgrConnsDisconnects: make(chan model.MgrConnsDisconnect, chanSize),
+		MgrDiskWrites:       make(chan model.WriteRequest, chanSize),
+		MgrDiskInventories:  make(chan model.InventoryRequest, chanSize),
+		MgrDiskHints:        make(chan model.HintsRequest, chanSize),
+		MgrDiskHintDeletes:  make(chan model.DeleteHint, chanSize),
+		MgrDiskReads:        make(chan model.ReadRequest, chanSize),
+		MgrUiStatuses:       make(chan model.UiConnectionStatus, chanSize),
+		MgrWebdavGets:       make(chan model.BlockResponse, chanSize),
+		MgrWebdavPuts:       make(chan model.BlockIdResponse, chanSize),
+		nodesAddressMap:     make(map[model.NodeId]string),
+		NodeId:              nodeId,
+		connAddress:         make(map[model.ConnId]string),
+		reconnectsDue:       make(chan string),
+		connProtocols:       make(map[model.ConnId]model.Protocol),
+		pendingAuths:        make(map[model.ConnId]*pendingAuth),
+		clusterSecret:       clusterSecret,
+		issuedJoinTokens:    make(map[string]issuedJoinToken),
+		heldJoinTokens:      make(map[string]joinToken),
+		nodeConnMap:         set.NewBimap[model.NodeId, model.ConnId](),
+		mirrorDistributer:   dist.NewMirrorDistributer(),
+		xorDistributer:      dist.NewXorDistributer(),
+		blockType:           blockType,
+		nodeAddress:         nodeAddress,
+		savePath:            savePath,
+		fileOps:             fileOps,
+		pendingBlockWrites:  newPendingBlockWrites(),
+		pendingBlockReads:   newPendingBlockReads(),
+		latencies:           newLatencyStats(),
+		hedgesDue:           make(chan model.ReqId),
+		repairChecks:        make(repairChecks),
+		clock:               newHybridClock(),
+		antiEntropySessions: make(antiEntropySessions),
+		antiEntropyPushes:   make(map[model.ReqId]model.DiskPointer),
+		hintOwners:          make(map[model.NodeId]bool),
+		hintReplays:         make(map[model.ReqId]model.DiskPointer),
+		freeBytes:           freeBytes,
+		Config:              DefaultConfig(),
 	}
 	mgr.mirrorDistributer.SetWeight(mgr.NodeId, int(freeBytes))
 	mgr.xorDistributer.SetWeight(mgr.NodeId, int(freeBytes))
@@ -96,7 +152,7 @@ func NewWithChanSize(chanSize int, nodeAddress string, savePath string, fileOps
 	return &mgr
 }
 
-func readNodeId(savePath string, fileOps disk.FileOps) (model.NodeId, error) {
+func ReadNodeId(savePath string, fileOps disk.FileOps) (model.NodeId, error) {
 	data, err := fileOps.ReadFile(filepath.Join(savePath, "node_id"))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -117,7 +173,23 @@ func (m *Mgr) Start() error {
 	if err != nil {
 		return err
 	}
-	go m.eventLoop()
+	m.failureDetector = newFailureDetector(m.Config.HeartbeatInterval)
+	m.reconnects = newReconnectScheduler(m.Config.ReconnectBase, m.Config.ReconnectMax)
+	var heartbeats <-chan time.Time
+	if m.Config.HeartbeatInterval > 0 {
+		heartbeats = time.NewTicker(m.Config.HeartbeatInterval).C
+	}
+	var timeouts <-chan time.Time
+	if m.Config.RequestTimeout > 0 {
+		timeouts = time.NewTicker(m.Config.RequestTimeout / 4).C
+	}
+	var antiEntropy <-chan time.Time
+	if m.Config.AntiEntropyInterval > 0 {
+		antiEntropy = time.NewTicker(m.Config.AntiEntropyInterval).C
+	}
+	// hints kept from before a restart are replayed once their owners connect
+	m.MgrDiskHints <- model.HintsRequest{ReqId: model.NewReqId()}
+	go m.eventLoop(heartbeats, timeouts, antiEntropy)
 	for nodeId, address := range m.nodesAddressMap {
 		if nodeId != m.NodeId {
 			m.UiMgrConnectTos <- model.UiMgrConnectTo{
@@ -159,11 +231,25 @@ func (m *Mgr) saveNodeAddressMap() error {
 	return nil
 }
 
-func (m *Mgr) eventLoop() {
+func (m *Mgr) eventLoop(heartbeats <-chan time.Time, timeouts <-chan time.Time, antiEntropy <-chan time.Time) {
 	for {
 		select {
+		case now := <-antiEntropy:
+			m.startAntiEntropy(now)
+		case now := <-heartbeats:
+			m.handleHeartbeatTick(now)
+		case now := <-timeouts:
+			m.handleRequestTimeouts(now)
+		case reqId := <-m.hedgesDue:
+			m.handleHedge(reqId)
+		case address := <-m.reconnectsDue:
+			if m.reconnects.due(address) {
+				m.MgrConnsConnectTos <- model.MgrConnsConnectTo{Address: address}
+			}
 		case r := <-m.UiMgrConnectTos:
 			m.handleConnectToReq(r)
+		case r := <-m.UiMgrJoinTokens:
+			m.handleJoinTokenReq(r)
 		case r := <-m.ConnsMgrStatuses:
 			m.handleNetConnectedStatus(r)
 		case r := <-m.ConnsMgrReceives:
@@ -172,6 +258,10 @@ func (m *Mgr) eventLoop() {
 			m.handleDiskReadResult(r)
 		case r := <-m.DiskMgrWrites:
 			m.handleDiskWriteResult(r)
+		case r := <-m.DiskMgrInventories:
+			m.handleInventory(r)
+		case r := <-m.DiskMgrHints:
+			m.handleHints(r)
 		case r := <-m.WebdavMgrGets:
 			m.handleWebdavGets(r)
 		case r := <-m.WebdavMgrPuts:
@@ -181,9 +271,31 @@ func (m *Mgr) eventLoop() {
 }
 
 func (m *Mgr) handleConnectToReq(i model.UiMgrConnectTo) {
+	if i.JoinToken != "" {
+		token, err := parseJoinToken(i.JoinToken)
+		if err != nil {
+			fmt.Println("handleConnectToReq:", err)
+			return
+		}
+		m.heldJoinTokens[i.Address] = token
+	}
 	m.MgrConnsConnectTos <- model.MgrConnsConnectTo{Address: string(i.Address)}
 }
 
+func (m *Mgr) handleJoinTokenReq(r model.UiMgrJoinToken) {
+	token, err := newJoinToken()
+	if err != nil {
+		fmt.Println("handleJoinTokenReq:", err)
+		r.Resp <- ""
+		return
+	}
+	m.issuedJoinTokens[token.id] = issuedJoinToken{
+		secret:  token.secret,
+		expires: time.Now().Add(joinTokenLifetime),
+	}
+	r.Resp <- token.String()
+}
+
 func (m *Mgr) syncNodesPayloadToSend() model.SyncNodes {
 	result := model.NewSyncNodes()
 	for node := range m.nodesAddressMap {
@@ -203,23 +315,35 @@ func (m *Mgr) syncNodesPayloadToSend() model.SyncNodes {
 func (m *Mgr) handleReceives(i model.ConnsMgrReceive) {
 	switch p := i.Payload.(type) {
 	case *model.IAm:
-		m.connAddress[i.ConnId] = p.Address
+		m.handleIAm(i.ConnId, p)
+		return
+	case *model.AuthChallenge:
+		m.handleAuthChallenge(i.ConnId, p)
+		return
+	case *model.AuthProof:
+		m.handleAuthProof(i.ConnId, p)
+		return
+	case *model.JoinAccept:
+		m.handleJoinAccept(i.ConnId, p)
+		return
+	}
+
+	node, authenticated := m.nodeConnMap.Get2(i.ConnId)
+	if !authenticated {
+		fmt.Println("handleReceives: ignoring payload from unauthenticated connection")
+		return
+	}
+
+	if !i.Bounced && m.failureDetector.heartbeat(node, time.Now()) {
 		m.MgrUiStatuses <- model.UiConnectionStatus{
 			Type:          model.Connected,
-			RemoteAddress: p.Address,
-			Id:            p.NodeId,
-		}
-		_ = m.addNodeToCluster(*p, i.ConnId)
-		syncNodes := m.syncNodesPayloadToSend()
-		for n := range m.nodesAddressMap {
-			connId, ok := m.nodeConnMap.Get1(n)
-			if ok {
-				m.MgrConnsSends <- model.MgrConnsSend{
-					ConnId:  connId,
-					Payload: &syncNodes,
-				}
-			}
+			RemoteAddress: m.connAddress[i.ConnId],
+			Id:            node,
 		}
+	}
+
+	switch p := i.Payload.(type) {
+	case *model.NoOp:
 	case *model.SyncNodes:
 		remoteNodes := p.GetNodes()
 		localNodes := set.NewSetFromMapKeys(m.nodesAddressMap)
@@ -230,45 +354,226 @@ func (m *Mgr) handleReceives(i model.ConnsMgrReceive) {
 			m.MgrConnsConnectTos <- model.MgrConnsConnectTo{Address: address}
 		}
 	case *model.WriteRequest:
-		caller, ok := m.nodeConnMap.Get2(i.ConnId)
-		if !ok {
-			payload := model.WriteResult{
-				Ok:      false,
-				Message: "connection error",
-				Caller:  caller,
-				Ptr:     p.Data.Ptr,
-			}
-			m.MgrConnsSends <- model.MgrConnsSend{
-				ConnId:  i.ConnId,
-				Payload: &payload,
-			}
+		if p.Caller == m.NodeId {
+			// conns could not deliver one of our own writes
+			delete(m.hintReplays, p.ReqId)
+			m.writeReplicaFailed(p.ReqId, p.Data.Ptr, "could not send write request")
+		} else {
+			m.clock.observe(p.Data.Version)
+			m.MgrDiskWrites <- *p
 		}
-		m.MgrDiskWrites <- *p
 	case *model.WriteResult:
 		m.handleDiskWriteResult(*p)
 	case *model.ReadRequest:
-		m.MgrDiskReads <- *p
+		if p.Caller == m.NodeId {
+			// conns could not deliver one of our own reads
+			m.readAttemptFailed(p.ReqId, "could not send read request", false)
+		} else {
+			m.MgrDiskReads <- *p
+		}
 	case *model.ReadResult:
 		m.handleDiskReadResult(*p)
+	case *model.RangeHashes:
+		m.handleRangeHashes(node, p)
+	case *model.RangeBlocks:
+		m.handleRangeBlocks(node, p)
 	default:
 		panic("Received unknown payload")
 	}
 }
 
+func (m *Mgr) pendingAuthFor(c model.ConnId) *pendingAuth {
+	pa, ok := m.pendingAuths[c]
+	if !ok {
+		pa = &pendingAuth{}
+		m.pendingAuths[c] = pa
+	}
+	return pa
+}
+
+func (m *Mgr) handleIAm(c model.ConnId, iam *model.IAm) {
+	localIAm := m.iAm()
+	protocol, err := model.NegotiateProtocol(&localIAm, iam)
+	if err != nil {
+		m.refuseConnection(c, iam, err)
+		return
+	}
+	if !protocol.Features.Has(model.FeatureAuth) {
+		m.refuseConnection(c, iam, errors.New("peer does not support authentication"))
+		return
+	}
+	if iam.NodeId == m.NodeId {
+		m.refuseConnection(c, iam, errors.New("peer claims to be this node"))
+		return
+	}
+	nonce, err := randomBytes(secretSize)
+	if err != nil {
+		m.refuseConnection(c, iam, err)
+		return
+	}
+	m.connProtocols[c] = protocol
+	m.connAddress[c] = iam.Address
+	pa := m.pendingAuthFor(c)
+	pa.iam = iam
+	pa.nonce = nonce
+	m.MgrConnsSends <- model.MgrConnsSend{
+		ConnId:  c,
+		Payload: &model.AuthChallenge{Nonce: nonce},
+	}
+}
+
+func (m *Mgr) handleAuthChallenge(c model.ConnId, challenge *model.AuthChallenge) {
+	pa := m.pendingAuthFor(c)
+	if pa.iam == nil {
+		// the peer's IAm comes first on the connection, it names the challenger
+		m.MgrConnsDisconnects <- model.MgrConnsDisconnect{ConnId: c}
+		return
+	}
+	pa.peerNonce = challenge.Nonce
+	proof := model.AuthProof{NodeId: m.NodeId}
+	if pa.joinToken != nil {
+		proof.TokenId = pa.joinToken.id
+		proof.Mac = authMac(pa.joinToken.secret, challenge.Nonce, pa.iam.NodeId, m.NodeId)
+	} else {
+		proof.Mac = authMac(m.clusterSecret, challenge.Nonce, pa.iam.NodeId, m.NodeId)
+	}
+	m.MgrConnsSends <- model.MgrConnsSend{
+		ConnId:  c,
+		Payload: &proof,
+	}
+}
+
+func (m *Mgr) handleAuthProof(c model.ConnId, proof *model.AuthProof) {
+	pa, ok := m.pendingAuths[c]
+	if !ok || pa.iam == nil {
+		m.MgrConnsDisconnects <- model.MgrConnsDisconnect{ConnId: c}
+		return
+	}
+	if proof.NodeId != pa.iam.NodeId {
+		m.refuseConnection(c, pa.iam, errors.New("authentication failed"))
+		return
+	}
+
+	if proof.TokenId != "" {
+		issued, ok := m.issuedJoinTokens[proof.TokenId]
+		if !ok || time.Now().After(issued.expires) || !validAuthMac(issued.secret, pa.nonce, m.NodeId, proof.NodeId, proof.Mac) {
+			m.refuseConnection(c, pa.iam, errors.New("invalid join token"))
+			return
+		}
+		delete(m.issuedJoinTokens, proof.TokenId)
+		m.MgrConnsSends <- model.MgrConnsSend{
+			ConnId:  c,
+			Payload: &model.JoinAccept{WrappedSecret: wrapSecret(m.clusterSecret, issued.secret, pa.nonce)},
+		}
+		m.completeAuth(c, pa.iam)
+		return
+	}
+
+	if validAuthMac(m.clusterSecret, pa.nonce, m.NodeId, proof.NodeId, proof.Mac) {
+		m.completeAuth(c, pa.iam)
+		return
+	}
+
+	// We are joining and don't know the cluster secret until JoinAccept arrives
+	if pa.joinToken != nil {
+		pa.stashedProof = proof
+		return
+	}
+
+	m.refuseConnection(c, pa.iam, errors.New("authentication failed"))
+}
+
+func (m *Mgr) handleJoinAccept(c model.ConnId, accept *model.JoinAccept) {
+	pa, ok := m.pendingAuths[c]
+	if !ok || pa.joinToken == nil || pa.iam == nil {
+		m.MgrConnsDisconnects <- model.MgrConnsDisconnect{ConnId: c}
+		return
+	}
+	secret := wrapSecret(accept.WrappedSecret, pa.joinToken.secret, pa.peerNonce)
+	// The peer proved itself before accepting us, that proof has to hold up
+	// with the secret it handed over before we adopt the secret
+	proof := pa.stashedProof
+	if proof == nil || !validAuthMac(secret, pa.nonce, m.NodeId, proof.NodeId, proof.Mac) {
+		m.refuseConnection(c, pa.iam, errors.New("authentication failed"))
+		return
+	}
+	err := saveClusterSecret(m.savePath, m.fileOps, secret)
+	if err != nil {
+		m.refuseConnection(c, pa.iam, err)
+		return
+	}
+	m.clusterSecret = secret
+	delete(m.heldJoinTokens, m.connAddress[c])
+	pa.joinToken = nil
+	pa.stashedProof = nil
+	m.completeAuth(c, pa.iam)
+}
+
+func (m *Mgr) completeAuth(c model.ConnId, iam *model.IAm) {
+	delete(m.pendingAuths, c)
+	m.MgrUiStatuses <- model.UiConnectionStatus{
+		Type:          model.Connected,
+		RemoteAddress: iam.Address,
+		Id:            iam.NodeId,
+	}
+	_ = m.addNodeToCluster(*iam, c)
+	m.reconnects.reset(iam.Address)
+	if m.hintOwners[iam.NodeId] {
+		m.replayHints(iam.NodeId)
+	}
+	if m.connProtocols[c].Features.Has(model.FeatureHeartbeat) {
+		m.failureDetector.add(iam.NodeId, time.Now())
+	}
+	syncNodes := m.syncNodesPayloadToSend()
+	for n := range m.nodesAddressMap {
+		connId, ok := m.nodeConnMap.Get1(n)
+		if ok {
+			m.MgrConnsSends <- model.MgrConnsSend{
+				ConnId:  connId,
+				Payload: &syncNodes,
+			}
+		}
+	}
+}
+
+func (m *Mgr) refuseConnection(c model.ConnId, iam *model.IAm, err error) {
+	m.MgrUiStatuses <- model.UiConnectionStatus{
+		Type:          model.Refused,
+		RemoteAddress: iam.Address,
+		Msg:           err.Error(),
+		Id:            iam.NodeId,
+	}
+	m.MgrConnsDisconnects <- model.MgrConnsDisconnect{ConnId: c}
+}
+
+func (m *Mgr) iAm() model.IAm {
+	return model.IAm{
+		NodeId:      m.NodeId,
+		Address:     m.nodeAddress,
+		FreeBytes:   m.freeBytes,
+		ProtocolMin: model.ProtocolVersionMin,
+		ProtocolMax: model.ProtocolVersionMax,
+		Features:    model.SupportedFeatures,
+	}
+}
+
 func (m *Mgr) handleDiskWriteResult(r model.WriteResult) {
+	if r.Ok && r.Ptr.NodeId == m.NodeId && model.IsHint(r.Ptr.FileName) {
+		m.hintKept(r.Ptr)
+	}
 	if r.Caller == m.NodeId {
-		resolved, blockId := m.pendingBlockWrites.resolve(r.Ptr)
-		var err error = nil
-		if !r.Ok {
-			err = errors.New(r.Message)
-			m.pendingBlockWrites.cancel(blockId)
-		}
-		switch resolved {
-		case done:
-			m.MgrWebdavPuts <- model.BlockIdResponse{
-				BlockId: blockId,
-				Err:     err,
+		if hint, ok := m.hintReplays[r.ReqId]; ok {
+			m.hintReplayed(r, hint)
+		} else if model.IsHint(r.Ptr.FileName) {
+			if !r.Ok {
+				fmt.Println("could not keep hint", r.Ptr.FileName, "on", r.Ptr.NodeId+":", r.Message)
 			}
+		} else if r.Ok {
+			m.writeReplicaDone(r.ReqId, r.Ptr)
+		} else if r.Conflict {
+			m.writeReplicaRefused(r.ReqId, r.Ptr)
+		} else {
+			m.writeReplicaFailed(r.ReqId, r.Ptr, r.Message)
 		}
 	} else {
 		c, ok := m.nodeConnMap.Get1(r.Caller)
@@ -278,35 +583,165 @@ func (m *Mgr) handleDiskWriteResult(r model.WriteResult) {
 				Payload: &r,
 			}
 		} else {
-			panic("not connected")
+			fmt.Println("handleDiskWriteResult: not connected")
 		}
 	}
 }
 
 func (m *Mgr) handleDiskReadResult(r model.ReadResult) {
-	if r.Ok {
-		if r.Caller == m.NodeId {
-			m.MgrWebdavGets <- model.BlockResponse{
-				Block: model.Block{
-					Id:   r.BlockId,
-					Type: model.Mirrored,
-					Data: r.Data.Data,
-				},
-				Err: nil,
+	if r.Caller != m.NodeId {
+		c, ok := m.nodeConnMap.Get1(r.Caller)
+		if ok {
+			m.MgrConnsSends <- model.MgrConnsSend{
+				ConnId:  c,
+				Payload: &r,
 			}
 		} else {
-			c, ok := m.nodeConnMap.Get1(r.Caller)
-			if ok {
-				m.MgrConnsSends <- model.MgrConnsSend{
-					ConnId:  c,
-					Payload: &r,
-				}
-			} else {
-				fmt.Println("handleDiskReadResult: not connected")
-			}
+			fmt.Println("handleDiskReadResult: not connected")
 		}
-	} else {
-		m.readDiskPtr(r.Ptrs, r.BlockId)
+		return
+	}
+
+	if target, ok := m.antiEntropyPushes[r.ReqId]; ok {
+		delete(m.antiEntropyPushes, r.ReqId)
+		if r.Ok {
+			m.repairReplica(target, r.BlockId, r.Data, "outdated")
+		}
+		return
+	}
+	if check, ok := m.repairChecks.take(r.ReqId); ok {
+		m.handleRepairCheck(check, r)
+		return
+	}
+	if r.NotFound {
+		m.continueRead(m.pendingBlockReads.notFound(r.ReqId, r.Message, time.Now()))
+		return
+	}
+	if !r.Ok {
+		m.readAttemptFailed(r.ReqId, r.Message, true)
+		return
+	}
+	m.clock.observe(r.Data.Version)
+	outcome, ok := m.pendingBlockReads.succeeded(r.ReqId, r.Data, time.Now())
+	if !ok {
+		// the other attempt of a hedged read already answered
+		return
+	}
+	m.latencies.record(outcome.ptr.NodeId, outcome.latency)
+	if outcome.done {
+		m.finishRead(outcome)
+	} else if outcome.inflight == 0 {
+		m.readNextReplica(outcome.read, outcome.blockId)
+	}
+}
+
+func (m *Mgr) finishRead(outcome readOutcome) {
+	if outcome.neverWritten {
+		// no replica has ever stored the block
+		m.MgrWebdavGets <- model.BlockResponse{
+			Block: model.Block{Id: outcome.blockId, Type: model.Mirrored, Data: []byte{}},
+			ReqId: outcome.read,
+		}
+		return
+	}
+	if outcome.best == nil {
+		m.MgrWebdavGets <- model.BlockResponse{
+			Block: model.Block{Id: outcome.blockId},
+			ReqId: outcome.read,
+			Err:   fmt.Errorf("no replica of the block could be read (%s)", outcome.failures),
+		}
+		return
+	}
+	if len(outcome.failures) > 0 {
+		fmt.Println("read of", outcome.blockId, "failed over:", outcome.failures)
+	}
+	m.MgrWebdavGets <- model.BlockResponse{
+		Block: model.Block{
+			Id:      outcome.blockId,
+			Type:    model.Mirrored,
+			Data:    outcome.best.Data,
+			Version: outcome.best.Version,
+		},
+		ReqId: outcome.read,
+		Err:   nil,
+	}
+	m.readRepair(outcome)
+}
+
+// readRepair rewrites the replicas that failed the read or answered with an
+// older version and, for a sample of reads, checks the replicas that
+// weren't read
+func (m *Mgr) readRepair(outcome readOutcome) {
+	best := *outcome.best
+	for _, f := range outcome.failures {
+		if f.bad {
+			m.repairReplica(f.ptr, outcome.blockId, best, f.reason)
+		}
+	}
+	for _, ptr := range outcome.stale {
+		m.repairReplica(ptr, outcome.blockId, best, "stale version")
+	}
+	if m.Config.ReadRepairChance <= 0 || rand.Float64() >= m.Config.ReadRepairChance {
+		return
+	}
+	deadline := time.Now().Add(m.Config.RequestTimeout)
+	for _, ptr := range outcome.untried {
+		if m.failureDetector.state(ptr.NodeId) == suspect {
+			continue
+		}
+		id := model.NewReqId()
+		m.repairChecks[id] = repairCheck{ptr: ptr, blockId: outcome.blockId, data: best, deadline: deadline}
+		rr := model.ReadRequest{
+			Caller:  m.NodeId,
+			Ptrs:    []model.DiskPointer{ptr},
+			BlockId: outcome.blockId,
+			ReqId:   id,
+		}
+		if !m.sendReadRequest(rr) {
+			delete(m.repairChecks, id)
+		}
+	}
+}
+
+// handleRepairCheck compares another replica with the copy a read returned,
+// whichever holds the older version is rewritten
+func (m *Mgr) handleRepairCheck(check repairCheck, r model.ReadResult) {
+	switch {
+	case !r.Ok:
+		m.repairReplica(check.ptr, check.blockId, check.data, r.Message)
+	case r.Data.Version > check.data.Version:
+		m.repairReplica(check.data.Ptr, check.blockId, r.Data, "stale version")
+	case r.Data.Version < check.data.Version:
+		m.repairReplica(check.ptr, check.blockId, check.data, "stale version")
+	case !bytes.Equal(r.Data.Data, check.data.Data):
+		m.repairReplica(check.ptr, check.blockId, check.data, "replica differs")
+	}
+}
+
+func (m *Mgr) repairReplica(ptr model.DiskPointer, blockId model.BlockId, data model.RawData, reason string) {
+	fmt.Println("repairing replica", ptr.NodeId, "of", blockId+":", reason)
+	data.Ptr = ptr
+	w := model.WriteRequest{
+		Caller: m.NodeId,
+		Data:   data,
+		ReqId:  model.NewReqId(),
+	}
+	if ptr.NodeId == m.NodeId {
+		m.MgrDiskWrites <- w
+	} else if c, ok := m.nodeConnMap.Get1(ptr.NodeId); ok {
+		m.MgrConnsSends <- model.MgrConnsSend{ConnId: c, Payload: &w}
+	} else if err := m.handOff(data); err != nil {
+		fmt.Println("could not repair", ptr.NodeId, "of", blockId+":", err)
+	}
+}
+
+func (m *Mgr) readAttemptFailed(attempt model.ReqId, reason string, bad bool) {
+	m.continueRead(m.pendingBlockReads.failed(attempt, reason, bad, time.Now()))
+}
+
+func (m *Mgr) continueRead(outcome readOutcome, ok bool) {
+	if ok && outcome.inflight == 0 {
+		m.readNextReplica(outcome.read, outcome.blockId)
 	}
 }
 
@@ -325,68 +760,271 @@ func (m *Mgr) addNodeToCluster(iam model.IAm, c model.ConnId) error {
 func (m *Mgr) handleNetConnectedStatus(cs model.NetConnectionStatus) {
 	switch cs.Type {
 	case model.Connected:
+		if token, ok := m.heldJoinTokens[cs.Address]; ok {
+			m.pendingAuthFor(cs.Id).joinToken = &token
+		}
+		iam := m.iAm()
 		m.MgrConnsSends <- model.MgrConnsSend{
-			ConnId: cs.Id,
-			Payload: &model.IAm{
-				NodeId:    m.NodeId,
-				Address:   m.nodeAddress,
-				FreeBytes: m.freeBytes,
-			},
+			ConnId:  cs.Id,
+			Payload: &iam,
+		}
+	case model.Congested:
+		if node, ok := m.nodeConnMap.Get2(cs.Id); ok {
+			m.MgrUiStatuses <- model.UiConnectionStatus{
+				Type:          model.Congested,
+				RemoteAddress: m.connAddress[cs.Id],
+				Msg:           cs.Msg,
+				Id:            node,
+			}
 		}
 	case model.NotConnected:
-		address := m.connAddress[cs.Id]
+		if node, ok := m.nodeConnMap.Get2(cs.Id); ok {
+			m.failureDetector.remove(node)
+			m.nodeConnMap.Remove2(cs.Id)
+		}
+		delete(m.connProtocols, cs.Id)
+		delete(m.pendingAuths, cs.Id)
+		address, ok := m.connAddress[cs.Id]
 		delete(m.connAddress, cs.Id)
-		// Todo: need a mechanism to back off
-		m.MgrConnsConnectTos <- model.MgrConnsConnectTo{
-			Address: address,
+		if !ok {
+			// a failed dial is only retried if it was to a node we know
+			address = cs.Address
+		}
+		node, known := m.nodeForAddress(address)
+		if !known {
+			fmt.Println("Not Connected")
+			return
+		}
+		m.scheduleReconnect(node, address, cs.Msg)
+	}
+}
+
+func (m *Mgr) scheduleReconnect(node model.NodeId, address string, reason string) {
+	delay, ok := m.reconnects.schedule(address, time.Now())
+	if !ok {
+		return
+	}
+	time.AfterFunc(delay, func() { m.reconnectsDue <- address })
+	m.MgrUiStatuses <- model.UiConnectionStatus{
+		Type:          model.NotConnected,
+		RemoteAddress: address,
+		Msg:           fmt.Sprintf("%s, retrying in %s", reason, delay.Round(time.Millisecond)),
+		Id:            node,
+	}
+}
+
+func (m *Mgr) nodeForAddress(address string) (model.NodeId, bool) {
+	if address == "" {
+		return "", false
+	}
+	for node, a := range m.nodesAddressMap {
+		if a == address && node != m.NodeId {
+			return node, true
+		}
+	}
+	return "", false
+}
+
+func (m *Mgr) handleHeartbeatTick(now time.Time) {
+	for node := range m.nodesAddressMap {
+		c, ok := m.nodeConnMap.Get1(node)
+		if ok && m.connProtocols[c].Features.Has(model.FeatureHeartbeat) {
+			m.MgrConnsSends <- model.MgrConnsSend{
+				ConnId:  c,
+				Payload: &model.NoOp{},
+			}
+		}
+	}
+
+	for _, change := range m.failureDetector.check(now, m.Config.SuspectPhi, m.Config.DeadPhi) {
+		c, ok := m.nodeConnMap.Get1(change.node)
+		status := model.UiConnectionStatus{
+			RemoteAddress: m.nodesAddressMap[change.node],
+			Msg:           fmt.Sprintf("no heartbeat, phi %.1f", change.phi),
+			Id:            change.node,
+		}
+		switch change.state {
+		case suspect:
+			status.Type = model.Suspect
+			m.MgrUiStatuses <- status
+		case dead:
+			status.Type = model.Dead
+			m.MgrUiStatuses <- status
+			m.failureDetector.remove(change.node)
+			// the connection may already be gone, conn 0 belongs to another node
+			if ok {
+				m.MgrConnsDisconnects <- model.MgrConnsDisconnect{ConnId: c}
+			}
 		}
-		// Todo: reflect this in the ui
-		fmt.Println("Not Connected")
 	}
 }
 
-func (m *Mgr) handleWebdavGets(blockId model.BlockId) {
-	ptrs := m.mirrorDistributer.PointersForId(blockId)
+func (m *Mgr) handleWebdavGets(r model.GetBlockReq) {
+	ptrs := m.latencies.order(m.mirrorDistributer.PointersForId(r.BlockId))
 	if len(ptrs) == 0 {
 		m.MgrWebdavGets <- model.BlockResponse{
-			Block: model.Block{},
+			Block: model.Block{Id: r.BlockId},
+			ReqId: r.ReqId,
 			Err:   errors.New("not found"),
 		}
 	} else {
-		m.readDiskPtr(ptrs, blockId)
+		// a block nobody wrote reads as empty once as many replicas say so as
+		// a read waits for, even when the read asks every replica
+		missingQuorum := m.Config.readQuorum(len(ptrs))
+		quorum := missingQuorum
+		if r.Latest {
+			quorum = len(ptrs)
+		}
+		m.pendingBlockReads.add(r.ReqId, r.BlockId, ptrs, quorum, missingQuorum)
+		for range quorum - 1 {
+			m.sendNextReadAttempt(r.ReqId, r.BlockId)
+		}
+		m.readNextReplica(r.ReqId, r.BlockId)
 	}
 }
 
-func (m *Mgr) readDiskPtr(ptrs []model.DiskPointer, blockId model.BlockId) {
-	if len(ptrs) == 0 {
+// readNextReplica asks the next reachable replica for the block, settling
+// for the answers so far once every replica has been tried
+func (m *Mgr) readNextReplica(reqId model.ReqId, blockId model.BlockId) {
+	if m.sendNextReadAttempt(reqId, blockId) {
+		m.scheduleHedge(reqId)
 		return
 	}
-	n := ptrs[0].NodeId
-	rr := model.ReadRequest{
-		Caller:  m.NodeId,
-		Ptrs:    ptrs,
-		BlockId: blockId,
+	if outcome, ok := m.pendingBlockReads.giveUp(reqId); ok {
+		m.finishRead(outcome)
+	}
+}
+
+func (m *Mgr) sendNextReadAttempt(reqId model.ReqId, blockId model.BlockId) bool {
+	for {
+		now := time.Now()
+		attempt, ptr, ok := m.pendingBlockReads.nextAttempt(reqId, now, now.Add(m.Config.RequestTimeout))
+		if !ok {
+			return false
+		}
+		rr := model.ReadRequest{
+			Caller:  m.NodeId,
+			Ptrs:    []model.DiskPointer{ptr},
+			BlockId: blockId,
+			ReqId:   attempt,
+		}
+		if m.sendReadRequest(rr) {
+			return true
+		}
+		m.pendingBlockReads.failed(attempt, "not connected", false, now)
 	}
-	if m.NodeId == n {
+}
+
+func (m *Mgr) sendReadRequest(rr model.ReadRequest) bool {
+	ptr := rr.Ptrs[0]
+	if ptr.NodeId == m.NodeId {
 		m.MgrDiskReads <- rr
-	} else {
-		c, ok := m.nodeConnMap.Get1(n)
-		if ok {
-			m.MgrConnsSends <- model.MgrConnsSend{
-				ConnId:  c,
-				Payload: &rr,
-			}
-		} else {
-			m.MgrWebdavGets <- model.BlockResponse{
-				Block: model.Block{},
-				Err:   errors.New("no connection"),
-			}
+		return true
+	}
+	if c, ok := m.nodeConnMap.Get1(ptr.NodeId); ok {
+		m.MgrConnsSends <- model.MgrConnsSend{
+			ConnId:  c,
+			Payload: &rr,
+		}
+		return true
+	}
+	return false
+}
+
+// scheduleHedge sends a second read to another replica if the first hasn't
+// answered within the p95 latency of the node it went to
+func (m *Mgr) scheduleHedge(reqId model.ReqId) {
+	if m.Config.HedgeDelay <= 0 {
+		return
+	}
+	node, ok := m.pendingBlockReads.hedgeable(reqId)
+	if !ok {
+		return
+	}
+	delay := m.Config.HedgeDelay
+	if p95, ok := m.latencies.quantile(node, 0.95); ok {
+		delay = p95
+	}
+	time.AfterFunc(delay, func() { m.hedgesDue <- reqId })
+}
+
+func (m *Mgr) handleHedge(reqId model.ReqId) {
+	if _, ok := m.pendingBlockReads.hedgeable(reqId); !ok {
+		return
+	}
+	blockId := m.pendingBlockReads.markHedged(reqId)
+	m.sendNextReadAttempt(reqId, blockId)
+}
+
+func (m *Mgr) handleRequestTimeouts(now time.Time) {
+	for r, b := range m.pendingBlockWrites.expired(now) {
+		m.MgrWebdavPuts <- model.BlockIdResponse{
+			BlockId: b,
+			ReqId:   r,
+			Err:     errors.New("timed out writing block"),
+		}
+	}
+	m.repairChecks.expire(now)
+	m.antiEntropySessions.expire(now)
+	for _, attempt := range m.pendingBlockReads.expired(now) {
+		outcome, ok := m.pendingBlockReads.failed(attempt, "timed out", false, now)
+		if !ok {
+			continue
+		}
+		m.latencies.record(outcome.ptr.NodeId, outcome.latency)
+		if outcome.inflight == 0 {
+			m.readNextReplica(outcome.read, outcome.blockId)
+		}
+	}
+}
+
+func (m *Mgr) writeReplicaDone(reqId model.ReqId, ptr model.DiskPointer) {
+	if resolved, blockId := m.pendingBlockWrites.resolve(reqId, ptr); resolved == done {
+		m.MgrWebdavPuts <- model.BlockIdResponse{
+			BlockId: blockId,
+			ReqId:   reqId,
+			Err:     nil,
 		}
 	}
 }
 
-func (m *Mgr) handleWebdavWriteRequest(w model.Block) {
-	switch w.Type {
+func (m *Mgr) writeReplicaFailed(reqId model.ReqId, ptr model.DiskPointer, reason string) {
+	switch result, blockId := m.pendingBlockWrites.fail(reqId, ptr); result {
+	case quorumLost:
+		m.MgrWebdavPuts <- model.BlockIdResponse{
+			BlockId: blockId,
+			ReqId:   reqId,
+			Err:     errors.New(reason),
+		}
+	case notDone:
+		fmt.Println("replica", ptr.NodeId, "of", blockId, "was not written:", reason)
+	}
+}
+
+// writeReplicaRefused records a replica that holds a newer version than the
+// one a conditional write was based on. The write only conflicts when too
+// many replicas refuse it for the rest to reach the quorum.
+func (m *Mgr) writeReplicaRefused(reqId model.ReqId, ptr model.DiskPointer) {
+	switch result, blockId := m.pendingBlockWrites.refuse(reqId, ptr); result {
+	case conflicted:
+		m.MgrWebdavPuts <- model.BlockIdResponse{
+			BlockId: blockId,
+			ReqId:   reqId,
+			Err:     model.ErrVersionConflict,
+		}
+	case quorumLost:
+		m.MgrWebdavPuts <- model.BlockIdResponse{
+			BlockId: blockId,
+			ReqId:   reqId,
+			Err:     errors.New("too few replicas accepted the write"),
+		}
+	case notDone:
+		fmt.Println("replica", ptr.NodeId, "of", blockId, "refused the write:", model.ErrVersionConflict)
+	}
+}
+
+func (m *Mgr) handleWebdavWriteRequest(w model.PutBlockReq) {
+	switch w.Block.Type {
 	case model.Mirrored:
 		m.handleMirroredWriteRequest(w)
 	case model.XORed:
@@ -396,39 +1034,167 @@ func (m *Mgr) handleWebdavWriteRequest(w model.Block) {
 	}
 }
 
-func (m *Mgr) handleMirroredWriteRequest(b model.Block) {
+func (m *Mgr) handleMirroredWriteRequest(r model.PutBlockReq) {
+	b := r.Block
 	ptrs := m.mirrorDistributer.PointersForId(b.Id)
+	deadline := time.Now().Add(m.Config.RequestTimeout)
+	quorum := m.Config.writeQuorum(len(ptrs))
+	if b.IfVersion {
+		quorum = m.Config.conditionalWriteQuorum(len(ptrs))
+	}
+	m.pendingBlockWrites.add(r.ReqId, b.Id, ptrs, quorum, deadline)
+	version := m.clock.next()
 	for _, ptr := range ptrs {
-		m.pendingBlockWrites.add(b.Id, ptr)
 		data := model.RawData{
-			Data: b.Data,
-			Ptr:  ptr,
+			Data:    b.Data,
+			Ptr:     ptr,
+			Version: version,
 		}
 		writeRequest := model.WriteRequest{
-			Data:   data,
-			Caller: m.NodeId,
+			Data:        data,
+			Caller:      m.NodeId,
+			ReqId:       r.ReqId,
+			Conditional: b.IfVersion,
+			Expected:    b.Version,
 		}
 		if ptr.NodeId == m.NodeId {
 			m.MgrDiskWrites <- writeRequest
 		} else {
 			c, ok := m.nodeConnMap.Get1(ptr.NodeId)
-			if ok {
+			if ok && b.IfVersion && !m.connProtocols[c].Features.Has(model.FeatureConditionalWrites) {
+				// the node would write over a newer version without checking
+				m.writeReplicaFailed(r.ReqId, ptr, "node can't check versions")
+			} else if ok {
 				m.MgrConnsSends <- model.MgrConnsSend{
 					ConnId:  c,
 					Payload: &writeRequest,
 				}
+			} else if err := m.handOff(data); err == nil {
+				// the hint gets the replica written eventually, it doesn't
+				// make this write durable on the replica's node
+				m.writeReplicaFailed(r.ReqId, ptr, "not connected, kept a hint")
 			} else {
-				m.pendingBlockWrites.cancel(b.Id)
-				m.MgrWebdavPuts <- model.BlockIdResponse{
-					BlockId: b.Id,
-					Err:     errors.New("not connected"),
-				}
-				return
+				m.writeReplicaFailed(r.ReqId, ptr, "not connected and could not keep a hint: "+err.Error())
+			}
+		}
+	}
+}
+
+// startAntiEntropy compares the blocks this node holds with a random peer
+// so replicas that nobody reads still converge
+func (m *Mgr) startAntiEntropy(now time.Time) {
+	peers := []model.NodeId{}
+	for node := range m.nodesAddressMap {
+		if c, ok := m.nodeConnMap.Get1(node); ok && m.connProtocols[c].Features.Has(model.FeatureAntiEntropy) {
+			peers = append(peers, node)
+		}
+	}
+	if len(peers) == 0 {
+		return
+	}
+	id := model.NewReqId()
+	m.antiEntropySessions[id] = &antiEntropySession{
+		peer:      peers[rand.IntN(len(peers))],
+		initiator: true,
+		deadline:  now.Add(m.Config.AntiEntropyInterval),
+	}
+	m.MgrDiskInventories <- model.InventoryRequest{ReqId: id}
+}
+
+func (m *Mgr) handleRangeHashes(node model.NodeId, p *model.RangeHashes) {
+	m.antiEntropySessions[p.ReqId] = &antiEntropySession{
+		peer:         node,
+		remoteHashes: p.Hashes,
+		deadline:     time.Now().Add(m.Config.AntiEntropyInterval),
+	}
+	m.MgrDiskInventories <- model.InventoryRequest{ReqId: p.ReqId}
+}
+
+func (m *Mgr) handleInventory(i model.Inventory) {
+	s, ok := m.antiEntropySessions[i.ReqId]
+	if !ok {
+		return
+	}
+	c, connected := m.nodeConnMap.Get1(s.peer)
+	if i.Err != nil || !connected {
+		fmt.Println("anti-entropy with", s.peer, "stopped:", i.Err)
+		delete(m.antiEntropySessions, i.ReqId)
+		return
+	}
+	s.blocks = m.sharedBlocks(i.Blocks, s.peer)
+	if s.initiator {
+		m.MgrConnsSends <- model.MgrConnsSend{
+			ConnId:  c,
+			Payload: &model.RangeHashes{ReqId: i.ReqId, Hashes: rangeHashes(s.blocks)},
+		}
+		return
+	}
+	ranges := differingRanges(rangeHashes(s.blocks), s.remoteHashes)
+	if len(ranges) == 0 {
+		delete(m.antiEntropySessions, i.ReqId)
+		return
+	}
+	m.MgrConnsSends <- model.MgrConnsSend{
+		ConnId: c,
+		Payload: &model.RangeBlocks{
+			ReqId:  i.ReqId,
+			Ranges: ranges,
+			Blocks: blocksInRanges(s.blocks, ranges),
+			Reply:  true,
+		},
+	}
+}
+
+func (m *Mgr) handleRangeBlocks(node model.NodeId, p *model.RangeBlocks) {
+	s, ok := m.antiEntropySessions[p.ReqId]
+	if !ok || s.peer != node || s.blocks == nil {
+		return
+	}
+	delete(m.antiEntropySessions, p.ReqId)
+	for _, b := range outdatedIn(s.blocks, p.Blocks, p.Ranges) {
+		m.pushBlock(b, node)
+	}
+	if p.Reply {
+		if c, ok := m.nodeConnMap.Get1(node); ok {
+			m.MgrConnsSends <- model.MgrConnsSend{
+				ConnId: c,
+				Payload: &model.RangeBlocks{
+					ReqId:  p.ReqId,
+					Ranges: p.Ranges,
+					Blocks: blocksInRanges(s.blocks, p.Ranges),
+				},
 			}
 		}
 	}
 }
 
-func (m *Mgr) handleXoredWriteRequest(b model.Block) {
+// sharedBlocks returns the blocks the distributer places on both nodes
+func (m *Mgr) sharedBlocks(blocks []model.BlockSummary, peer model.NodeId) []model.BlockSummary {
+	result := []model.BlockSummary{}
+	for _, b := range blocks {
+		ptrs := m.mirrorDistributer.PointersForId(b.BlockId)
+		onNode := func(node model.NodeId) func(model.DiskPointer) bool {
+			return func(ptr model.DiskPointer) bool { return ptr.NodeId == node }
+		}
+		if slices.ContainsFunc(ptrs, onNode(m.NodeId)) && slices.ContainsFunc(ptrs, onNode(peer)) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// pushBlock reads a local block so it can be written to a peer missing it
+func (m *Mgr) pushBlock(blockId model.BlockId, node model.NodeId) {
+	id := model.NewReqId()
+	m.antiEntropyPushes[id] = model.DiskPointer{NodeId: node, FileName: string(blockId)}
+	m.MgrDiskReads <- model.ReadRequest{
+		Caller:  m.NodeId,
+		Ptrs:    []model.DiskPointer{{NodeId: m.NodeId, FileName: string(blockId)}},
+		BlockId: blockId,
+		ReqId:   id,
+	}
+}
+
+func (m *Mgr) handleXoredWriteRequest(r model.PutBlockReq) {
 	panic("not implemented yet")
 }
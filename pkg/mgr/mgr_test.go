@@ -15,10 +15,15 @@
 package mgr
 
 import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
 	"sync/atomic"
 	"tealfs/pkg/disk"
 	"tealfs/pkg/model"
 	"testing"
+	"time"
 
 	"context"
 )
@@ -27,7 +32,8 @@ func TestConnectToMgr(t *testing.T) {
 	const expectedAddress = "some-address:123"
 
 	m := NewWithChanSize(0, "dummyAddress", "dummyPath", &disk.MockFileOps{}, model.Mirrored, 1)
-	err := m.Start()
+	m.Config.HeartbeatInterval = 0
+	err := startMgr(m)
 	if err != nil {
 		t.Error("Error starting", err)
 		return
@@ -93,6 +99,86 @@ func TestReceiveSyncNodes(t *testing.T) {
 	}
 }
 
+func TestRefuseIncompatibleProtocol(t *testing.T) {
+	const remoteAddress = "some-address:123"
+	const remoteConnectionId = 1
+	var remoteNodeId = model.NewNodeId()
+
+	m := mgrWithConnectedNodes([]connectedNode{}, 0, t)
+
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.Connected,
+		Id:   remoteConnectionId,
+	}
+	<-m.MgrConnsSends
+
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: remoteConnectionId,
+		Payload: &model.IAm{
+			NodeId:      remoteNodeId,
+			Address:     remoteAddress,
+			FreeBytes:   1,
+			ProtocolMin: model.ProtocolVersionMax + 1,
+			ProtocolMax: model.ProtocolVersionMax + 2,
+		},
+	}
+
+	status := <-m.MgrUiStatuses
+	if status.Type != model.Refused || status.Id != remoteNodeId {
+		t.Error("expected connection to be refused", status)
+		return
+	}
+
+	disconnect := <-m.MgrConnsDisconnects
+	if disconnect.ConnId != remoteConnectionId {
+		t.Error("expected disconnect of", remoteConnectionId, "got", disconnect.ConnId)
+		return
+	}
+}
+
+func TestReconnectWithBackoff(t *testing.T) {
+	const remoteAddress = "some-address:123"
+	const remoteConnectionId = 1
+	var remoteNodeId = model.NewNodeId()
+
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: remoteAddress, conn: remoteConnectionId, node: remoteNodeId},
+	}, 0, t)
+
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Msg:  "Connection closed",
+		Id:   remoteConnectionId,
+	}
+	status := <-m.MgrUiStatuses
+	if status.Type != model.NotConnected || status.Id != remoteNodeId || !strings.Contains(status.Msg, "retrying in") {
+		t.Error("expected retry status for the lost node", status)
+		return
+	}
+	connectTo := <-m.MgrConnsConnectTos
+	if connectTo.Address != remoteAddress {
+		t.Error("expected to reconnect to", remoteAddress, "got", connectTo.Address)
+		return
+	}
+
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type:    model.NotConnected,
+		Msg:     "Failure connecting",
+		Id:      remoteConnectionId + 1,
+		Address: remoteAddress,
+	}
+	status = <-m.MgrUiStatuses
+	if status.Type != model.NotConnected || status.Id != remoteNodeId {
+		t.Error("expected retry status after a failed dial", status)
+		return
+	}
+	connectTo = <-m.MgrConnsConnectTos
+	if connectTo.Address != remoteAddress {
+		t.Error("expected to redial", remoteAddress, "got", connectTo.Address)
+		return
+	}
+}
+
 func TestWebdavGet(t *testing.T) {
 	const expectedAddress1 = "some-address:123"
 	const expectedConnectionId1 = 1
@@ -134,6 +220,7 @@ func TestWebdavGet(t *testing.T) {
 						Data: []byte{1, 2, 3},
 					},
 					BlockId: r.BlockId,
+					ReqId:   r.ReqId,
 				}
 			}
 		}
@@ -163,6 +250,7 @@ func TestWebdavGet(t *testing.T) {
 								Data: []byte{1, 2, 3},
 							},
 							BlockId: readRequest.BlockId,
+							ReqId:   readRequest.ReqId,
 						},
 					}
 				}
@@ -171,7 +259,7 @@ func TestWebdavGet(t *testing.T) {
 	}()
 
 	for _, blockId := range ids {
-		m.WebdavMgrGets <- blockId
+		m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
 		w := <-m.MgrWebdavGets
 		if w.Block.Id != blockId {
 			t.Error("Expected", blockId, "got", w.Block.Id)
@@ -183,6 +271,925 @@ func TestWebdavGet(t *testing.T) {
 	}
 }
 
+func TestWebdavGetTimesOutToNextReplica(t *testing.T) {
+	config := testConfig()
+	config.RequestTimeout = 20 * time.Millisecond
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+		{address: "some-address2:234", conn: 2, node: model.NewNodeId()},
+	}, 0, config, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+
+	// the first replica never answers
+	first := nextReadRequest(m)
+	second := nextReadRequest(m)
+	if first.req.Ptrs[0] == second.req.Ptrs[0] {
+		t.Error("expected the read to move on to another replica")
+		return
+	}
+	result := model.ReadResult{
+		Ok:      true,
+		Caller:  m.NodeId,
+		Ptrs:    second.req.Ptrs[1:],
+		Data:    model.RawData{Ptr: second.req.Ptrs[0], Data: []byte{1, 2, 3}},
+		BlockId: blockId,
+		ReqId:   second.req.ReqId,
+	}
+	if second.fromDisk {
+		m.DiskMgrReads <- result
+	} else {
+		m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: second.conn, Payload: &result}
+	}
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || resp.Block.Id != blockId {
+		t.Error("expected read from the second replica", resp)
+		return
+	}
+}
+
+func TestHedgedWebdavGet(t *testing.T) {
+	config := testConfig()
+	config.HedgeDelay = 10 * time.Millisecond
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, config, t)
+
+	blockId := model.NewBlockId()
+	start := time.Now()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+
+	// the first replica is slow so a hedged read goes to the other one
+	first := nextReadRequest(m)
+	second := nextReadRequest(m)
+	if first.req.Ptrs[0] == second.req.Ptrs[0] || first.req.ReqId == second.req.ReqId {
+		t.Error("expected the hedge to go to another replica")
+		return
+	}
+	if time.Since(start) >= config.RequestTimeout {
+		t.Error("hedge should be sent before the request times out")
+		return
+	}
+	answer := func(sent sentReadRequest) {
+		result := model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Data:    model.RawData{Ptr: sent.req.Ptrs[0], Data: []byte{1, 2, 3}},
+			BlockId: blockId,
+			ReqId:   sent.req.ReqId,
+		}
+		if sent.fromDisk {
+			m.DiskMgrReads <- result
+		} else {
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: sent.conn, Payload: &result}
+		}
+	}
+
+	answer(second)
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || resp.Block.Id != blockId {
+		t.Error("expected the hedged read to answer", resp)
+		return
+	}
+	answer(first)
+	select {
+	case resp := <-m.MgrWebdavGets:
+		t.Error("the slower answer should be dropped", resp)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestConcurrentWebdavGetsOfSameBlock(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{}, 2, t)
+	blockId := model.NewBlockId()
+	reqIds := []model.ReqId{model.NewReqId(), model.NewReqId()}
+
+	for _, reqId := range reqIds {
+		m.WebdavMgrGets <- model.GetBlockReq{ReqId: reqId, BlockId: blockId}
+	}
+	for range reqIds {
+		r := <-m.MgrDiskReads
+		m.DiskMgrReads <- model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Ptrs:    r.Ptrs[1:],
+			Data:    model.RawData{Ptr: r.Ptrs[0], Data: []byte{1}},
+			BlockId: r.BlockId,
+			ReqId:   r.ReqId,
+		}
+	}
+
+	answered := map[model.ReqId]bool{}
+	for range reqIds {
+		resp := <-m.MgrWebdavGets
+		answered[resp.ReqId] = true
+	}
+	for _, reqId := range reqIds {
+		if !answered[reqId] {
+			t.Error("request never answered", reqId)
+		}
+	}
+}
+
+func TestWebdavGetFailsWhenNoReplicaAnswers(t *testing.T) {
+	config := testConfig()
+	config.RequestTimeout = 20 * time.Millisecond
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, config, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+	nextReadRequest(m)
+	nextReadRequest(m)
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err == nil || resp.Block.Id != blockId {
+		t.Error("expected an error once every replica timed out", resp)
+		return
+	}
+	if strings.Count(resp.Err.Error(), "timed out") != 2 {
+		t.Error("expected both attempts in the error", resp.Err)
+		return
+	}
+}
+
+func TestWebdavGetFailsOverOnReadError(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+
+	var badPtr model.DiskPointer
+	for _, ok := range []bool{false, true} {
+		sent := nextReadRequest(m)
+		if !ok {
+			badPtr = sent.req.Ptrs[0]
+		}
+		result := model.ReadResult{
+			Ok:      ok,
+			Caller:  m.NodeId,
+			Ptrs:    sent.req.Ptrs[1:],
+			Data:    model.RawData{Ptr: sent.req.Ptrs[0], Data: []byte{1, 2, 3}},
+			BlockId: blockId,
+			ReqId:   sent.req.ReqId,
+		}
+		if !ok {
+			result.Message = "checksum mismatch"
+			result.Data = model.RawData{Ptr: sent.req.Ptrs[0]}
+		}
+		if sent.fromDisk {
+			m.DiskMgrReads <- result
+		} else {
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: sent.conn, Payload: &result}
+		}
+	}
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || !bytes.Equal(resp.Block.Data, []byte{1, 2, 3}) {
+		t.Error("expected read to fail over to the second replica", resp)
+		return
+	}
+
+	repair := nextWriteRequest(m)
+	if repair.Data.Ptr != badPtr || !bytes.Equal(repair.Data.Data, []byte{1, 2, 3}) {
+		t.Error("expected the corrupt replica to be rewritten", repair)
+		return
+	}
+}
+
+func TestWebdavGetRepairsMissingReplica(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+
+	var missingPtr model.DiskPointer
+	for _, found := range []bool{false, true} {
+		sent := nextReadRequest(m)
+		result := model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Ptrs:    sent.req.Ptrs[1:],
+			Data:    model.RawData{Ptr: sent.req.Ptrs[0], Data: []byte{1, 2, 3}},
+			BlockId: blockId,
+			ReqId:   sent.req.ReqId,
+		}
+		if !found {
+			missingPtr = sent.req.Ptrs[0]
+			result.Ok = false
+			result.NotFound = true
+			result.Message = "block not found"
+			result.Data = model.RawData{Ptr: sent.req.Ptrs[0]}
+		}
+		sendReadResult(m, sent, result)
+	}
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || !bytes.Equal(resp.Block.Data, []byte{1, 2, 3}) {
+		t.Error("expected read to fail over to the replica holding the block", resp)
+		return
+	}
+
+	repair := nextWriteRequest(m)
+	if repair.Data.Ptr != missingPtr || !bytes.Equal(repair.Data.Data, []byte{1, 2, 3}) {
+		t.Error("expected the missing replica to be rewritten", repair)
+		return
+	}
+}
+
+func TestWebdavGetOfBlockNoReplicaHas(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+	for range 2 {
+		sent := nextReadRequest(m)
+		sendReadResult(m, sent, model.ReadResult{
+			Ok:       false,
+			NotFound: true,
+			Message:  "block not found",
+			Caller:   m.NodeId,
+			Data:     model.RawData{Ptr: sent.req.Ptrs[0]},
+			BlockId:  blockId,
+			ReqId:    sent.req.ReqId,
+		})
+	}
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || len(resp.Block.Data) != 0 {
+		t.Error("expected an empty block when no replica has it", resp)
+		return
+	}
+}
+
+func TestWebdavGetOfBlockNoReplicaHasWithNodeDown(t *testing.T) {
+	for _, latest := range []bool{false, true} {
+		config := testConfig()
+		config.WriteQuorum = 0
+		config.ReadQuorum = 0
+		m := mgrWithConfig([]connectedNode{
+			{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+			{address: "some-address2:234", conn: 2, node: model.NewNodeId()},
+		}, 2, config, t)
+		m.ConnsMgrStatuses <- model.NetConnectionStatus{
+			Type: model.NotConnected,
+			Id:   2,
+		}
+		<-m.MgrUiStatuses
+		<-m.MgrConnsConnectTos
+
+		blockId := model.NewBlockId()
+		m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId, Latest: latest}
+		for range 2 {
+			sent := nextReadRequest(m)
+			sendReadResult(m, sent, model.ReadResult{
+				Ok:       false,
+				NotFound: true,
+				Message:  "block not found",
+				Caller:   m.NodeId,
+				Data:     model.RawData{Ptr: sent.req.Ptrs[0]},
+				BlockId:  blockId,
+				ReqId:    sent.req.ReqId,
+			})
+		}
+
+		resp := <-m.MgrWebdavGets
+		if resp.Err != nil || len(resp.Block.Data) != 0 {
+			t.Error("expected an empty block when the replicas that are up don't have it, latest:", latest, resp)
+			return
+		}
+	}
+}
+
+func sendReadResult(m *Mgr, sent sentReadRequest, result model.ReadResult) {
+	if sent.fromDisk {
+		m.DiskMgrReads <- result
+	} else {
+		m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: sent.conn, Payload: &result}
+	}
+}
+
+func TestReadRepairOfSampledRead(t *testing.T) {
+	config := testConfig()
+	config.ReadRepairChance = 1
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, config, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+	answer := func(sent sentReadRequest, data []byte) {
+		result := model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Data:    model.RawData{Ptr: sent.req.Ptrs[0], Data: data},
+			BlockId: blockId,
+			ReqId:   sent.req.ReqId,
+		}
+		if sent.fromDisk {
+			m.DiskMgrReads <- result
+		} else {
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: sent.conn, Payload: &result}
+		}
+	}
+
+	first := nextReadRequest(m)
+	answer(first, []byte{1, 2, 3})
+	if resp := <-m.MgrWebdavGets; resp.Err != nil {
+		t.Error("unexpected error", resp.Err)
+		return
+	}
+
+	// the other replica is checked and found to be stale
+	check := nextReadRequest(m)
+	if check.req.Ptrs[0] == first.req.Ptrs[0] {
+		t.Error("expected the other replica to be checked")
+		return
+	}
+	answer(check, []byte{1, 2})
+	repair := nextWriteRequest(m)
+	if repair.Data.Ptr != check.req.Ptrs[0] || !bytes.Equal(repair.Data.Data, []byte{1, 2, 3}) {
+		t.Error("expected the stale replica to be rewritten", repair)
+		return
+	}
+}
+
+func TestWebdavGetFailsOverWhenNotConnected(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, t)
+
+	// with the remote node gone every read has to be served locally
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Id:   1,
+	}
+	<-m.MgrUiStatuses
+	<-m.MgrConnsConnectTos
+	for range 10 {
+		m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: model.NewBlockId()}
+		sent := nextReadRequest(m)
+		if !sent.fromDisk {
+			t.Error("expected read from the local disk")
+			return
+		}
+		m.DiskMgrReads <- model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Ptrs:    sent.req.Ptrs[1:],
+			Data:    model.RawData{Ptr: sent.req.Ptrs[0], Data: []byte{1}},
+			BlockId: sent.req.BlockId,
+			ReqId:   sent.req.ReqId,
+		}
+		if resp := <-m.MgrWebdavGets; resp.Err != nil {
+			t.Error("unexpected error", resp.Err)
+			return
+		}
+	}
+}
+
+func TestWebdavPutTimesOut(t *testing.T) {
+	config := testConfig()
+	config.RequestTimeout = 20 * time.Millisecond
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 0, config, t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.MgrDiskWrites:
+			case <-m.MgrConnsSends:
+			}
+		}
+	}()
+
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1}}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	resp := <-m.MgrWebdavPuts
+	if resp.Err == nil || resp.BlockId != block.Id {
+		t.Error("expected the write to time out", resp)
+		return
+	}
+}
+
+func TestWebdavPutWithQuorum(t *testing.T) {
+	config := testConfig()
+	config.WriteQuorum = 2
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+		{address: "some-address2:234", conn: 2, node: model.NewNodeId()},
+	}, 2, config, t)
+
+	// one of the three replicas is briefly down
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Id:   2,
+	}
+	<-m.MgrUiStatuses
+	<-m.MgrConnsConnectTos
+
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1}}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	versions := map[model.Version]bool{}
+	// the two replicas that are up, and the hint for the one that's down
+	for range 3 {
+		select {
+		case w := <-m.MgrDiskWrites:
+			versions[w.Data.Version] = true
+			m.DiskMgrWrites <- model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId}
+		case s := <-m.MgrConnsSends:
+			w := s.Payload.(*model.WriteRequest)
+			versions[w.Data.Version] = true
+			if s.ConnId != 1 {
+				t.Error("unexpected write to", s.ConnId)
+				return
+			}
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{
+				ConnId:  s.ConnId,
+				Payload: &model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId},
+			}
+		}
+	}
+
+	resp := <-m.MgrWebdavPuts
+	if resp.Err != nil || resp.BlockId != block.Id {
+		t.Error("expected the write to reach its quorum", resp)
+		return
+	}
+	if len(versions) != 1 || versions[0] {
+		t.Error("expected every replica to get the same version", versions)
+		return
+	}
+}
+
+func TestWebdavGetPrefersHighestVersion(t *testing.T) {
+	config := testConfig()
+	config.ReadQuorum = 2
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 2, config, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId}
+	sent := []sentReadRequest{nextReadRequest(m), nextReadRequest(m)}
+	for i, s := range sent {
+		result := model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Data:    model.RawData{Ptr: s.req.Ptrs[0], Data: []byte{byte(i)}, Version: model.Version(i + 1)},
+			BlockId: blockId,
+			ReqId:   s.req.ReqId,
+		}
+		if s.fromDisk {
+			m.DiskMgrReads <- result
+		} else {
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: s.conn, Payload: &result}
+		}
+	}
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || !bytes.Equal(resp.Block.Data, []byte{1}) {
+		t.Error("expected the newer version", resp)
+		return
+	}
+	repair := nextWriteRequest(m)
+	if repair.Data.Ptr != sent[0].req.Ptrs[0] || repair.Data.Version != 2 || !bytes.Equal(repair.Data.Data, []byte{1}) {
+		t.Error("expected the stale replica to be rewritten", repair)
+		return
+	}
+}
+
+func TestWebdavGetLatestAsksEveryReplica(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 2, t)
+
+	blockId := model.NewBlockId()
+	m.WebdavMgrGets <- model.GetBlockReq{ReqId: model.NewReqId(), BlockId: blockId, Latest: true}
+	sent := []sentReadRequest{nextReadRequest(m), nextReadRequest(m)}
+	for i, s := range sent {
+		result := model.ReadResult{
+			Ok:      true,
+			Caller:  m.NodeId,
+			Data:    model.RawData{Ptr: s.req.Ptrs[0], Data: []byte{byte(i)}, Version: model.Version(i + 1)},
+			BlockId: blockId,
+			ReqId:   s.req.ReqId,
+		}
+		if s.fromDisk {
+			m.DiskMgrReads <- result
+		} else {
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: s.conn, Payload: &result}
+		}
+	}
+
+	resp := <-m.MgrWebdavGets
+	if resp.Err != nil || resp.Block.Version != 2 {
+		t.Error("expected the newest version of every replica", resp)
+		return
+	}
+}
+
+func TestConditionalWebdavPutConflict(t *testing.T) {
+	m := mgrWithConnectedNodes([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+	}, 2, t)
+
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1}, Version: 5, IfVersion: true}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	w := nextWriteRequest(m)
+	if !w.Conditional || w.Expected != 5 || w.Data.Version <= 5 {
+		t.Error("expected a conditional write based on version 5", w)
+		return
+	}
+	m.DiskMgrWrites <- model.WriteResult{
+		Ok:       false,
+		Message:  model.ErrVersionConflict.Error(),
+		Caller:   w.Caller,
+		Ptr:      w.Data.Ptr,
+		ReqId:    w.ReqId,
+		Conflict: true,
+	}
+
+	resp := <-m.MgrWebdavPuts
+	if !errors.Is(resp.Err, model.ErrVersionConflict) || resp.BlockId != block.Id {
+		t.Error("expected the write to conflict", resp)
+		return
+	}
+}
+
+func TestConditionalWebdavPutPartialConflict(t *testing.T) {
+	config := testConfig()
+	config.WriteQuorum = 2
+	m := mgrWithConfig([]connectedNode{
+		{address: "some-address:123", conn: 1, node: model.NewNodeId()},
+		{address: "some-address2:234", conn: 2, node: model.NewNodeId()},
+	}, 2, config, t)
+
+	// one replica missed an earlier write and another holds a newer one, the
+	// other two accept so the quorum decides for the write
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1}, Version: 5, IfVersion: true}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	for i := range 3 {
+		w := nextWriteRequest(m)
+		result := model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId}
+		if i == 0 {
+			result = model.WriteResult{
+				Ok:       false,
+				Message:  model.ErrVersionConflict.Error(),
+				Caller:   w.Caller,
+				Ptr:      w.Data.Ptr,
+				ReqId:    w.ReqId,
+				Conflict: true,
+			}
+		}
+		if w.Data.Ptr.NodeId == m.NodeId {
+			m.DiskMgrWrites <- result
+		} else {
+			c, _ := m.nodeConnMap.Get1(w.Data.Ptr.NodeId)
+			m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: c, Payload: &result}
+		}
+	}
+
+	resp := <-m.MgrWebdavPuts
+	if resp.Err != nil || resp.BlockId != block.Id {
+		t.Error("expected the quorum to accept the write", resp)
+		return
+	}
+}
+
+func TestConditionalWebdavPutSkipsNodesThatCantCheckVersions(t *testing.T) {
+	remote := connectedNode{address: "some-address:123", conn: 1, node: model.NewNodeId()}
+	m := mgrWithConnectedNodes([]connectedNode{remote}, 2, t)
+	p := m.connProtocols[remote.conn]
+	p.Features &^= model.FeatureConditionalWrites
+	m.connProtocols[remote.conn] = p
+
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1}, Version: 5, IfVersion: true}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	resp := <-m.MgrWebdavPuts
+	if resp.Err == nil {
+		t.Error("expected the write to miss its quorum", resp)
+		return
+	}
+	select {
+	case s := <-m.MgrConnsSends:
+		t.Error("expected no conditional write to the node", s)
+	default:
+	}
+}
+
+func TestConcurrentConditionalWebdavPuts(t *testing.T) {
+	// whichever write a replica sees first moves it past version 5, so it
+	// refuses the other one
+	cases := []struct {
+		name        string
+		remoteFirst int
+		succeeded   []int
+	}{
+		{name: "replicas agree", remoteFirst: 0, succeeded: []int{0}},
+		{name: "replicas split", remoteFirst: 1, succeeded: []int{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			remote := connectedNode{address: "some-address:123", conn: 1, node: model.NewNodeId()}
+			config := testConfig()
+			config.WriteQuorum = 0
+			m := mgrWithConfig([]connectedNode{remote}, 4, config, t)
+
+			reqs := []model.ReqId{model.NewReqId(), model.NewReqId()}
+			block := model.Block{Id: model.NewBlockId(), Data: []byte{1}, Version: 5, IfVersion: true}
+			for _, r := range reqs {
+				m.WebdavMgrPuts <- model.PutBlockReq{ReqId: r, Block: block}
+			}
+			writes := map[model.NodeId]map[model.ReqId]model.WriteRequest{}
+			for range 4 {
+				w := nextWriteRequest(m)
+				if writes[w.Data.Ptr.NodeId] == nil {
+					writes[w.Data.Ptr.NodeId] = map[model.ReqId]model.WriteRequest{}
+				}
+				writes[w.Data.Ptr.NodeId][w.ReqId] = w
+			}
+
+			order := map[model.NodeId][]model.ReqId{
+				m.NodeId:    reqs,
+				remote.node: {reqs[tc.remoteFirst], reqs[1-tc.remoteFirst]},
+			}
+			for node, replicaOrder := range order {
+				current := model.Version(5)
+				for _, r := range replicaOrder {
+					w := writes[node][r]
+					result := model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId}
+					if w.Expected != current {
+						result = model.WriteResult{
+							Ok:       false,
+							Message:  model.ErrVersionConflict.Error(),
+							Caller:   w.Caller,
+							Ptr:      w.Data.Ptr,
+							ReqId:    w.ReqId,
+							Conflict: true,
+						}
+					} else {
+						current = w.Data.Version
+					}
+					if node == m.NodeId {
+						m.DiskMgrWrites <- result
+					} else {
+						m.ConnsMgrReceives <- model.ConnsMgrReceive{ConnId: remote.conn, Payload: &result}
+					}
+				}
+			}
+
+			succeeded := []int{}
+			for range 2 {
+				resp := <-m.MgrWebdavPuts
+				if resp.Err == nil {
+					succeeded = append(succeeded, slices.Index(reqs, resp.ReqId))
+				} else if !errors.Is(resp.Err, model.ErrVersionConflict) {
+					t.Error("expected a conflict", resp)
+					return
+				}
+			}
+			if !slices.Equal(succeeded, tc.succeeded) {
+				t.Error("expected", tc.succeeded, "to succeed, got", succeeded)
+				return
+			}
+		})
+	}
+}
+
+func TestHintedHandoff(t *testing.T) {
+	remote := connectedNode{address: "some-address:123", conn: 1, node: model.NewNodeId()}
+	config := testConfig()
+	config.WriteQuorum = 1
+	m := mgrWithConfig([]connectedNode{remote}, 2, config, t)
+
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Id:   remote.conn,
+	}
+	<-m.MgrUiStatuses
+	<-m.MgrConnsConnectTos
+
+	// the write meant for the offline replica is kept as a hint on this node
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1, 2, 3}}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	var hint model.WriteRequest
+	for range 2 {
+		w := <-m.MgrDiskWrites
+		if model.IsHint(w.Data.Ptr.FileName) {
+			hint = w
+		}
+		m.DiskMgrWrites <- model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId}
+	}
+	hinted, ok := model.HintedPointer(hint.Data.Ptr.FileName)
+	if !ok || hint.Data.Ptr.NodeId != m.NodeId || hinted.NodeId != remote.node || hinted.FileName != string(block.Id) {
+		t.Error("expected a hint for the offline replica", hint)
+		return
+	}
+	if resp := <-m.MgrWebdavPuts; resp.Err != nil || resp.BlockId != block.Id {
+		t.Error("expected the write to succeed", resp)
+		return
+	}
+
+	// the hint is replayed once the owner is back
+	remote.conn = 2
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{Type: model.Connected, Id: remote.conn}
+	<-m.MgrConnsSends
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: remote.conn,
+		Payload: &model.IAm{
+			NodeId:      remote.node,
+			Address:     remote.address,
+			FreeBytes:   1,
+			ProtocolMin: model.ProtocolVersionMin,
+			ProtocolMax: model.ProtocolVersionMax,
+			Features:    model.SupportedFeatures,
+		},
+	}
+	challenge := (<-m.MgrConnsSends).Payload.(*model.AuthChallenge)
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId: remote.conn,
+		Payload: &model.AuthProof{
+			NodeId: remote.node,
+			Mac:    authMac(m.clusterSecret, challenge.Nonce, m.NodeId, remote.node),
+		},
+	}
+	<-m.MgrUiStatuses
+
+	hintsReq := <-m.MgrDiskHints
+	if hintsReq.Owner != remote.node {
+		t.Error("expected the hints for the owner to be read", hintsReq)
+		return
+	}
+	m.DiskMgrHints <- model.Hints{ReqId: hintsReq.ReqId, Owner: remote.node, Blocks: []model.RawData{hint.Data}}
+
+	var replayed *model.WriteRequest
+	for replayed == nil {
+		s := <-m.MgrConnsSends
+		if r, ok := s.Payload.(*model.WriteRequest); ok {
+			replayed = r
+		}
+	}
+	if replayed.Data.Ptr != hinted || !bytes.Equal(replayed.Data.Data, block.Data) {
+		t.Error("unexpected replayed write", replayed)
+		return
+	}
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  remote.conn,
+		Payload: &model.WriteResult{Ok: true, Caller: replayed.Caller, Ptr: replayed.Data.Ptr, ReqId: replayed.ReqId},
+	}
+
+	deleted := <-m.MgrDiskHintDeletes
+	if deleted.Ptr.FileName != hint.Data.Ptr.FileName {
+		t.Error("expected the hint to be deleted once delivered", deleted)
+		return
+	}
+}
+
+func TestHintDoesNotCountTowardWriteQuorum(t *testing.T) {
+	remote := connectedNode{address: "some-address:123", conn: 1, node: model.NewNodeId()}
+	m := mgrWithConnectedNodes([]connectedNode{remote}, 2, t)
+
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Id:   remote.conn,
+	}
+	<-m.MgrUiStatuses
+	<-m.MgrConnsConnectTos
+
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1, 2, 3}}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	for range 2 {
+		w := <-m.MgrDiskWrites
+		m.DiskMgrWrites <- model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId}
+	}
+	if resp := <-m.MgrWebdavPuts; resp.Err == nil {
+		t.Error("expected the write to miss its quorum", resp)
+		return
+	}
+}
+
+func TestDefaultWriteQuorumToleratesReplicaDown(t *testing.T) {
+	remote := connectedNode{address: "some-address:123", conn: 1, node: model.NewNodeId()}
+	config := testConfig()
+	config.WriteQuorum = 0
+	config.ReadQuorum = 0
+	m := mgrWithConfig([]connectedNode{remote}, 2, config, t)
+
+	m.ConnsMgrStatuses <- model.NetConnectionStatus{
+		Type: model.NotConnected,
+		Id:   remote.conn,
+	}
+	<-m.MgrUiStatuses
+	<-m.MgrConnsConnectTos
+
+	block := model.Block{Id: model.NewBlockId(), Data: []byte{1, 2, 3}}
+	m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
+	for range 2 {
+		w := <-m.MgrDiskWrites
+		m.DiskMgrWrites <- model.WriteResult{Ok: true, Caller: w.Caller, Ptr: w.Data.Ptr, ReqId: w.ReqId}
+	}
+	if resp := <-m.MgrWebdavPuts; resp.Err != nil || resp.BlockId != block.Id {
+		t.Error("expected the write to succeed with a replica down", resp)
+		return
+	}
+	if config.writeQuorum(2)+config.readQuorum(2) <= 2 || config.writeQuorum(3)+config.readQuorum(3) <= 3 {
+		t.Error("expected reads to overlap writes", config.writeQuorum(3), config.readQuorum(3))
+	}
+}
+
+func TestAntiEntropyPushesMissingBlocks(t *testing.T) {
+	remote := connectedNode{address: "some-address:123", conn: 1, node: model.NewNodeId()}
+	config := testConfig()
+	config.AntiEntropyInterval = time.Hour
+	m := mgrWithConfig([]connectedNode{remote}, 2, config, t)
+
+	// the peer holds no blocks
+	reqId := model.NewReqId()
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  remote.conn,
+		Payload: &model.RangeHashes{ReqId: reqId, Hashes: rangeHashes(nil)},
+	}
+	inventory := <-m.MgrDiskInventories
+	block := model.BlockSummary{BlockId: model.NewBlockId(), Checksum: []byte{1}}
+	m.DiskMgrInventories <- model.Inventory{ReqId: inventory.ReqId, Blocks: []model.BlockSummary{block}}
+
+	s := <-m.MgrConnsSends
+	listed, ok := s.Payload.(*model.RangeBlocks)
+	if !ok || s.ConnId != remote.conn || !listed.Reply || len(listed.Blocks) != 1 || listed.Blocks[0].BlockId != block.BlockId {
+		t.Error("expected the blocks in the differing range", s.Payload)
+		return
+	}
+	m.ConnsMgrReceives <- model.ConnsMgrReceive{
+		ConnId:  remote.conn,
+		Payload: &model.RangeBlocks{ReqId: reqId, Ranges: listed.Ranges},
+	}
+
+	read := <-m.MgrDiskReads
+	if read.BlockId != block.BlockId {
+		t.Error("expected the missing block to be read", read)
+		return
+	}
+	m.DiskMgrReads <- model.ReadResult{
+		Ok:      true,
+		Caller:  read.Caller,
+		Data:    model.RawData{Ptr: read.Ptrs[0], Data: []byte{1, 2, 3}},
+		BlockId: read.BlockId,
+		ReqId:   read.ReqId,
+	}
+	w := nextWriteRequest(m)
+	if w.Data.Ptr.NodeId != remote.node || w.Data.Ptr.FileName != string(block.BlockId) || !bytes.Equal(w.Data.Data, []byte{1, 2, 3}) {
+		t.Error("expected the block to be pushed to the peer", w)
+		return
+	}
+}
+
+func nextWriteRequest(m *Mgr) model.WriteRequest {
+	for {
+		select {
+		case w := <-m.MgrDiskWrites:
+			return w
+		case s := <-m.MgrConnsSends:
+			if w, ok := s.Payload.(*model.WriteRequest); ok {
+				return *w
+			}
+		}
+	}
+}
+
+type sentReadRequest struct {
+	req      model.ReadRequest
+	fromDisk bool
+	conn     model.ConnId
+}
+
+func nextReadRequest(m *Mgr) sentReadRequest {
+	for {
+		select {
+		case r := <-m.MgrDiskReads:
+			return sentReadRequest{req: r, fromDisk: true}
+		case s := <-m.MgrConnsSends:
+			if r, ok := s.Payload.(*model.ReadRequest); ok {
+				return sentReadRequest{req: *r, conn: s.ConnId}
+			}
+		}
+	}
+}
+
 func TestWebdavPut(t *testing.T) {
 	const expectedAddress1 = "some-address:123"
 	const expectedConnectionId1 = 1
@@ -225,6 +1232,7 @@ func TestWebdavPut(t *testing.T) {
 					Ok:     true,
 					Caller: m.NodeId,
 					Ptr:    w.Data.Ptr,
+					ReqId:  w.ReqId,
 				}
 			}
 		}
@@ -250,6 +1258,7 @@ func TestWebdavPut(t *testing.T) {
 							Ok:     true,
 							Caller: writeRequest.Caller,
 							Ptr:    writeRequest.Data.Ptr,
+							ReqId:  writeRequest.ReqId,
 						},
 					}
 				}
@@ -259,7 +1268,7 @@ func TestWebdavPut(t *testing.T) {
 	}()
 
 	for _, block := range blocks {
-		m.WebdavMgrPuts <- block
+		m.WebdavMgrPuts <- model.PutBlockReq{ReqId: model.NewReqId(), Block: block}
 		w := <-m.MgrWebdavPuts
 		if w.BlockId != block.Id {
 			t.Error("Expected", block.Id, "got", w.BlockId)
@@ -277,9 +1286,65 @@ type connectedNode struct {
 	node    model.NodeId
 }
 
+func TestDeadPeerWithoutConnection(t *testing.T) {
+	m := NewWithChanSize(1, "dummyAddress", "dummyPath", &disk.MockFileOps{}, model.Mirrored, 1)
+	m.failureDetector = newFailureDetector(time.Second)
+	node := model.NewNodeId()
+	now := time.Unix(1000, 0)
+	// the peer's connection went away before it was found dead
+	m.failureDetector.add(node, now)
+
+	m.handleHeartbeatTick(now.Add(time.Hour))
+	if status := <-m.MgrUiStatuses; status.Type != model.Dead || status.Id != node {
+		t.Error("expected the peer to be reported dead", status)
+		return
+	}
+	select {
+	case d := <-m.MgrConnsDisconnects:
+		t.Error("expected no connection to be closed", d)
+	default:
+	}
+}
+
+func testConfig() Config {
+	config := DefaultConfig()
+	config.HeartbeatInterval = 0
+	config.ReconnectBase = time.Millisecond
+	config.HedgeDelay = 0
+	config.ReadRepairChance = 0
+	config.AntiEntropyInterval = 0
+	// writes wait for every replica and reads take the first answer, unless
+	// a test is about the quorums
+	config.WriteQuorum = 3
+	config.ReadQuorum = 1
+	return config
+}
+
+// startMgr starts m and takes the request for the hints kept from before a
+// restart that it sends the disk on startup
+func startMgr(m *Mgr) error {
+	started := make(chan error)
+	go func() { started <- m.Start() }()
+	select {
+	case err := <-started:
+		if err != nil {
+			return err
+		}
+		<-m.MgrDiskHints
+		return nil
+	case <-m.MgrDiskHints:
+		return <-started
+	}
+}
+
 func mgrWithConnectedNodes(nodes []connectedNode, chanSize int, t *testing.T) *Mgr {
+	return mgrWithConfig(nodes, chanSize, testConfig(), t)
+}
+
+func mgrWithConfig(nodes []connectedNode, chanSize int, config Config, t *testing.T) *Mgr {
 	m := NewWithChanSize(chanSize, "dummyAddress", "dummyPath", &disk.MockFileOps{}, model.Mirrored, 1)
-	err := m.Start()
+	m.Config = config
+	err := startMgr(m)
 	if err != nil {
 		t.Error("Error starting", err)
 	}
@@ -316,15 +1381,34 @@ func mgrWithConnectedNodes(nodes []connectedNode, chanSize int, t *testing.T) *M
 		// Send a message to Mgr indicating the newly
 		// connected node has sent us an Iam payload
 		iamPayload := model.IAm{
-			NodeId:    n.node,
-			Address:   n.address,
-			FreeBytes: 1,
+			NodeId:      n.node,
+			Address:     n.address,
+			FreeBytes:   1,
+			ProtocolMin: model.ProtocolVersionMin,
+			ProtocolMax: model.ProtocolVersionMax,
+			Features:    model.SupportedFeatures,
 		}
 		m.ConnsMgrReceives <- model.ConnsMgrReceive{
 			ConnId:  n.conn,
 			Payload: &iamPayload,
 		}
 
+		// Mgr should challenge the new node to prove
+		// it knows the cluster secret
+		challenge := <-m.MgrConnsSends
+		c, ok := challenge.Payload.(*model.AuthChallenge)
+		if !ok || challenge.ConnId != n.conn {
+			t.Error("Expected auth challenge", challenge)
+			panic("Expected auth challenge")
+		}
+		m.ConnsMgrReceives <- model.ConnsMgrReceive{
+			ConnId: n.conn,
+			Payload: &model.AuthProof{
+				NodeId: n.node,
+				Mac:    authMac(m.clusterSecret, c.Nonce, m.NodeId, n.node),
+			},
+		}
+
 		<-m.MgrUiStatuses
 
 		nodesInCluster = append(nodesInCluster, n)
@@ -0,0 +1,265 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"fmt"
+	"strings"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// pendingBlockReads tracks reads this node started. Each request sent to a
+// replica is an attempt with its own id, so a read can have a hedged
+// attempt in flight and a replica that never answers can be skipped in
+// favor of the next one. A read is done once quorum replicas answered and
+// returns the highest version among them.
+type pendingBlockReads struct {
+	reads    map[model.ReqId]*pendingRead
+	attempts map[model.ReqId]*readAttempt
+}
+
+type pendingRead struct {
+	blockId model.BlockId
+	untried []model.DiskPointer
+	quorum  int
+	// missingQuorum is how many replicas have to answer that they have no
+	// copy for the block to count as never written
+	missingQuorum int
+	inflight      int
+	hedged        bool
+	failures      readFailures
+	answers       []model.RawData
+}
+
+type readAttempt struct {
+	read     model.ReqId
+	ptr      model.DiskPointer
+	sent     time.Time
+	deadline time.Time
+}
+
+type readFailure struct {
+	ptr    model.DiskPointer
+	reason string
+	// bad is set when the replica answered but its copy is missing or corrupt
+	bad bool
+	// missing is set when the replica answered that it has no copy at all
+	missing bool
+}
+
+type readFailures []readFailure
+
+func (f readFailures) String() string {
+	reasons := make([]string, 0, len(f))
+	for _, failure := range f {
+		reasons = append(reasons, fmt.Sprintf("%s/%s: %s", failure.ptr.NodeId, failure.ptr.FileName, failure.reason))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// neverWritten reports whether at least quorum replicas answered that they
+// have no copy and none answered with a corrupt one. Replicas that couldn't
+// be reached don't count either way.
+func (f readFailures) neverWritten(quorum int) bool {
+	missing := 0
+	for _, failure := range f {
+		if failure.missing {
+			missing++
+		} else if failure.bad {
+			return false
+		}
+	}
+	return missing > 0 && missing >= quorum
+}
+
+func newPendingBlockReads() pendingBlockReads {
+	return pendingBlockReads{
+		reads:    make(map[model.ReqId]*pendingRead),
+		attempts: make(map[model.ReqId]*readAttempt),
+	}
+}
+
+func (p *pendingBlockReads) add(r model.ReqId, b model.BlockId, ptrs []model.DiskPointer, quorum int, missingQuorum int) {
+	p.reads[r] = &pendingRead{blockId: b, untried: ptrs, quorum: quorum, missingQuorum: missingQuorum}
+}
+
+// nextAttempt takes the next untried replica for a read
+func (p *pendingBlockReads) nextAttempt(r model.ReqId, now time.Time, deadline time.Time) (model.ReqId, model.DiskPointer, bool) {
+	read, exists := p.reads[r]
+	if !exists || len(read.untried) == 0 {
+		return "", model.DiskPointer{}, false
+	}
+	ptr := read.untried[0]
+	read.untried = read.untried[1:]
+	read.inflight++
+	id := model.NewReqId()
+	p.attempts[id] = &readAttempt{read: r, ptr: ptr, sent: now, deadline: deadline}
+	return id, ptr, true
+}
+
+// hedgeable reports whether a read has exactly one attempt in flight, has
+// not been hedged yet and has another replica to try, along with the node
+// the attempt in flight went to
+func (p *pendingBlockReads) hedgeable(r model.ReqId) (model.NodeId, bool) {
+	read, exists := p.reads[r]
+	if !exists || read.hedged || read.inflight != 1 || len(read.untried) == 0 {
+		return "", false
+	}
+	for _, a := range p.attempts {
+		if a.read == r {
+			return a.ptr.NodeId, true
+		}
+	}
+	return "", false
+}
+
+func (p *pendingBlockReads) markHedged(r model.ReqId) model.BlockId {
+	read, exists := p.reads[r]
+	if !exists {
+		return ""
+	}
+	read.hedged = true
+	return read.blockId
+}
+
+type readOutcome struct {
+	read     model.ReqId
+	blockId  model.BlockId
+	ptr      model.DiskPointer
+	latency  time.Duration
+	inflight int
+	failures readFailures
+	untried  []model.DiskPointer
+	// done is set once the read has its answer, which is best. Replicas
+	// that answered with an older version are stale.
+	done  bool
+	best  *model.RawData
+	stale []model.DiskPointer
+	// neverWritten is set when no replica answered with the block and
+	// enough of them said they don't have it
+	neverWritten bool
+}
+
+// succeeded records an answer. Once the read is done later answers from
+// other attempts of the same read are no longer tracked.
+func (p *pendingBlockReads) succeeded(attempt model.ReqId, data model.RawData, now time.Time) (readOutcome, bool) {
+	a, exists := p.attempts[attempt]
+	if !exists {
+		return readOutcome{}, false
+	}
+	delete(p.attempts, attempt)
+	read := p.reads[a.read]
+	read.inflight--
+	data.Ptr = a.ptr
+	read.answers = append(read.answers, data)
+	if len(read.answers) >= read.quorum {
+		outcome := p.finish(a.read)
+		outcome.ptr = a.ptr
+		outcome.latency = now.Sub(a.sent)
+		return outcome, true
+	}
+	return readOutcome{
+		read:     a.read,
+		blockId:  read.blockId,
+		ptr:      a.ptr,
+		latency:  now.Sub(a.sent),
+		inflight: read.inflight,
+	}, true
+}
+
+// failed records why an attempt didn't work out
+func (p *pendingBlockReads) failed(attempt model.ReqId, reason string, bad bool, now time.Time) (readOutcome, bool) {
+	return p.fail(attempt, readFailure{reason: reason, bad: bad}, now)
+}
+
+// notFound records an attempt whose replica has no copy of the block
+func (p *pendingBlockReads) notFound(attempt model.ReqId, reason string, now time.Time) (readOutcome, bool) {
+	return p.fail(attempt, readFailure{reason: reason, bad: true, missing: true}, now)
+}
+
+func (p *pendingBlockReads) fail(attempt model.ReqId, failure readFailure, now time.Time) (readOutcome, bool) {
+	a, exists := p.attempts[attempt]
+	if !exists {
+		return readOutcome{}, false
+	}
+	delete(p.attempts, attempt)
+	read := p.reads[a.read]
+	read.inflight--
+	failure.ptr = a.ptr
+	read.failures = append(read.failures, failure)
+	return readOutcome{
+		read:     a.read,
+		blockId:  read.blockId,
+		ptr:      a.ptr,
+		latency:  now.Sub(a.sent),
+		inflight: read.inflight,
+		failures: read.failures,
+	}, true
+}
+
+// giveUp finishes a read once no replica is left to try, best is nil if
+// no replica answered
+func (p *pendingBlockReads) giveUp(r model.ReqId) (readOutcome, bool) {
+	read, exists := p.reads[r]
+	if !exists || read.inflight > 0 {
+		return readOutcome{}, false
+	}
+	return p.finish(r), true
+}
+
+func (p *pendingBlockReads) finish(r model.ReqId) readOutcome {
+	read := p.reads[r]
+	outcome := readOutcome{
+		read:     r,
+		blockId:  read.blockId,
+		failures: read.failures,
+		untried:  read.untried,
+		done:     true,
+	}
+	for i, answer := range read.answers {
+		if outcome.best == nil || answer.Version > outcome.best.Version {
+			outcome.best = &read.answers[i]
+		}
+	}
+	for _, answer := range read.answers {
+		if answer.Version < outcome.best.Version {
+			outcome.stale = append(outcome.stale, answer.Ptr)
+		}
+	}
+	outcome.neverWritten = outcome.best == nil && read.failures.neverWritten(read.missingQuorum)
+	p.remove(r)
+	return outcome
+}
+
+func (p *pendingBlockReads) remove(r model.ReqId) {
+	for id, a := range p.attempts {
+		if a.read == r {
+			delete(p.attempts, id)
+		}
+	}
+	delete(p.reads, r)
+}
+
+// expired returns the attempts whose replica has not answered by now
+func (p *pendingBlockReads) expired(now time.Time) []model.ReqId {
+	result := []model.ReqId{}
+	for id, a := range p.attempts {
+		if now.After(a.deadline) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -17,29 +17,44 @@ package mgr
 import (
 	"tealfs/pkg/model"
 	"tealfs/pkg/set"
+	"time"
 )
 
 type pendingBlockWrites struct {
-	b2ptr map[model.BlockId]set.Set[model.DiskPointer]
-	ptr2b map[model.DiskPointer]model.BlockId
+	writes map[model.ReqId]*pendingWrite
+}
+
+// pendingWrite is answered once quorum replicas are durable, the rest keep
+// going in the background
+type pendingWrite struct {
+	blockId   model.BlockId
+	ptrs      set.Set[model.DiskPointer]
+	replicas  int
+	quorum    int
+	acked     int
+	conflicts int
+	answered  bool
+	deadline  time.Time
 }
 
 func newPendingBlockWrites() pendingBlockWrites {
 	return pendingBlockWrites{
-		b2ptr: make(map[model.BlockId]set.Set[model.DiskPointer]),
-		ptr2b: make(map[model.DiskPointer]model.BlockId),
+		writes: make(map[model.ReqId]*pendingWrite),
 	}
 }
 
-func (p *pendingBlockWrites) add(b model.BlockId, ptr model.DiskPointer) {
-	if _, exists := p.b2ptr[b]; !exists {
-		p.b2ptr[b] = set.NewSet[model.DiskPointer]()
+func (p *pendingBlockWrites) add(r model.ReqId, b model.BlockId, ptrs []model.DiskPointer, quorum int, deadline time.Time) {
+	w := &pendingWrite{
+		blockId:  b,
+		ptrs:     set.NewSet[model.DiskPointer](),
+		replicas: len(ptrs),
+		quorum:   quorum,
+		deadline: deadline,
 	}
-
-	s := p.b2ptr[b]
-	s.Add(ptr)
-
-	p.ptr2b[ptr] = b
+	for _, ptr := range ptrs {
+		w.ptrs.Add(ptr)
+	}
+	p.writes[r] = w
 }
 
 type resolveResult int
@@ -48,27 +63,81 @@ const (
 	done resolveResult = iota
 	notDone
 	notTracking
+	quorumLost
+	conflicted
 )
 
-func (p *pendingBlockWrites) resolve(ptr model.DiskPointer) (resolveResult, model.BlockId) {
-	if b, exists := p.ptr2b[ptr]; exists {
-		s := p.b2ptr[b]
-		s.Remove(ptr)
-		delete(p.ptr2b, ptr)
-		if s.Len() == 0 {
-			delete(p.b2ptr, b)
-			return done, b
+// resolve records a durable replica, done is returned once, when the
+// write reaches its quorum
+func (p *pendingBlockWrites) resolve(r model.ReqId, ptr model.DiskPointer) (resolveResult, model.BlockId) {
+	w, exists := p.writes[r]
+	if !exists || !w.ptrs.Contains(ptr) {
+		return notTracking, ""
+	}
+	w.ptrs.Remove(ptr)
+	w.acked++
+	p.cleanUp(r)
+	if !w.answered && w.acked >= w.quorum {
+		w.answered = true
+		return done, w.blockId
+	}
+	return notDone, w.blockId
+}
+
+// fail records a replica that could not be written, quorumLost is returned
+// when the write can no longer reach its quorum
+func (p *pendingBlockWrites) fail(r model.ReqId, ptr model.DiskPointer) (resolveResult, model.BlockId) {
+	return p.failReplica(r, ptr, false)
+}
+
+// refuse records a replica that refused a conditional write because the
+// block changed since the version it was based on. conflicted is returned
+// once too many replicas refused for the write to reach its quorum.
+func (p *pendingBlockWrites) refuse(r model.ReqId, ptr model.DiskPointer) (resolveResult, model.BlockId) {
+	return p.failReplica(r, ptr, true)
+}
+
+func (p *pendingBlockWrites) failReplica(r model.ReqId, ptr model.DiskPointer, conflict bool) (resolveResult, model.BlockId) {
+	w, exists := p.writes[r]
+	if !exists || !w.ptrs.Contains(ptr) {
+		return notTracking, ""
+	}
+	w.ptrs.Remove(ptr)
+	if conflict {
+		w.conflicts++
+	}
+	if !w.answered && w.acked+w.ptrs.Len() < w.quorum {
+		p.cancel(r)
+		if w.conflicts > w.replicas-w.quorum {
+			return conflicted, w.blockId
 		}
-		return notDone, b
+		return quorumLost, w.blockId
 	}
-	return notTracking, ""
+	p.cleanUp(r)
+	return notDone, w.blockId
+}
+
+func (p *pendingBlockWrites) cleanUp(r model.ReqId) {
+	if w := p.writes[r]; w.ptrs.Len() == 0 {
+		delete(p.writes, r)
+	}
+}
+
+func (p *pendingBlockWrites) cancel(r model.ReqId) {
+	delete(p.writes, r)
 }
 
-func (p *pendingBlockWrites) cancel(b model.BlockId) {
-	if s, exists := p.b2ptr[b]; exists {
-		for _, ptr := range s.GetValues() {
-			delete(p.ptr2b, ptr)
+// expired cancels the writes that have not finished by now and returns the
+// ones that never reached their quorum
+func (p *pendingBlockWrites) expired(now time.Time) map[model.ReqId]model.BlockId {
+	result := make(map[model.ReqId]model.BlockId)
+	for r, w := range p.writes {
+		if now.After(w.deadline) {
+			if !w.answered {
+				result[r] = w.blockId
+			}
+			p.cancel(r)
 		}
-		delete(p.b2ptr, b)
 	}
+	return result
 }
@@ -17,6 +17,7 @@ package mgr
 import (
 	"tealfs/pkg/model"
 	"testing"
+	"time"
 )
 
 func TestPendingBlockWrites(t *testing.T) {
@@ -37,31 +38,174 @@ func TestPendingBlockWrites(t *testing.T) {
 		FileName: "someFile3",
 	}
 
-	result, _ := pbw.resolve(ptr1)
+	reqId1 := model.NewReqId()
+	reqId2 := model.NewReqId()
+
+	result, _ := pbw.resolve(reqId1, ptr1)
 	if result != notTracking {
 		t.Errorf("should be not tracking")
 		return
 	}
 
-	pbw.add(blockId1, ptr1)
-	pbw.add(blockId1, ptr2)
-	pbw.add(blockId2, ptr3)
+	deadline := time.Now().Add(time.Minute)
+	pbw.add(reqId1, blockId1, []model.DiskPointer{ptr1, ptr2}, 2, deadline)
+	pbw.add(reqId2, blockId2, []model.DiskPointer{ptr3}, 1, deadline)
 
-	result, blockResult := pbw.resolve(ptr1)
+	result, blockResult := pbw.resolve(reqId1, ptr1)
 	if result != notDone || blockResult != blockId1 {
 		t.Errorf("should not be done")
 		return
 	}
 
-	result, blockResult = pbw.resolve(ptr2)
+	result, blockResult = pbw.resolve(reqId1, ptr2)
 	if result != done || blockResult != blockId1 {
 		t.Errorf("should be done")
 		return
 	}
 
-	result, blockResult = pbw.resolve(ptr3)
+	result, blockResult = pbw.resolve(reqId2, ptr3)
 	if result != done || blockResult != blockId2 {
 		t.Errorf("should nbe done")
 		return
 	}
 }
+
+func TestPendingBlockWritesExpire(t *testing.T) {
+	pbw := newPendingBlockWrites()
+	blockId := model.NewBlockId()
+	ptr := model.DiskPointer{
+		NodeId:   model.NewNodeId(),
+		FileName: "someFile1",
+	}
+	reqId := model.NewReqId()
+	now := time.Now()
+	pbw.add(reqId, blockId, []model.DiskPointer{ptr}, 1, now.Add(time.Second))
+
+	if expired := pbw.expired(now); len(expired) != 0 {
+		t.Error("should not have expired yet")
+		return
+	}
+	expired := pbw.expired(now.Add(2 * time.Second))
+	if len(expired) != 1 || expired[reqId] != blockId {
+		t.Error("expected block to expire", expired)
+		return
+	}
+	if result, _ := pbw.resolve(reqId, ptr); result != notTracking {
+		t.Error("expired write should no longer be tracked")
+		return
+	}
+}
+
+func TestPendingBlockWritesQuorum(t *testing.T) {
+	pbw := newPendingBlockWrites()
+	blockId := model.NewBlockId()
+	ptrs := []model.DiskPointer{
+		{NodeId: model.NewNodeId(), FileName: "someFile1"},
+		{NodeId: model.NewNodeId(), FileName: "someFile2"},
+		{NodeId: model.NewNodeId(), FileName: "someFile3"},
+	}
+	reqId := model.NewReqId()
+	pbw.add(reqId, blockId, ptrs, 2, time.Now().Add(time.Minute))
+
+	if result, _ := pbw.fail(reqId, ptrs[0]); result != notDone {
+		t.Error("quorum should still be reachable")
+		return
+	}
+	if result, _ := pbw.resolve(reqId, ptrs[1]); result != notDone {
+		t.Error("should not be done")
+		return
+	}
+	if result, b := pbw.resolve(reqId, ptrs[2]); result != done || b != blockId {
+		t.Error("should be done")
+		return
+	}
+	if result, _ := pbw.resolve(reqId, ptrs[2]); result != notTracking {
+		t.Error("should be finished")
+		return
+	}
+}
+
+func TestPendingBlockWritesQuorumLost(t *testing.T) {
+	pbw := newPendingBlockWrites()
+	blockId := model.NewBlockId()
+	ptrs := []model.DiskPointer{
+		{NodeId: model.NewNodeId(), FileName: "someFile1"},
+		{NodeId: model.NewNodeId(), FileName: "someFile2"},
+		{NodeId: model.NewNodeId(), FileName: "someFile3"},
+	}
+	reqId := model.NewReqId()
+	now := time.Now()
+	pbw.add(reqId, blockId, ptrs, 2, now.Add(time.Second))
+
+	if result, _ := pbw.fail(reqId, ptrs[0]); result != notDone {
+		t.Error("quorum should still be reachable")
+		return
+	}
+	if result, b := pbw.fail(reqId, ptrs[1]); result != quorumLost || b != blockId {
+		t.Error("quorum should be lost")
+		return
+	}
+	if result, _ := pbw.resolve(reqId, ptrs[2]); result != notTracking {
+		t.Error("failed write should no longer be tracked")
+		return
+	}
+
+	reqId = model.NewReqId()
+	pbw.add(reqId, blockId, ptrs, 1, now.Add(time.Second))
+	if result, _ := pbw.resolve(reqId, ptrs[0]); result != done {
+		t.Error("should be done")
+		return
+	}
+	if expired := pbw.expired(now.Add(2 * time.Second)); len(expired) != 0 {
+		t.Error("answered writes should not time out", expired)
+		return
+	}
+}
+
+func TestPendingBlockWritesConflictOnQuorum(t *testing.T) {
+	pbw := newPendingBlockWrites()
+	blockId := model.NewBlockId()
+	ptrs := []model.DiskPointer{
+		{NodeId: model.NewNodeId(), FileName: "someFile1"},
+		{NodeId: model.NewNodeId(), FileName: "someFile2"},
+		{NodeId: model.NewNodeId(), FileName: "someFile3"},
+	}
+	reqId := model.NewReqId()
+	pbw.add(reqId, blockId, ptrs, 2, time.Now().Add(time.Minute))
+
+	// one stale replica refusing doesn't decide the write
+	if result, _ := pbw.refuse(reqId, ptrs[0]); result != notDone {
+		t.Error("quorum should still be reachable")
+		return
+	}
+	if result, _ := pbw.resolve(reqId, ptrs[1]); result != notDone {
+		t.Error("should not be done")
+		return
+	}
+	if result, b := pbw.resolve(reqId, ptrs[2]); result != done || b != blockId {
+		t.Error("should be done")
+		return
+	}
+
+	reqId = model.NewReqId()
+	pbw.add(reqId, blockId, ptrs, 2, time.Now().Add(time.Minute))
+	if result, _ := pbw.refuse(reqId, ptrs[0]); result != notDone {
+		t.Error("quorum should still be reachable")
+		return
+	}
+	if result, b := pbw.refuse(reqId, ptrs[1]); result != conflicted || b != blockId {
+		t.Error("should conflict once the quorum can't be reached")
+		return
+	}
+
+	reqId = model.NewReqId()
+	pbw.add(reqId, blockId, ptrs, 2, time.Now().Add(time.Minute))
+	if result, _ := pbw.refuse(reqId, ptrs[0]); result != notDone {
+		t.Error("quorum should still be reachable")
+		return
+	}
+	if result, _ := pbw.fail(reqId, ptrs[1]); result != quorumLost {
+		t.Error("a single refusal among failures is not a conflict")
+		return
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// reconnectScheduler tracks dial attempts per address so a node that is down
+// is retried with exponential backoff instead of in a tight loop
+type reconnectScheduler struct {
+	base    time.Duration
+	max     time.Duration
+	retries map[string]*retryState
+	jitter  func(time.Duration) time.Duration
+}
+
+type retryState struct {
+	attempts int
+	next     time.Time
+	pending  bool
+}
+
+func newReconnectScheduler(base time.Duration, max time.Duration) reconnectScheduler {
+	return reconnectScheduler{
+		base:    base,
+		max:     max,
+		retries: make(map[string]*retryState),
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int64N(int64(d) + 1))
+		},
+	}
+}
+
+// schedule returns how long to wait before the next dial to address and
+// false if a dial is already waiting on its timer
+func (r *reconnectScheduler) schedule(address string, now time.Time) (time.Duration, bool) {
+	s, ok := r.retries[address]
+	if !ok {
+		s = &retryState{}
+		r.retries[address] = s
+	}
+	if s.pending {
+		return 0, false
+	}
+	delay := r.delay(s.attempts)
+	s.attempts++
+	s.next = now.Add(delay)
+	s.pending = true
+	return delay, true
+}
+
+// delay is half the capped exponential backoff plus up to the other half as jitter
+func (r *reconnectScheduler) delay(attempts int) time.Duration {
+	// doubling stops at max, shifting by attempts would overflow
+	d := min(r.base, r.max)
+	for range attempts {
+		if d >= r.max/2 {
+			d = r.max
+			break
+		}
+		d *= 2
+	}
+	return d/2 + r.jitter(d/2)
+}
+
+// due marks the timer for address as fired and reports whether it should
+// still be dialed, which it should not if the peer came back in the meantime
+func (r *reconnectScheduler) due(address string) bool {
+	s, ok := r.retries[address]
+	if !ok || !s.pending {
+		return false
+	}
+	s.pending = false
+	return true
+}
+
+func (r *reconnectScheduler) reset(address string) {
+	delete(r.retries, address)
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	r := newReconnectScheduler(time.Second, 8*time.Second)
+	r.jitter = func(d time.Duration) time.Duration { return d }
+	address := "some-address:123"
+	now := time.Now()
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for _, e := range expected {
+		delay, ok := r.schedule(address, now)
+		if !ok {
+			t.Error("expected a reconnect to be scheduled")
+			return
+		}
+		if delay != e {
+			t.Error("expected delay", e, "got", delay)
+			return
+		}
+		if _, ok := r.schedule(address, now); ok {
+			t.Error("should not schedule while a reconnect is pending")
+			return
+		}
+		if !r.due(address) {
+			t.Error("expected pending reconnect to be due")
+			return
+		}
+	}
+
+	r.reset(address)
+	if r.due(address) {
+		t.Error("reset address should not be due")
+		return
+	}
+	if delay, _ := r.schedule(address, now); delay != time.Second {
+		t.Error("expected backoff to start over after reset, got", delay)
+		return
+	}
+}
+
+func TestReconnectJitter(t *testing.T) {
+	r := newReconnectScheduler(time.Second, time.Minute)
+	for attempts := range 10 {
+		d := min(time.Second<<attempts, time.Minute)
+		delay := r.delay(attempts)
+		if delay < d/2 || delay > d {
+			t.Error("delay", delay, "out of range for attempt", attempts)
+			return
+		}
+	}
+}
+
+func TestReconnectDelayStaysCappedAfterManyAttempts(t *testing.T) {
+	r := newReconnectScheduler(10*time.Second, 5*time.Minute)
+	r.jitter = func(d time.Duration) time.Duration { return d }
+	for _, attempts := range []int{31, 32, 40, 64, 1000} {
+		if delay := r.delay(attempts); delay != 5*time.Minute {
+			t.Error("expected the delay to stay at max for attempt", attempts, "got", delay)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package mgr
+
+import (
+	"tealfs/pkg/model"
+	"time"
+)
+
+// repairCheck is a read of another replica sent after a successful read so
+// the replica can be compared against the copy that was returned
+type repairCheck struct {
+	ptr     model.DiskPointer
+	blockId model.BlockId
+	// data is the copy that was returned, its Ptr is the replica it came from
+	data     model.RawData
+	deadline time.Time
+}
+
+type repairChecks map[model.ReqId]repairCheck
+
+// take stops tracking a check and returns it
+func (r repairChecks) take(id model.ReqId) (repairCheck, bool) {
+	check, ok := r[id]
+	delete(r, id)
+	return check, ok
+}
+
+func (r repairChecks) expire(now time.Time) {
+	for id, check := range r {
+		if now.After(check.deadline) {
+			delete(r, id)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+	"slices"
+)
+
+// BlockSummary identifies the copy of a block a node holds
+type BlockSummary struct {
+	BlockId  BlockId
+	Checksum []byte
+	Version  Version
+}
+
+func (b *BlockSummary) Equal(o *BlockSummary) bool {
+	return b.BlockId == o.BlockId && bytes.Equal(b.Checksum, o.Checksum) && b.Version == o.Version
+}
+
+func (b *BlockSummary) ToBytes() []byte {
+	blockId := StringToBytes(string(b.BlockId))
+	checksum := BytesToBytes(b.Checksum)
+	version := Int64ToBytes(int64(b.Version))
+	return bytes.Join([][]byte{blockId, checksum, version}, []byte{})
+}
+
+func ToBlockSummary(data []byte) (BlockSummary, []byte) {
+	blockId, remainder := StringFromBytes(data)
+	checksum, remainder := BytesFromBytes(remainder)
+	version, remainder := Int64FromBytes(remainder)
+	return BlockSummary{BlockId: BlockId(blockId), Checksum: checksum, Version: Version(version)}, remainder
+}
+
+// InventoryRequest asks the disk for a summary of every block it holds
+type InventoryRequest struct {
+	ReqId ReqId
+}
+
+type Inventory struct {
+	ReqId  ReqId
+	Blocks []BlockSummary
+	Err    error
+}
+
+// RangeHashes starts an anti-entropy exchange. It carries a hash for each
+// range of the blocks both nodes should hold.
+type RangeHashes struct {
+	ReqId  ReqId
+	Hashes [][]byte
+}
+
+func (r *RangeHashes) ToBytes() []byte {
+	result := StringToBytes(string(r.ReqId))
+	result = append(result, IntToBytes(uint32(len(r.Hashes)))...)
+	for _, h := range r.Hashes {
+		result = append(result, BytesToBytes(h)...)
+	}
+	return AddType(RangeHashesType, result)
+}
+
+func (r *RangeHashes) Equal(p Payload) bool {
+	if o, ok := p.(*RangeHashes); ok {
+		return r.ReqId == o.ReqId && slices.EqualFunc(r.Hashes, o.Hashes, bytes.Equal)
+	}
+	return false
+}
+
+func ToRangeHashes(data []byte) *RangeHashes {
+	reqId, remainder := StringFromBytes(data)
+	numHashes, remainder := IntFromBytes(remainder)
+	hashes := make([][]byte, 0, numHashes)
+	for range numHashes {
+		var h []byte
+		h, remainder = BytesFromBytes(remainder)
+		hashes = append(hashes, h)
+	}
+	return &RangeHashes{ReqId: ReqId(reqId), Hashes: hashes}
+}
+
+// RangeBlocks lists the blocks a node holds in the ranges whose hashes
+// differed. Reply is set when the receiver should answer with its own list.
+type RangeBlocks struct {
+	ReqId  ReqId
+	Ranges []uint32
+	Blocks []BlockSummary
+	Reply  bool
+}
+
+func (r *RangeBlocks) ToBytes() []byte {
+	result := StringToBytes(string(r.ReqId))
+	result = append(result, IntToBytes(uint32(len(r.Ranges)))...)
+	for _, i := range r.Ranges {
+		result = append(result, IntToBytes(i)...)
+	}
+	result = append(result, IntToBytes(uint32(len(r.Blocks)))...)
+	for _, b := range r.Blocks {
+		result = append(result, b.ToBytes()...)
+	}
+	result = append(result, BoolToBytes(r.Reply)...)
+	return AddType(RangeBlocksType, result)
+}
+
+func (r *RangeBlocks) Equal(p Payload) bool {
+	if o, ok := p.(*RangeBlocks); ok {
+		return r.ReqId == o.ReqId &&
+			r.Reply == o.Reply &&
+			slices.Equal(r.Ranges, o.Ranges) &&
+			slices.EqualFunc(r.Blocks, o.Blocks, func(a BlockSummary, b BlockSummary) bool { return a.Equal(&b) })
+	}
+	return false
+}
+
+func ToRangeBlocks(data []byte) *RangeBlocks {
+	reqId, remainder := StringFromBytes(data)
+	numRanges, remainder := IntFromBytes(remainder)
+	ranges := make([]uint32, 0, numRanges)
+	for range numRanges {
+		var i uint32
+		i, remainder = IntFromBytes(remainder)
+		ranges = append(ranges, i)
+	}
+	numBlocks, remainder := IntFromBytes(remainder)
+	blocks := make([]BlockSummary, 0, numBlocks)
+	for range numBlocks {
+		var b BlockSummary
+		b, remainder = ToBlockSummary(remainder)
+		blocks = append(blocks, b)
+	}
+	reply, _ := BoolFromBytes(remainder)
+	return &RangeBlocks{
+		ReqId:  ReqId(reqId),
+		Ranges: ranges,
+		Blocks: blocks,
+		Reply:  reply,
+	}
+}
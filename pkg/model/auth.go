@@ -0,0 +1,94 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import (
+	"bytes"
+)
+
+type AuthChallenge struct {
+	Nonce []byte
+}
+
+func (a *AuthChallenge) ToBytes() []byte {
+	return AddType(AuthChallengeType, BytesToBytes(a.Nonce))
+}
+
+func (a *AuthChallenge) Equal(p Payload) bool {
+	if o, ok := p.(*AuthChallenge); ok {
+		return bytes.Equal(a.Nonce, o.Nonce)
+	}
+	return false
+}
+
+func ToAuthChallenge(data []byte) *AuthChallenge {
+	nonce, _ := BytesFromBytes(data)
+	return &AuthChallenge{Nonce: nonce}
+}
+
+// AuthProof answers an AuthChallenge. TokenId is empty when the mac was
+// made with the cluster secret and names the join token otherwise.
+type AuthProof struct {
+	NodeId  NodeId
+	TokenId string
+	Mac     []byte
+}
+
+func (a *AuthProof) ToBytes() []byte {
+	nodeId := StringToBytes(string(a.NodeId))
+	tokenId := StringToBytes(a.TokenId)
+	mac := BytesToBytes(a.Mac)
+	return AddType(AuthProofType, bytes.Join([][]byte{nodeId, tokenId, mac}, []byte{}))
+}
+
+func (a *AuthProof) Equal(p Payload) bool {
+	if o, ok := p.(*AuthProof); ok {
+		return a.NodeId == o.NodeId && a.TokenId == o.TokenId && bytes.Equal(a.Mac, o.Mac)
+	}
+	return false
+}
+
+func ToAuthProof(data []byte) *AuthProof {
+	nodeId, remainder := StringFromBytes(data)
+	tokenId, remainder := StringFromBytes(remainder)
+	mac, _ := BytesFromBytes(remainder)
+	return &AuthProof{
+		NodeId:  NodeId(nodeId),
+		TokenId: tokenId,
+		Mac:     mac,
+	}
+}
+
+// JoinAccept hands the cluster secret to a node that proved it holds a
+// join token. The secret is wrapped with a key derived from the token.
+type JoinAccept struct {
+	WrappedSecret []byte
+}
+
+func (j *JoinAccept) ToBytes() []byte {
+	return AddType(JoinAcceptType, BytesToBytes(j.WrappedSecret))
+}
+
+func (j *JoinAccept) Equal(p Payload) bool {
+	if o, ok := p.(*JoinAccept); ok {
+		return bytes.Equal(j.WrappedSecret, o.WrappedSecret)
+	}
+	return false
+}
+
+func ToJoinAccept(data []byte) *JoinAccept {
+	wrapped, _ := BytesFromBytes(data)
+	return &JoinAccept{WrappedSecret: wrapped}
+}
@@ -16,6 +16,7 @@ package model
 
 import (
 	"bytes"
+	"errors"
 
 	"github.com/google/uuid"
 )
@@ -27,9 +28,20 @@ const (
 	XORed
 )
 
+// Version orders the writes of a block. It is a hybrid logical clock with
+// wall time in milliseconds in the high bits and a counter in the low bits.
+type Version uint64
+
+// ErrVersionConflict rejects a conditional write of a block that was
+// changed since the version the write was based on
+var ErrVersionConflict = errors.New("block was changed by another writer")
+
+// RawData is a block as stored on one disk. Version is not part of its
+// encoding, the payloads carrying it append the version at the end.
 type RawData struct {
-	Ptr  DiskPointer
-	Data []byte
+	Ptr     DiskPointer
+	Data    []byte
+	Version Version
 }
 
 func ToRawData(dataRaw []byte) (*RawData, []byte) {
@@ -54,7 +66,7 @@ func (b *RawData) Equals(o *RawData) bool {
 	if !bytes.Equal(b.Data, o.Data) {
 		return false
 	}
-	return true
+	return b.Version == o.Version
 }
 
 type DiskPointer struct {
@@ -94,20 +106,48 @@ func NewBlockId() BlockId {
 	return BlockId(idValue.String())
 }
 
+// ReqId identifies one read or write so its result finds its way back to
+// the caller even when other requests for the same block are in flight
+type ReqId string
+
+func NewReqId() ReqId {
+	idValue := uuid.New()
+	return ReqId(idValue.String())
+}
+
+type GetBlockReq struct {
+	ReqId   ReqId
+	BlockId BlockId
+	// Latest asks every replica rather than a read quorum
+	Latest bool
+}
+
+type PutBlockReq struct {
+	ReqId ReqId
+	Block Block
+}
+
 type BlockIdResponse struct {
 	BlockId BlockId
+	ReqId   ReqId
 	Err     error
 }
 
 type BlockResponse struct {
 	Block Block
+	ReqId ReqId
 	Err   error
 }
 
+// Block is what webdav reads and writes. Version is the version a read
+// returned, a write with IfVersion set only succeeds if no replica holds a
+// newer version than Version.
 type Block struct {
-	Id   BlockId
-	Type BlockType
-	Data []byte
+	Id        BlockId
+	Type      BlockType
+	Data      []byte
+	Version   Version
+	IfVersion bool
 }
 
 func (r *Block) Equal(o *Block) bool {
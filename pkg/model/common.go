@@ -25,6 +25,14 @@ func StringFromBytes(data []byte) (string, []byte) {
 	return utfString, data[length:]
 }
 
+// OptionalStringFromBytes reads a trailing string that older nodes don't send
+func OptionalStringFromBytes(data []byte) (string, []byte) {
+	if len(data) == 0 {
+		return "", data
+	}
+	return StringFromBytes(data)
+}
+
 func StringToBytes(value string) []byte {
 	rawString := []byte(value)
 	length := uint32(len(rawString))
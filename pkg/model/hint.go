@@ -0,0 +1,65 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import "strings"
+
+// hintPrefix starts the file name of a block kept for a replica whose node
+// was offline. It's followed by the owner and the file name the block has
+// on the owner, node ids don't contain dots.
+const hintPrefix = "hint."
+
+// HintPointer is where holder keeps the write meant for ptr
+func HintPointer(holder NodeId, ptr DiskPointer) DiskPointer {
+	return DiskPointer{NodeId: holder, FileName: hintPrefix + string(ptr.NodeId) + "." + ptr.FileName}
+}
+
+// IsHint is whether a file holds a hint instead of a block of its own
+func IsHint(fileName string) bool {
+	return strings.HasPrefix(fileName, hintPrefix)
+}
+
+// HintedPointer is the pointer a hint's file was written for
+func HintedPointer(fileName string) (DiskPointer, bool) {
+	rest, ok := strings.CutPrefix(fileName, hintPrefix)
+	if !ok {
+		return DiskPointer{}, false
+	}
+	owner, name, ok := strings.Cut(rest, ".")
+	if !ok || owner == "" || name == "" {
+		return DiskPointer{}, false
+	}
+	return DiskPointer{NodeId: NodeId(owner), FileName: name}, true
+}
+
+// HintsRequest asks the disk for the hints it keeps for a node, or for every
+// node when Owner is empty
+type HintsRequest struct {
+	ReqId ReqId
+	Owner NodeId
+}
+
+// Hints are the blocks a disk keeps for Owner, under their hint file names
+type Hints struct {
+	ReqId  ReqId
+	Owner  NodeId
+	Blocks []RawData
+	Err    error
+}
+
+// DeleteHint drops a hint once it was delivered
+type DeleteHint struct {
+	Ptr DiskPointer
+}
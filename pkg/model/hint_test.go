@@ -0,0 +1,43 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package model_test
+
+import (
+	"tealfs/pkg/model"
+	"testing"
+)
+
+func TestHintPointer(t *testing.T) {
+	holder := model.NewNodeId()
+	ptr := model.DiskPointer{NodeId: model.NewNodeId(), FileName: "fileName"}
+	hint := model.HintPointer(holder, ptr)
+	if hint.NodeId != holder || !model.IsHint(hint.FileName) {
+		t.Error("expected the hint to be kept on the holder", hint)
+		return
+	}
+	hinted, ok := model.HintedPointer(hint.FileName)
+	if !ok || !hinted.Equals(&ptr) {
+		t.Error("expected the hinted pointer back", hinted)
+		return
+	}
+	if model.IsHint(ptr.FileName) {
+		t.Error("a block isn't a hint")
+		return
+	}
+	if _, ok := model.HintedPointer("hint.nodeOnly"); ok {
+		t.Error("expected a malformed hint to be refused")
+		return
+	}
+}
@@ -17,21 +17,32 @@ package model
 import "bytes"
 
 type IAm struct {
-	NodeId    NodeId
-	Address   string
-	FreeBytes uint32
+	NodeId      NodeId
+	Address     string
+	FreeBytes   uint32
+	ProtocolMin ProtocolVersion
+	ProtocolMax ProtocolVersion
+	Features    Features
 }
 
 func (h *IAm) ToBytes() []byte {
 	nodeId := StringToBytes(string(h.NodeId))
 	address := StringToBytes(h.Address)
 	freeByes := IntToBytes(h.FreeBytes)
-	return AddType(IAmType, bytes.Join([][]byte{nodeId, address, freeByes}, []byte{}))
+	protocolMin := IntToBytes(uint32(h.ProtocolMin))
+	protocolMax := IntToBytes(uint32(h.ProtocolMax))
+	features := IntToBytes(uint32(h.Features))
+	return AddType(IAmType, bytes.Join([][]byte{nodeId, address, freeByes, protocolMin, protocolMax, features}, []byte{}))
 }
 
 func (h *IAm) Equal(p Payload) bool {
 	if h2, ok := p.(*IAm); ok {
-		return h2.NodeId == h.NodeId && h2.Address == h.Address && h2.FreeBytes == h.FreeBytes
+		return h2.NodeId == h.NodeId &&
+			h2.Address == h.Address &&
+			h2.FreeBytes == h.FreeBytes &&
+			h2.ProtocolMin == h.ProtocolMin &&
+			h2.ProtocolMax == h.ProtocolMax &&
+			h2.Features == h.Features
 	}
 	return false
 }
@@ -39,10 +50,25 @@ func (h *IAm) Equal(p Payload) bool {
 func ToHello(data []byte) *IAm {
 	rawId, remainder := StringFromBytes(data)
 	rawAddress, remainder := StringFromBytes(remainder)
-	rawFreeBytes, _ := IntFromBytes(remainder)
-	return &IAm{
-		NodeId:    NodeId(rawId),
-		Address:   rawAddress,
-		FreeBytes: rawFreeBytes,
+	rawFreeBytes, remainder := IntFromBytes(remainder)
+	result := IAm{
+		NodeId:      NodeId(rawId),
+		Address:     rawAddress,
+		FreeBytes:   rawFreeBytes,
+		ProtocolMin: ProtocolVersionLegacy,
+		ProtocolMax: ProtocolVersionLegacy,
+		Features:    NoFeatures,
 	}
+	// Legacy nodes stop after FreeBytes. They speak ProtocolVersionLegacy,
+	// which is outside the range this build accepts, so they're refused.
+	if len(remainder) < 12 {
+		return &result
+	}
+	rawMin, remainder := IntFromBytes(remainder)
+	rawMax, remainder := IntFromBytes(remainder)
+	rawFeatures, _ := IntFromBytes(remainder)
+	result.ProtocolMin = ProtocolVersion(rawMin)
+	result.ProtocolMax = ProtocolVersion(rawMax)
+	result.Features = Features(rawFeatures)
+	return &result
 }
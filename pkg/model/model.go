@@ -28,23 +28,51 @@ type UiConnectionStatus struct {
 }
 
 type NetConnectionStatus struct {
-	Type ConnectedStatus
-	Msg  string
-	Id   ConnId
+	Type    ConnectedStatus
+	Msg     string
+	Id      ConnId
+	Address string
 }
 type ConnectedStatus int
 
 const (
 	Connected ConnectedStatus = iota
 	NotConnected
+	Refused
+	Congested
+	Suspect
+	Dead
 )
 
+func (c ConnectedStatus) String() string {
+	switch c {
+	case Connected:
+		return "Connected"
+	case NotConnected:
+		return "Not Connected"
+	case Refused:
+		return "Refused"
+	case Congested:
+		return "Congested"
+	case Suspect:
+		return "Suspect"
+	case Dead:
+		return "Dead"
+	default:
+		return "Unknown"
+	}
+}
+
 type ConnId int32
 
 type MgrConnsConnectTo struct {
 	Address string
 }
 
+type MgrConnsDisconnect struct {
+	ConnId ConnId
+}
+
 type MgrConnsSend struct {
 	ConnId  ConnId
 	Payload Payload
@@ -61,6 +89,9 @@ func (m *MgrConnsSend) Equal(o *MgrConnsSend) bool {
 type ConnsMgrReceive struct {
 	ConnId  ConnId
 	Payload Payload
+	// Bounced payloads are our own sends that conns couldn't deliver, they
+	// say nothing about the peer being alive
+	Bounced bool
 }
 
 type MgrDiskSave struct {
@@ -69,7 +100,12 @@ type MgrDiskSave struct {
 }
 
 type UiMgrConnectTo struct {
-	Address string
+	Address   string
+	JoinToken string
+}
+
+type UiMgrJoinToken struct {
+	Resp chan string
 }
 
 type NodeId string
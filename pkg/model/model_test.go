@@ -15,6 +15,7 @@
 package model_test
 
 import (
+	"bytes"
 	"tealfs/pkg/model"
 	"testing"
 )
@@ -76,6 +77,7 @@ func TestReadResult(t *testing.T) {
 			Data: []byte{1, 2, 3},
 		},
 		BlockId: "blockId",
+		ReqId:   "reqId",
 	}
 	rr2 := model.ReadResult{
 		Ok:      false,
@@ -99,6 +101,7 @@ func TestReadResult(t *testing.T) {
 			Data: []byte{1, 2, 3},
 		},
 		BlockId: "blockId",
+		ReqId:   "reqId",
 	}
 	if rr1.Equal(&rr2) {
 		t.Error("should not be equal")
@@ -116,6 +119,14 @@ func TestReadResult(t *testing.T) {
 	if !rr1.Equal(rr3) {
 		t.Error("should be equal")
 	}
+
+	rr1.Ok = false
+	rr1.NotFound = true
+	bytes1 = rr1.ToBytes()
+	rr3 = model.ToReadResult(bytes1[1:])
+	if !rr3.NotFound || !rr1.Equal(rr3) {
+		t.Error("expected not found to survive the round trip")
+	}
 }
 
 func TestWriteResult(t *testing.T) {
@@ -127,6 +138,7 @@ func TestWriteResult(t *testing.T) {
 			NodeId:   "nodeId",
 			FileName: "fileName",
 		},
+		ReqId: "reqId",
 	}
 	wr2 := model.WriteResult{
 		Ok:      true,
@@ -136,6 +148,7 @@ func TestWriteResult(t *testing.T) {
 			NodeId:   "nodeId",
 			FileName: "fileName",
 		},
+		ReqId: "reqId",
 	}
 
 	if wr1.Equal(&wr2) {
@@ -157,6 +170,14 @@ func TestWriteResult(t *testing.T) {
 		t.Error("should be equal")
 		return
 	}
+
+	wr1.Ok = false
+	wr1.Conflict = true
+	rr4 := model.ToWriteResult(wr1.ToBytes()[1:])
+	if !rr4.Conflict || !wr1.Equal(rr4) {
+		t.Error("conflict should survive serialization")
+		return
+	}
 }
 
 func TestReadRequest(t *testing.T) {
@@ -169,6 +190,7 @@ func TestReadRequest(t *testing.T) {
 			},
 		},
 		BlockId: "blockId1",
+		ReqId:   "reqId1",
 	}
 	rr2 := model.ReadRequest{
 		Caller: "caller1",
@@ -179,6 +201,7 @@ func TestReadRequest(t *testing.T) {
 			},
 		},
 		BlockId: "blockId2",
+		ReqId:   "reqId1",
 	}
 
 	if rr1.Equal(&rr2) {
@@ -201,3 +224,115 @@ func TestReadRequest(t *testing.T) {
 		return
 	}
 }
+
+func TestIAm(t *testing.T) {
+	iam1 := model.IAm{
+		NodeId:      "node1",
+		Address:     "address:123",
+		FreeBytes:   10,
+		ProtocolMin: 1,
+		ProtocolMax: 2,
+		Features:    3,
+	}
+
+	bytes1 := iam1.ToBytes()
+	iam2 := model.ToHello(bytes1[1:])
+
+	if !iam1.Equal(iam2) {
+		t.Error("should be equal")
+		return
+	}
+
+	legacy := bytes.Join([][]byte{
+		model.StringToBytes("node1"),
+		model.StringToBytes("address:123"),
+		model.IntToBytes(10),
+	}, []byte{})
+	iam3 := model.ToHello(legacy)
+
+	if iam3.ProtocolMin != model.ProtocolVersionLegacy || iam3.ProtocolMax != model.ProtocolVersionLegacy {
+		t.Error("legacy IAm should decode as the legacy protocol version")
+		return
+	}
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+	local := model.IAm{ProtocolMin: 1, ProtocolMax: 3, Features: 5}
+
+	protocol, err := model.NegotiateProtocol(&local, &model.IAm{ProtocolMin: 2, ProtocolMax: 4, Features: 4})
+	if err != nil {
+		t.Error("should be compatible", err)
+		return
+	}
+	if protocol.Features != 4 {
+		t.Error("unexpected protocol", protocol)
+		return
+	}
+
+	_, err = model.NegotiateProtocol(&local, &model.IAm{ProtocolMin: 4, ProtocolMax: 5})
+	if err == nil {
+		t.Error("should not be compatible")
+		return
+	}
+
+	current := model.IAm{ProtocolMin: model.ProtocolVersionMin, ProtocolMax: model.ProtocolVersionMax}
+	legacy := model.IAm{ProtocolMin: model.ProtocolVersionLegacy, ProtocolMax: model.ProtocolVersionLegacy}
+	_, err = model.NegotiateProtocol(&current, &legacy)
+	if err == nil {
+		t.Error("legacy nodes encode payloads differently and should not be compatible")
+		return
+	}
+}
+
+func TestReadRequestWithoutReqId(t *testing.T) {
+	rr1 := model.ReadRequest{
+		Caller: "caller1",
+		Ptrs: []model.DiskPointer{
+			{
+				NodeId:   "nodeId1",
+				FileName: "filename1",
+			},
+		},
+		BlockId: "blockId1",
+	}
+
+	// older nodes don't send the trailing request id at all
+	raw := rr1.ToBytes()
+	legacy := raw[1 : len(raw)-4]
+	rr2 := model.ToReadRequest(legacy)
+
+	if !rr1.Equal(rr2) {
+		t.Error("should be equal", rr2)
+	}
+}
+
+func TestRangeHashes(t *testing.T) {
+	rh := model.RangeHashes{
+		ReqId:  "reqId",
+		Hashes: [][]byte{{1, 2}, {}, {3}},
+	}
+	parsed := model.ToPayload(rh.ToBytes())
+	if !rh.Equal(parsed) {
+		t.Error("should be equal", parsed)
+	}
+}
+
+func TestRangeBlocks(t *testing.T) {
+	rb := model.RangeBlocks{
+		ReqId:  "reqId",
+		Ranges: []uint32{1, 7},
+		Blocks: []model.BlockSummary{
+			{BlockId: "block1", Checksum: []byte{1, 2, 3}},
+			{BlockId: "block2", Checksum: []byte{4}},
+		},
+		Reply: true,
+	}
+	parsed := model.ToPayload(rb.ToBytes())
+	if !rb.Equal(parsed) {
+		t.Error("should be equal", parsed)
+	}
+	rb.Reply = false
+	if rb.Equal(parsed) {
+		t.Error("should not be equal")
+	}
+}
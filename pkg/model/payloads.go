@@ -15,13 +15,18 @@
 package model
 
 const (
-	NoOpType         = uint8(0)
-	IAmType          = uint8(1)
-	SyncType         = uint8(2)
-	WriteRequestType = uint8(3)
-	WriteResultType  = uint8(4)
-	ReadRequestType  = uint8(5)
-	ReadResultType   = uint8(6)
+	NoOpType          = uint8(0)
+	IAmType           = uint8(1)
+	SyncType          = uint8(2)
+	WriteRequestType  = uint8(3)
+	WriteResultType   = uint8(4)
+	ReadRequestType   = uint8(5)
+	ReadResultType    = uint8(6)
+	AuthChallengeType = uint8(7)
+	AuthProofType     = uint8(8)
+	JoinAcceptType    = uint8(9)
+	RangeHashesType   = uint8(10)
+	RangeBlocksType   = uint8(11)
 )
 
 type Payload interface {
@@ -43,6 +48,16 @@ func ToPayload(data []byte) Payload {
 		return ToReadRequest(payloadData(data))
 	case ReadResultType:
 		return ToReadResult(payloadData(data))
+	case AuthChallengeType:
+		return ToAuthChallenge(payloadData(data))
+	case AuthProofType:
+		return ToAuthProof(payloadData(data))
+	case JoinAcceptType:
+		return ToJoinAccept(payloadData(data))
+	case RangeHashesType:
+		return ToRangeHashes(payloadData(data))
+	case RangeBlocksType:
+		return ToRangeBlocks(payloadData(data))
 	default:
 		return ToNoOp(payloadData(data))
 	}
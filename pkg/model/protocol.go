@@ -0,0 +1,71 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package model
+
+import "fmt"
+
+// ProtocolVersion numbers the way payloads are encoded. It goes up whenever
+// the encoding of an existing payload changes, nodes whose ranges don't
+// overlap refuse each other instead of misreading what they send. New
+// payloads and behaviour come with a feature bit instead.
+type ProtocolVersion uint32
+
+const (
+	// ProtocolVersionLegacy is assumed for peers whose IAm carries no version range
+	ProtocolVersionLegacy ProtocolVersion = 1
+	// ProtocolVersionMin is the oldest encoding this build can read, only
+	// the current one
+	ProtocolVersionMin ProtocolVersion = 3
+	ProtocolVersionMax ProtocolVersion = 3
+)
+
+type Features uint32
+
+const (
+	NoFeatures               Features = 0
+	FeatureAuth              Features = 1 << 0
+	FeatureHeartbeat         Features = 1 << 1
+	FeatureAntiEntropy       Features = 1 << 2
+	FeatureHints             Features = 1 << 3
+	FeatureConditionalWrites Features = 1 << 4
+)
+
+// SupportedFeatures is the set of optional features this build understands
+const SupportedFeatures = FeatureAuth | FeatureHeartbeat | FeatureAntiEntropy | FeatureHints | FeatureConditionalWrites
+
+func (f Features) Has(o Features) bool {
+	return f&o == o
+}
+
+// Protocol is what two nodes agreed on in their handshake. Their version
+// ranges only have to overlap, what either of them does differently is
+// decided by the features they share.
+type Protocol struct {
+	Features Features
+}
+
+func NegotiateProtocol(local *IAm, remote *IAm) (Protocol, error) {
+	low := max(local.ProtocolMin, remote.ProtocolMin)
+	high := min(local.ProtocolMax, remote.ProtocolMax)
+	if low > high {
+		return Protocol{}, fmt.Errorf(
+			"incompatible protocol: local supports %d-%d, remote supports %d-%d",
+			local.ProtocolMin, local.ProtocolMax, remote.ProtocolMin, remote.ProtocolMax,
+		)
+	}
+	return Protocol{
+		Features: local.Features & remote.Features,
+	}, nil
+}
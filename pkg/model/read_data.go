@@ -22,6 +22,7 @@ type ReadRequest struct {
 	Caller  NodeId
 	Ptrs    []DiskPointer
 	BlockId BlockId
+	ReqId   ReqId
 }
 
 func (r *ReadRequest) ToBytes() []byte {
@@ -32,7 +33,8 @@ func (r *ReadRequest) ToBytes() []byte {
 		ptrs = append(ptrs, ptr.ToBytes()...)
 	}
 	blockId := StringToBytes(string(r.BlockId))
-	return AddType(ReadRequestType, bytes.Join([][]byte{callerId, ptrLen, ptrs, blockId}, []byte{}))
+	reqId := StringToBytes(string(r.ReqId))
+	return AddType(ReadRequestType, bytes.Join([][]byte{callerId, ptrLen, ptrs, blockId, reqId}, []byte{}))
 }
 
 func (r *ReadRequest) Equal(p Payload) bool {
@@ -48,7 +50,7 @@ func (r *ReadRequest) Equal(p Payload) bool {
 				return false
 			}
 		}
-		return r.BlockId == o.BlockId
+		return r.BlockId == o.BlockId && r.ReqId == o.ReqId
 	}
 	return false
 }
@@ -62,11 +64,13 @@ func ToReadRequest(data []byte) *ReadRequest {
 		ptr, remainder = ToDiskPointer(remainder)
 		ptrs = append(ptrs, *ptr)
 	}
-	blockId, _ := StringFromBytes(remainder)
+	blockId, remainder := StringFromBytes(remainder)
+	reqId, _ := OptionalStringFromBytes(remainder)
 	rq := ReadRequest{
 		Caller:  NodeId(callerId),
 		Ptrs:    ptrs,
 		BlockId: BlockId(blockId),
+		ReqId:   ReqId(reqId),
 	}
 	return &rq
 }
@@ -25,6 +25,9 @@ type ReadResult struct {
 	Ptrs    []DiskPointer
 	Data    RawData
 	BlockId BlockId
+	ReqId   ReqId
+	// NotFound is set when the replica has no copy of the block
+	NotFound bool
 }
 
 func (r *ReadResult) Equal(p Payload) bool {
@@ -53,6 +56,12 @@ func (r *ReadResult) Equal(p Payload) bool {
 		if r.BlockId != o.BlockId {
 			return false
 		}
+		if r.ReqId != o.ReqId {
+			return false
+		}
+		if r.NotFound != o.NotFound {
+			return false
+		}
 
 		return true
 	}
@@ -70,7 +79,10 @@ func (r *ReadResult) ToBytes() []byte {
 	}
 	raw := r.Data.ToBytes()
 	blockId := StringToBytes(string(r.BlockId))
-	payload := bytes.Join([][]byte{ok, message, caller, numPtrs, ptrs, raw, blockId}, []byte{})
+	reqId := StringToBytes(string(r.ReqId))
+	version := Int64ToBytes(int64(r.Data.Version))
+	notFound := BoolToBytes(r.NotFound)
+	payload := bytes.Join([][]byte{ok, message, caller, numPtrs, ptrs, raw, blockId, reqId, version, notFound}, []byte{})
 	return AddType(ReadResultType, payload)
 }
 
@@ -86,13 +98,22 @@ func ToReadResult(data []byte) *ReadResult {
 		ptrs = append(ptrs, *ptr)
 	}
 	raw, remainder := ToRawData(remainder)
-	blockId, _ := StringFromBytes(remainder)
+	blockId, remainder := StringFromBytes(remainder)
+	reqId, remainder := OptionalStringFromBytes(remainder)
+	version, remainder := Int64FromBytes(remainder)
+	raw.Version = Version(version)
+	notFound := false
+	if len(remainder) > 0 {
+		notFound, _ = BoolFromBytes(remainder)
+	}
 	return &ReadResult{
-		Ok:      ok,
-		Message: message,
-		Caller:  NodeId(caller),
-		Ptrs:    ptrs,
-		Data:    *raw,
-		BlockId: BlockId(blockId),
+		Ok:       ok,
+		Message:  message,
+		Caller:   NodeId(caller),
+		Ptrs:     ptrs,
+		Data:     *raw,
+		BlockId:  BlockId(blockId),
+		ReqId:    ReqId(reqId),
+		NotFound: notFound,
 	}
 }
@@ -18,9 +18,15 @@ import (
 	"bytes"
 )
 
+// WriteRequest saves a block. A Conditional one is refused with
+// ErrVersionConflict when the disk holds a version newer than Expected, only
+// nodes with FeatureConditionalWrites get those.
 type WriteRequest struct {
-	Caller NodeId
-	Data   RawData
+	Caller      NodeId
+	Data        RawData
+	ReqId       ReqId
+	Conditional bool
+	Expected    Version
 }
 
 func (r *WriteRequest) Equal(p Payload) bool {
@@ -28,6 +34,12 @@ func (r *WriteRequest) Equal(p Payload) bool {
 		if r.Caller != o.Caller {
 			return false
 		}
+		if r.ReqId != o.ReqId {
+			return false
+		}
+		if r.Conditional != o.Conditional || r.Expected != o.Expected {
+			return false
+		}
 		return r.Data.Equals(&o.Data)
 	}
 	return false
@@ -36,15 +48,32 @@ func (r *WriteRequest) Equal(p Payload) bool {
 func (r *WriteRequest) ToBytes() []byte {
 	caller := StringToBytes(string(r.Caller))
 	rawData := r.Data.ToBytes()
-	payload := bytes.Join([][]byte{caller, rawData}, []byte{})
-	return AddType(WriteRequestType, payload)
+	reqId := StringToBytes(string(r.ReqId))
+	version := Int64ToBytes(int64(r.Data.Version))
+	fields := [][]byte{caller, rawData, reqId, version}
+	if r.Conditional {
+		// unconditional writes keep the layout older nodes know
+		fields = append(fields, Int64ToBytes(int64(r.Expected)), BoolToBytes(r.Conditional))
+	}
+	return AddType(WriteRequestType, bytes.Join(fields, []byte{}))
 }
 
 func ToWriteRequest(raw []byte) *WriteRequest {
 	caller, remainder := StringFromBytes(raw)
-	rawData, _ := ToRawData(remainder)
+	rawData, remainder := ToRawData(remainder)
+	reqId, remainder := OptionalStringFromBytes(remainder)
+	version, remainder := Int64FromBytes(remainder)
+	rawData.Version = Version(version)
+	expected, remainder := Int64FromBytes(remainder)
+	conditional := false
+	if len(remainder) > 0 {
+		conditional, _ = BoolFromBytes(remainder)
+	}
 	return &WriteRequest{
-		Caller: NodeId(caller),
-		Data:   *rawData,
+		Caller:      NodeId(caller),
+		Data:        *rawData,
+		ReqId:       ReqId(reqId),
+		Conditional: conditional,
+		Expected:    Version(expected),
 	}
 }
@@ -27,8 +27,46 @@ func TestWriteRequest(t *testing.T) {
 				NodeId:   "node1",
 				FileName: "fileName1",
 			},
-			Data: []byte{0x01, 0x02, 0x03},
+			Data:    []byte{0x01, 0x02, 0x03},
+			Version: 42,
 		},
+		ReqId: "reqId1",
+	}
+	raw := wr.ToBytes()
+	newWr := model.ToWriteRequest(raw[1:])
+	if !wr.Equal(newWr) {
+		t.Errorf("Expected %v, got %v", wr, newWr)
+	}
+}
+
+func TestWriteRequestWithoutVersion(t *testing.T) {
+	wr := model.WriteRequest{
+		Caller: "caller1",
+		Data: model.RawData{
+			Ptr:  model.DiskPointer{NodeId: "node1", FileName: "fileName1"},
+			Data: []byte{0x01},
+		},
+		ReqId: "reqId1",
+	}
+	raw := wr.ToBytes()
+	// older nodes end the payload after the request id
+	newWr := model.ToWriteRequest(raw[1 : len(raw)-8])
+	if !wr.Equal(newWr) || newWr.Data.Version != 0 {
+		t.Errorf("Expected %v, got %v", wr, newWr)
+	}
+}
+
+func TestConditionalWriteRequest(t *testing.T) {
+	wr := model.WriteRequest{
+		Caller: "caller1",
+		Data: model.RawData{
+			Ptr:     model.DiskPointer{NodeId: "node1", FileName: "fileName1"},
+			Data:    []byte{0x01},
+			Version: 43,
+		},
+		ReqId:       "reqId1",
+		Conditional: true,
+		Expected:    42,
 	}
 	raw := wr.ToBytes()
 	newWr := model.ToWriteRequest(raw[1:])
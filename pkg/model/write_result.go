@@ -23,6 +23,9 @@ type WriteResult struct {
 	Message string
 	Caller  NodeId
 	Ptr     DiskPointer
+	ReqId   ReqId
+	// Conflict is set when a conditional write found a newer version
+	Conflict bool
 }
 
 func (r *WriteResult) Equal(p Payload) bool {
@@ -39,7 +42,10 @@ func (r *WriteResult) Equal(p Payload) bool {
 		if !r.Ptr.Equals(&o.Ptr) {
 			return false
 		}
-		return true
+		if r.ReqId != o.ReqId {
+			return false
+		}
+		return r.Conflict == o.Conflict
 	}
 	return false
 }
@@ -49,8 +55,10 @@ func (r *WriteResult) ToBytes() []byte {
 	message := StringToBytes(r.Message)
 	caller := StringToBytes(string(r.Caller))
 	ptr := r.Ptr.ToBytes()
+	reqId := StringToBytes(string(r.ReqId))
+	conflict := BoolToBytes(r.Conflict)
 
-	payload := bytes.Join([][]byte{ok, message, caller, ptr}, []byte{})
+	payload := bytes.Join([][]byte{ok, message, caller, ptr, reqId, conflict}, []byte{})
 	return AddType(WriteResultType, payload)
 }
 
@@ -58,11 +66,18 @@ func ToWriteResult(data []byte) *WriteResult {
 	ok, remainder := BoolFromBytes(data)
 	message, remainder := StringFromBytes(remainder)
 	caller, remainder := StringFromBytes(remainder)
-	ptr, _ := ToDiskPointer(remainder)
+	ptr, remainder := ToDiskPointer(remainder)
+	reqId, remainder := OptionalStringFromBytes(remainder)
+	conflict := false
+	if len(remainder) > 0 {
+		conflict, _ = BoolFromBytes(remainder)
+	}
 	return &WriteResult{
-		Ok:      ok,
-		Message: message,
-		Caller:  NodeId(caller),
-		Ptr:     *ptr,
+		Ok:       ok,
+		Message:  message,
+		Caller:   NodeId(caller),
+		Ptr:      *ptr,
+		ReqId:    ReqId(reqId),
+		Conflict: conflict,
 	}
 }
@@ -32,10 +32,16 @@ func (b *Bimap[K, J]) Add(item1 K, item2 J) {
 }
 
 func (b *Bimap[K, J]) Remove1(item K) {
+	if other, ok := b.dataKj[item]; ok {
+		delete(b.dataJk, other)
+	}
 	delete(b.dataKj, item)
 }
 
 func (b *Bimap[K, J]) Remove2(item J) {
+	if other, ok := b.dataJk[item]; ok {
+		delete(b.dataKj, other)
+	}
 	delete(b.dataJk, item)
 }
 
@@ -16,7 +16,9 @@ package ui
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"html"
 	"net/http"
 	"strings"
 	"sync"
@@ -24,20 +26,24 @@ import (
 )
 
 type Ui struct {
-	connToReq  chan model.UiMgrConnectTo
-	connToResp chan model.UiConnectionStatus
-	statuses   map[model.NodeId]model.UiConnectionStatus
-	sMux       sync.Mutex
-	ops        HtmlOps
+	connToReq     chan model.UiMgrConnectTo
+	joinTokenReq  chan model.UiMgrJoinToken
+	connToResp    chan model.UiConnectionStatus
+	statuses      map[model.NodeId]model.UiConnectionStatus
+	sMux          sync.Mutex
+	ops           HtmlOps
+	adminPassword string
 }
 
-func NewUi(connToReq chan model.UiMgrConnectTo, connToResp chan model.UiConnectionStatus, ops HtmlOps, bindAddr string, ctx context.Context) *Ui {
+func NewUi(connToReq chan model.UiMgrConnectTo, joinTokenReq chan model.UiMgrJoinToken, connToResp chan model.UiConnectionStatus, ops HtmlOps, adminPassword string, bindAddr string, ctx context.Context) *Ui {
 	statuses := make(map[model.NodeId]model.UiConnectionStatus)
 	ui := Ui{
-		connToReq:  connToReq,
-		connToResp: connToResp,
-		statuses:   statuses,
-		ops:        ops,
+		connToReq:     connToReq,
+		joinTokenReq:  joinTokenReq,
+		connToResp:    connToResp,
+		statuses:      statuses,
+		ops:           ops,
+		adminPassword: adminPassword,
 	}
 	ui.registerHttpHandlers()
 	ui.handleRoot()
@@ -75,8 +81,38 @@ func (ui *Ui) registerHttpHandlers() {
 			return
 		}
 		hostAndPort := r.FormValue("hostAndPort")
-		ui.connToReq <- model.UiMgrConnectTo{Address: hostAndPort}
+		joinToken := r.FormValue("joinToken")
+		ui.connToReq <- model.UiMgrConnectTo{Address: hostAndPort, JoinToken: joinToken}
 	})
+	ui.ops.HandleFunc("/join-token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		if !ui.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tealfs"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		resp := make(chan string)
+		ui.joinTokenReq <- model.UiMgrJoinToken{Resp: resp}
+		token := <-resp
+		if token == "" {
+			http.Error(w, "Unable to create join token", http.StatusInternalServerError)
+			return
+		}
+		_, _ = fmt.Fprint(w, token)
+	})
+}
+
+// isAdmin checks the request carries the admin password, anyone who can
+// reach the ui could let a node into the cluster otherwise
+func (ui *Ui) isAdmin(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != "admin" || ui.adminPassword == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(ui.adminPassword)) == 1
 }
 
 func (ui *Ui) htmlStatus(divId string) string {
@@ -89,6 +125,11 @@ func (ui *Ui) htmlStatus(divId string) string {
 		builder.WriteString(string(value.RemoteAddress))
 		builder.WriteString(" ")
 		builder.WriteString(fmt.Sprint(value.Type))
+		if value.Msg != "" {
+			builder.WriteString(" (")
+			builder.WriteString(html.EscapeString(value.Msg))
+			builder.WriteString(")")
+		}
 		builder.WriteString("<br />")
 	}
 	ui.sMux.Unlock()
@@ -113,8 +154,15 @@ func (ui *Ui) handleRoot() {
 					<form hx-put="/connect-to">
 						<label for="textbox">Host and port:</label>
 						<input type="text" id="hostAndPort" name="hostAndPort">
+						<label for="joinToken">Join token from the other cluster, if joining one:</label>
+						<input type="text" id="joinToken" name="joinToken">
 						<input type="submit" value="Connect">
 					</form>
+					<p>Create a one-time token that lets a new node join this cluster</p>
+					<form hx-post="/join-token" hx-target="#newJoinToken">
+						<input type="submit" value="Create join token">
+					</form>
+					<pre id="newJoinToken"></pre>
 					` + ui.htmlStatus("status") + `
 				</main>
 			</body>
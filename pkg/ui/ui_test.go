@@ -27,7 +27,7 @@ import (
 func TestListenAddress(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	_, _, _, ops := NewUi(ctx)
+	_, _, _, _, ops := NewUi(ctx)
 	if ops.BindAddr != "mockBindAddr:123" {
 		t.Error("Didn't bind to mockBindAddr:123")
 	}
@@ -36,7 +36,7 @@ func TestListenAddress(t *testing.T) {
 func TestConnectTo(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	_, connToReq, _, ops := NewUi(ctx)
+	_, connToReq, _, _, ops := NewUi(ctx)
 	mockResponseWriter := ui.MockResponseWriter{}
 	request := http.Request{
 		Method:   http.MethodPut,
@@ -51,10 +51,49 @@ func TestConnectTo(t *testing.T) {
 	}
 }
 
+func TestJoinToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, joinTokenReq, _, ops := NewUi(ctx)
+	mockResponseWriter := ui.MockResponseWriter{}
+	request := http.Request{Method: http.MethodPost, Header: make(http.Header)}
+	request.SetBasicAuth("admin", "admin password")
+
+	go func() {
+		req := <-joinTokenReq
+		req.Resp <- "some-token"
+	}()
+	ops.Handlers["/join-token"](&mockResponseWriter, &request)
+
+	if mockResponseWriter.WrittenData != "some-token" {
+		t.Error("expected join token to be written, got", mockResponseWriter.WrittenData)
+	}
+}
+
+func TestJoinTokenNeedsAdmin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, joinTokenReq, _, ops := NewUi(ctx)
+	mockResponseWriter := ui.MockResponseWriter{}
+	request := http.Request{Method: http.MethodPost, Header: make(http.Header)}
+	request.SetBasicAuth("admin", "wrong password")
+
+	ops.Handlers["/join-token"](&mockResponseWriter, &request)
+
+	select {
+	case <-joinTokenReq:
+		t.Error("expected no join token to be made")
+	default:
+	}
+	if strings.Contains(mockResponseWriter.WrittenData, "token") {
+		t.Error("expected no join token to be written, got", mockResponseWriter.WrittenData)
+	}
+}
+
 func TestStatus(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	_, _, connToResp, ops := NewUi(ctx)
+	_, _, _, connToResp, ops := NewUi(ctx)
 	mockResponseWriter := ui.MockResponseWriter{}
 	request := http.Request{
 		Method:   http.MethodGet,
@@ -95,13 +134,14 @@ func waitForWrittenData(handler func() string, values []string) {
 	}
 }
 
-func NewUi(ctx context.Context) (*ui.Ui, chan model.UiMgrConnectTo, chan model.UiConnectionStatus, *ui.MockHtmlOps) {
+func NewUi(ctx context.Context) (*ui.Ui, chan model.UiMgrConnectTo, chan model.UiMgrJoinToken, chan model.UiConnectionStatus, *ui.MockHtmlOps) {
 	connToReq := make(chan model.UiMgrConnectTo)
+	joinTokenReq := make(chan model.UiMgrJoinToken)
 	connToResp := make(chan model.UiConnectionStatus)
 	ops := ui.MockHtmlOps{
 		BindAddr: "mockBindAddr:123",
 		Handlers: make(map[string]func(http.ResponseWriter, *http.Request)),
 	}
-	u := ui.NewUi(connToReq, connToResp, &ops, "address", ctx)
-	return u, connToReq, connToResp, &ops
+	u := ui.NewUi(connToReq, joinTokenReq, connToResp, &ops, "admin password", "address", ctx)
+	return u, connToReq, joinTokenReq, connToResp, &ops
 }
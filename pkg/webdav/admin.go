@@ -0,0 +1,181 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strconv"
+)
+
+// adminPrefix is where the cluster's own endpoints live, next to the files
+const adminPrefix = "/.tealfs/"
+
+func (w *Webdav) handleAdmin(mux *http.ServeMux) {
+	mux.HandleFunc(adminPrefix+"snapshots", w.adminOnly(w.snapshots))
+	mux.HandleFunc(adminPrefix+"versions", w.adminOnly(w.versions))
+	mux.HandleFunc(adminPrefix+"trash", w.adminOnly(w.trash))
+}
+
+// adminOnly asks for the admin password before anything but a read, the
+// endpoints can purge the trash, delete snapshots and write over files
+func (w *Webdav) adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !w.isAdmin(r) {
+			rw.Header().Set("WWW-Authenticate", `Basic realm="tealfs"`)
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(rw, r)
+	}
+}
+
+// isAdmin checks the request carries the same admin password the ui asks
+// for
+func (w *Webdav) isAdmin(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != "admin" || w.adminPassword == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(w.adminPassword)) == 1
+}
+
+// snapshots lists the snapshots on GET, takes one of the directory given by
+// path on POST and deletes the one given by name on DELETE. A snapshot taken
+// while files are being written can hold only part of those writes.
+func (w *Webdav) snapshots(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		snapshots, err := w.fileSystem.Snapshots(r.Context())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(snapshots)
+	case http.MethodPost:
+		path := r.FormValue("path")
+		if path == "" {
+			path = "/"
+		}
+		err := w.fileSystem.CreateSnapshot(r.Context(), r.FormValue("name"), path)
+		if err != nil {
+			http.Error(rw, err.Error(), adminStatus(err))
+			return
+		}
+		rw.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		err := w.fileSystem.DeleteSnapshot(r.Context(), r.FormValue("name"))
+		if err != nil {
+			http.Error(rw, err.Error(), adminStatus(err))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(rw, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// versions lists the old versions of the file given by path on GET, or sends
+// the contents of one when version is given too. POST makes that version
+// current again.
+func (w *Webdav) versions(rw http.ResponseWriter, r *http.Request) {
+	path := r.FormValue("path")
+	switch r.Method {
+	case http.MethodGet:
+		if r.FormValue("version") == "" {
+			versions, err := w.fileSystem.Versions(r.Context(), path)
+			if err != nil {
+				http.Error(rw, err.Error(), adminStatus(err))
+				return
+			}
+			rw.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(rw).Encode(versions)
+			return
+		}
+		number, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(rw, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		file, err := w.fileSystem.OpenVersion(r.Context(), path, number)
+		if err != nil {
+			http.Error(rw, err.Error(), adminStatus(err))
+			return
+		}
+		info, _ := file.Stat()
+		http.ServeContent(rw, r, info.Name(), info.ModTime(), file)
+	case http.MethodPost:
+		number, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(rw, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		err = w.fileSystem.RestoreVersion(r.Context(), path, number)
+		if err != nil {
+			http.Error(rw, err.Error(), adminStatus(err))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(rw, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// trash lists what was deleted on GET, restores the entry given by id on
+// POST and empties the trash on DELETE
+func (w *Webdav) trash(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		trash, err := w.fileSystem.Trash(r.Context())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(trash)
+	case http.MethodPost:
+		err := w.fileSystem.RestoreFromTrash(r.Context(), r.FormValue("id"))
+		if err != nil {
+			http.Error(rw, err.Error(), adminStatus(err))
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err := w.fileSystem.EmptyTrash(r.Context())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(rw, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, fs.ErrExist):
+		return http.StatusConflict
+	case errors.Is(err, fs.ErrPermission):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
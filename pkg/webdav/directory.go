@@ -0,0 +1,306 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"sort"
+	"tealfs/pkg/model"
+	"tealfs/pkg/set"
+)
+
+// rootDirBlockId names the metadata block of the root directory. Every other
+// directory keeps its listing in the block named by its own Block.Id.
+const rootDirBlockId model.BlockId = "fileIndex"
+
+// dirFormat starts every directory listing, which is a file record for each
+// child. A root block without it holds the old flat index of every file,
+// which gets split up the first time it's read.
+var dirFormat = []byte{0xff, 'd', 'i', 'v'}
+
+func isDirListing(raw []byte) bool {
+	return bytes.HasPrefix(raw, dirFormat)
+}
+
+func dirToBytes(children []*File) []byte {
+	sort.Slice(children, func(i, j int) bool { return children[i].Name() < children[j].Name() })
+	result := append([]byte{}, dirFormat...)
+	for _, child := range children {
+		result = append(result, child.recordBytes(child.Name())...)
+	}
+	return result
+}
+
+func dirFromBytes(raw []byte, dir Path, fileSystem *FileSystem) ([]*File, error) {
+	if len(raw) == 0 {
+		return []*File{}, nil
+	}
+	if !isDirListing(raw) {
+		return nil, errors.New("not a directory listing")
+	}
+	children := []*File{}
+	remainder := raw[len(dirFormat):]
+	for len(remainder) > 0 {
+		child, name, rest, err := recordFromBytes(remainder, fileSystem)
+		if err != nil {
+			return nil, err
+		}
+		remainder = rest
+		seg, err := newPathSeg(name)
+		if err != nil {
+			return nil, err
+		}
+		child.Path = append(append(Path{}, dir...), seg)
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+// dirUpdateAttempts bounds how often a directory update starts over after
+// another node changed the directory first
+const dirUpdateAttempts = 10
+
+// loadParents refreshes each directory from the root down to the parent of p,
+// which is all that's needed to know whether p exists
+func (f *FileSystem) loadParents(ctx context.Context, p Path) error {
+	for i := 0; i < len(p); i++ {
+		dir, exists := f.fileHolder.Get(p[:i])
+		if !exists || !dir.IsDir() {
+			return nil
+		}
+		err := f.loadDir(ctx, dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileSystem) loadDir(ctx context.Context, dir *File) error {
+	children, _, err := f.fetchDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	f.applyDir(dir, children)
+	return nil
+}
+
+// fetchDir reads the newest listing of dir along with its version
+func (f *FileSystem) fetchDir(ctx context.Context, dir *File) ([]*File, model.Version, error) {
+	for range dirUpdateAttempts {
+		result := f.fetchLatestBlock(ctx, dir.Block.Id)
+		if result.Err != nil {
+			return nil, 0, result.Err
+		}
+		data := result.Block.Data
+		if len(dir.Path) == 0 && len(data) > 0 && !isDirListing(data) {
+			err := f.migrateFileIndex(ctx, data, result.Block.Version)
+			if err != nil && !errors.Is(err, model.ErrVersionConflict) {
+				return nil, 0, err
+			}
+			continue
+		}
+		children, err := dirFromBytes(data, dir.Path, f)
+		return children, result.Block.Version, err
+	}
+	return nil, 0, model.ErrVersionConflict
+}
+
+// updateDir applies change to the newest listing of dir and writes it back
+// based on that listing's version. When another node changes the directory
+// in between, the write is refused and the change is applied again.
+func (f *FileSystem) updateDir(ctx context.Context, dir *File, change func([]*File) ([]*File, error)) error {
+	for range dirUpdateAttempts {
+		children, version, err := f.fetchDir(ctx, dir)
+		if err != nil {
+			return err
+		}
+		children, err = change(children)
+		if err != nil {
+			return err
+		}
+		result := f.pushBlock(ctx, model.Block{
+			Id:        dir.Block.Id,
+			Data:      dirToBytes(children),
+			Version:   version,
+			IfVersion: true,
+		})
+		if errors.Is(result.Err, model.ErrVersionConflict) {
+			continue
+		}
+		if result.Err != nil {
+			return result.Err
+		}
+		f.applyDir(dir, children)
+		return nil
+	}
+	return model.ErrVersionConflict
+}
+
+// updateBlock is updateDir for the blocks that aren't directories
+func (f *FileSystem) updateBlock(ctx context.Context, id model.BlockId, change func([]byte) ([]byte, error)) error {
+	for range dirUpdateAttempts {
+		result := f.fetchLatestBlock(ctx, id)
+		if result.Err != nil {
+			return result.Err
+		}
+		data, err := change(result.Block.Data)
+		if err != nil {
+			return err
+		}
+		pushed := f.pushBlock(ctx, model.Block{
+			Id:        id,
+			Data:      data,
+			Version:   result.Block.Version,
+			IfVersion: true,
+		})
+		if !errors.Is(pushed.Err, model.ErrVersionConflict) {
+			return pushed.Err
+		}
+	}
+	return model.ErrVersionConflict
+}
+
+// applyDir makes the cached children of dir match its listing
+func (f *FileSystem) applyDir(dir *File, children []*File) {
+	listed := set.NewSet[model.BlockId]()
+	for _, child := range children {
+		listed.Add(child.Block.Id)
+	}
+	for _, cached := range f.immediateChildren(dir.Path) {
+		if !listed.Contains(cached.Block.Id) {
+			f.forget(cached)
+		}
+	}
+	for _, child := range children {
+		cached, exists := f.fileHolder.byBlockId[child.Block.Id]
+		if exists && !cached.Path.Equals(child.Path) {
+			f.forget(cached)
+		}
+		if existing, exists := f.fileHolder.Get(child.Path); exists && existing.Block.Id != child.Block.Id {
+			f.forget(existing)
+		}
+		f.fileHolder.updateFile(child)
+	}
+}
+
+// forget drops a file and anything cached below it
+func (f *FileSystem) forget(file *File) {
+	for _, cached := range f.fileHolder.AllFiles() {
+		if cached.Path.startsWith(file.Path) && len(cached.Path) > len(file.Path) {
+			f.fileHolder.Delete(cached)
+		}
+	}
+	f.fileHolder.Delete(file)
+}
+
+func childNamed(children []*File, name string) *File {
+	for _, child := range children {
+		if child.Name() == name {
+			return child
+		}
+	}
+	return nil
+}
+
+// withChild puts child in the listing, replacing whatever had its name
+func withChild(children []*File, child *File) []*File {
+	result := withoutChild(children, child.Name())
+	return append(result, child)
+}
+
+func withoutChild(children []*File, name string) []*File {
+	result := make([]*File, 0, len(children))
+	for _, c := range children {
+		if c.Name() != name {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// withoutListed takes file out of the listing unless its name has been given
+// to another file since
+func withoutListed(children []*File, file *File) []*File {
+	if existing := childNamed(children, file.Name()); existing != nil && existing.Block.Id == file.Block.Id {
+		return withoutChild(children, file.Name())
+	}
+	return children
+}
+
+func (f *FileSystem) parentOf(p Path) (*File, error) {
+	base, err := p.base()
+	if err != nil {
+		return nil, err
+	}
+	parent, exists := f.fileHolder.Get(base)
+	if !exists || !parent.IsDir() {
+		return nil, errors.New("invalid path")
+	}
+	return parent, nil
+}
+
+// persistEntry records the size and modification time of file in its
+// directory
+func (f *FileSystem) persistEntry(ctx context.Context, file *File) error {
+	parent, err := f.parentOf(file.Path)
+	if err != nil {
+		return err
+	}
+	return f.updateDir(ctx, parent, func(children []*File) ([]*File, error) {
+		existing := childNamed(children, file.Name())
+		if existing == nil || existing.Block.Id != file.Block.Id {
+			return nil, fs.ErrNotExist
+		}
+		// the extensions listed may have been changed through another node
+		entry := *file
+		entry.extensions = existing.extensions
+		return withChild(children, &entry), nil
+	})
+}
+
+// migrateFileIndex splits an old flat index into per directory blocks. The root
+// is written last so an interrupted migration is simply done again.
+func (f *FileSystem) migrateFileIndex(ctx context.Context, data []byte, version model.Version) error {
+	legacy := NewFileHolder()
+	err := legacy.UpdateFileHolderFromBytes(data, f)
+	if err != nil {
+		return err
+	}
+	for _, file := range legacy.AllFiles() {
+		if !file.IsDir() || len(file.Path) == 0 {
+			continue
+		}
+		result := f.pushBlock(ctx, model.Block{
+			Id:        file.Block.Id,
+			Data:      dirToBytes(legacy.children(file.Path)),
+			IfVersion: true,
+		})
+		// another node already migrated this one
+		if result.Err != nil && !errors.Is(result.Err, model.ErrVersionConflict) {
+			return result.Err
+		}
+	}
+	result := f.pushBlock(ctx, model.Block{
+		Id:        rootDirBlockId,
+		Data:      dirToBytes(legacy.children(Path{})),
+		Version:   version,
+		IfVersion: true,
+	})
+	return result.Err
+}
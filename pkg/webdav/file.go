@@ -15,34 +15,61 @@
 package webdav
 
 import (
+	"context"
 	"errors"
 	"io"
 	"io/fs"
+	"slices"
 	"tealfs/pkg/model"
 	"time"
 )
 
 type File struct {
-	SizeValue  int64
-	ModeValue  fs.FileMode
-	Modtime    time.Time
-	Position   int64
-	Block      model.Block
-	HasData    bool
+	SizeValue int64
+	ModeValue fs.FileMode
+	Modtime   time.Time
+	// Ctime is when the file's metadata last changed, Birthtime when it was
+	// created
+	Ctime     time.Time
+	Birthtime time.Time
+	Position  int64
+	Block     model.Block
+	HasData   bool
+	// Chunks hold the contents, chunkSize bytes each but the last. Files
+	// written before chunking have none and keep their contents in Block.
+	Chunks     []model.BlockId
 	Path       Path
 	FileSystem *FileSystem
+	loaded     map[int64]*loadedChunk
+	entryDirty bool
+	unsynced   map[model.BlockId]bool
+	// replaced is what the file held before the writes since the last Sync
+	replaced   *FileVersion
+	extensions map[extensionTag][]byte
+	// readOnly files belong to a snapshot, whose directories come with
+	// their listing
+	readOnly bool
+	listing  []*File
 }
 
 func (f *File) ToBytes() []byte {
-	value := model.IntToBytes(uint32(f.SizeValue))
-	value = append(value, model.IntToBytes(uint32(f.ModeValue))...)
-	value = append(value, model.IntToBytes(uint32(f.Modtime.Unix()))...)
-	value = append(value, model.StringToBytes(string(f.Block.Id))...)
-	value = append(value, model.StringToBytes(string(f.Path.toName()))...)
-	return value
+	return f.recordBytes(string(f.Path.toName()))
 }
 
 func FileFromBytes(raw []byte, fileSystem *FileSystem) (File, []byte, error) {
+	if isRecord(raw) {
+		file, name, remainder, err := recordFromBytes(raw, fileSystem)
+		if err != nil {
+			return File{}, nil, err
+		}
+		file.Path, err = PathFromName(name)
+		if err != nil {
+			return File{}, nil, err
+		}
+		return *file, remainder, nil
+	}
+
+	// written before records were versioned
 	size, remainder := model.IntFromBytes(raw)
 	mode, remainder := model.IntFromBytes(remainder)
 	modtimeRaw, remainder := model.IntFromBytes(remainder)
@@ -69,15 +96,29 @@ func FileFromBytes(raw []byte, fileSystem *FileSystem) (File, []byte, error) {
 	}, remainder, nil
 }
 
-func (f *File) Close() error {
+// close pushes anything still buffered, an error means the writes since the
+// last Sync may be lost
+func (f *File) close(ctx context.Context) error {
+	err := f.sync(ctx)
+	f.reset()
+	return err
+}
+
+// reset drops what a closed file kept in memory. What the writes replaced
+// stays until it's kept as a version.
+func (f *File) reset() {
 	f.Position = 0
 	f.Block.Data = []byte{}
 	f.HasData = false
-	return nil
+	f.loaded = nil
+	f.entryDirty = false
 }
 
-func (f *File) Read(p []byte) (n int, err error) {
-	error := f.ensureData()
+func (f *File) read(ctx context.Context, p []byte) (n int, err error) {
+	if f.chunked() {
+		return f.readChunk(ctx, p)
+	}
+	error := f.ensureData(ctx)
 	if error != nil {
 		return 0, error
 	}
@@ -119,10 +160,17 @@ func (f *File) Readdir(count int) ([]fs.FileInfo, error) {
 	if count < 0 {
 		return nil, errors.New("negative dir count requested")
 	}
-	children := f.FileSystem.immediateChildren(f.Path)[count:]
+	children := f.listing
+	if children == nil {
+		children = f.FileSystem.immediateChildren(f.Path)
+	}
+	if len(f.Path) == 0 {
+		children = withoutChild(children, string(snapshotsDir))
+	}
+	children = children[count:]
 	result := make([]fs.FileInfo, 0, len(children))
 	for _, child := range children {
-		result = append(result, child)
+		result = append(result, child.info())
 	}
 	return result, nil
 }
@@ -131,38 +179,47 @@ func (f *File) Stat() (fs.FileInfo, error) {
 	return f, nil
 }
 
-func (f *File) Write(p []byte) (n int, err error) {
-	error := f.ensureData()
-	if error != nil {
-		return 0, error
-	}
+// info is a copy of the file to describe it with, which stays put while the
+// file itself changes
+func (f *File) info() *File {
+	info := *f
+	return &info
+}
 
-	if int(f.Position)+len(p) > len(f.Block.Data) {
-		needToGrow := int(f.Position) + len(p) - len(f.Block.Data)
-		newData := make([]byte, needToGrow)
-		f.Block.Data = append(f.Block.Data, newData...)
-		f.SizeValue = int64(len(f.Block.Data))
-		for _, b := range p {
-			f.Block.Data[f.Position] = b
-			f.Position++
-		}
-	}
+// view is a copy of the file for a handle to read and write. It has to be
+// made from the loop, which changes the file.
+func (f *File) view() *File {
+	view := f.info()
+	view.Chunks = slices.Clone(f.Chunks)
+	view.Position = 0
+	view.loaded = nil
+	view.entryDirty = false
+	view.unsynced = nil
+	view.replaced = nil
+	return view
+}
 
-	result := f.FileSystem.pushBlock(f.Block)
-	if result.Err == nil {
-		err = f.FileSystem.persistFileIndex()
-		if err != nil {
-			return 0, err
-		}
-		return len(p), nil
+func (f *File) write(ctx context.Context, p []byte) (n int, err error) {
+	if f.readOnly {
+		return 0, fs.ErrPermission
 	}
-
-	return 0, result.Err
+	if f.replaced == nil && !f.entryDirty {
+		f.replaced = currentVersion(f)
+	}
+	err = f.makeChunked(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = f.writeChunks(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
-func (f *File) ensureData() error {
+func (f *File) ensureData(ctx context.Context) error {
 	if !f.HasData {
-		resp := f.FileSystem.fetchBlock(f.Block.Id)
+		resp := f.FileSystem.fetchBlock(ctx, f.Block.Id)
 		if resp.Err == nil {
 			f.Block = resp.Block
 			f.HasData = true
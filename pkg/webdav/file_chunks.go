@@ -0,0 +1,246 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// defaultChunkSize is how much of a file one block holds
+const defaultChunkSize = 1 << 20
+
+// writeBufferChunks is how many written chunks an open file holds before
+// pushing them, the rest wait for Sync or Close
+const writeBufferChunks = 8
+
+// loadedChunk is a chunk of an open file kept in memory. Clean ones are
+// dropped as soon as another chunk is needed, dirty ones wait to be pushed.
+type loadedChunk struct {
+	data  []byte
+	dirty bool
+}
+
+func (f *File) chunked() bool {
+	return f.Chunks != nil || f.SizeValue == 0
+}
+
+func (f *File) chunkSize() int64 {
+	return f.FileSystem.chunkSize
+}
+
+// chunkLen is how many bytes of the file fall in chunk index
+func (f *File) chunkLen(index int64) int64 {
+	remaining := f.SizeValue - index*f.chunkSize()
+	return max(0, min(remaining, f.chunkSize()))
+}
+
+// loadChunk fetches chunk index unless it's already loaded. Chunks past the
+// end of the file are added empty, bytes never written read as zero.
+func (f *File) loadChunk(ctx context.Context, index int64) (*loadedChunk, error) {
+	if chunk, ok := f.loaded[index]; ok {
+		return chunk, nil
+	}
+	for i, chunk := range f.loaded {
+		if !chunk.dirty {
+			delete(f.loaded, i)
+		}
+	}
+	data := []byte{}
+	if index < int64(len(f.Chunks)) {
+		resp := f.FileSystem.fetchBlock(ctx, f.Chunks[index])
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+		// the fetched data may be shared with other readers, writes go to a copy
+		data = append(data, resp.Block.Data...)
+	}
+	for int64(len(f.Chunks)) <= index {
+		f.Chunks = append(f.Chunks, f.newChunkId())
+	}
+	if length := f.chunkLen(index); int64(len(data)) < length {
+		data = append(data, make([]byte, length-int64(len(data)))...)
+	}
+	if f.loaded == nil {
+		f.loaded = make(map[int64]*loadedChunk)
+	}
+	chunk := &loadedChunk{data: data}
+	f.loaded[index] = chunk
+	return chunk, nil
+}
+
+func (f *File) readChunk(ctx context.Context, p []byte) (int, error) {
+	if f.Position >= f.SizeValue {
+		return 0, io.EOF
+	}
+	index := f.Position / f.chunkSize()
+	chunk, err := f.loadChunk(ctx, index)
+	if err != nil {
+		return 0, err
+	}
+	offset := f.Position - index*f.chunkSize()
+	n := copy(p, chunk.data[offset:f.chunkLen(index)])
+	f.Position += int64(n)
+	return n, nil
+}
+
+// writeChunks copies p into the chunks it covers, which stay in memory
+// until the buffer fills up or the file is synced
+func (f *File) writeChunks(ctx context.Context, p []byte) error {
+	end := f.Position + int64(len(p))
+	written := 0
+	for written < len(p) {
+		index := f.Position / f.chunkSize()
+		chunk, err := f.loadChunk(ctx, index)
+		if err != nil {
+			return err
+		}
+		offset := f.Position - index*f.chunkSize()
+		needed := min(f.chunkSize(), end-index*f.chunkSize())
+		if int64(len(chunk.data)) < needed {
+			chunk.data = append(chunk.data, make([]byte, needed-int64(len(chunk.data)))...)
+		}
+		n := copy(chunk.data[offset:], p[written:])
+		chunk.dirty = true
+		f.entryDirty = true
+		written += n
+		f.Position += int64(n)
+		f.SizeValue = max(f.SizeValue, f.Position)
+	}
+	f.Modtime = time.Now()
+	f.Ctime = f.Modtime
+	if f.dirtyChunks() >= writeBufferChunks {
+		return f.flushChunks(ctx)
+	}
+	return nil
+}
+
+func (f *File) dirtyChunks() int {
+	count := 0
+	for _, chunk := range f.loaded {
+		if chunk.dirty {
+			count++
+		}
+	}
+	return count
+}
+
+// newChunkId names a chunk that no directory refers to yet, which can be
+// written over until the next Sync records it
+func (f *File) newChunkId() model.BlockId {
+	id := model.NewBlockId()
+	if f.unsynced == nil {
+		f.unsynced = make(map[model.BlockId]bool)
+	}
+	f.unsynced[id] = true
+	return id
+}
+
+// flushChunks pushes every dirty chunk, in file order. A chunk that's already
+// recorded in a directory may be shared with a snapshot, so its new contents
+// go to a new block instead.
+func (f *File) flushChunks(ctx context.Context) error {
+	indexes := make([]int64, 0, len(f.loaded))
+	for index, chunk := range f.loaded {
+		if chunk.dirty {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+	for _, index := range indexes {
+		chunk := f.loaded[index]
+		if !f.unsynced[f.Chunks[index]] {
+			f.Chunks[index] = f.newChunkId()
+			f.entryDirty = true
+		}
+		resp := f.FileSystem.pushBlock(ctx, model.Block{Id: f.Chunks[index], Data: chunk.data})
+		if resp.Err != nil {
+			return resp.Err
+		}
+		chunk.dirty = false
+	}
+	return nil
+}
+
+// sync pushes what was written so far, keeps what it replaced as a version
+// and records the new size and chunks in the file's directory
+func (f *File) sync(ctx context.Context) error {
+	err := f.flushChunks(ctx)
+	if err != nil {
+		return err
+	}
+	return f.FileSystem.commit(ctx, f, f)
+}
+
+// commit keeps what view replaced as a version of file and records the size
+// and chunks of view as those of file in its directory. The cached file takes
+// them on once they're recorded. A version that can't be saved doesn't stop
+// the write, the next sync of the handle tries again.
+func (f *FileSystem) commit(ctx context.Context, file *File, view *File) error {
+	if view.replaced != nil {
+		err := f.saveVersion(ctx, file, *view.replaced)
+		if err != nil {
+			fmt.Println("could not keep the replaced version of", file.Path.toName()+":", err)
+		} else {
+			view.replaced = nil
+		}
+	}
+	if !view.entryDirty {
+		return nil
+	}
+	entry := file.info()
+	entry.SizeValue = view.SizeValue
+	entry.Modtime = view.Modtime
+	entry.Ctime = view.Ctime
+	entry.Chunks = slices.Clone(view.Chunks)
+	err := f.persistEntry(ctx, entry)
+	if err != nil {
+		return err
+	}
+	view.entryDirty = false
+	view.unsynced = nil
+	return nil
+}
+
+// makeChunked moves the contents of a file written before chunking into
+// chunks, its old block is left behind
+func (f *File) makeChunked(ctx context.Context) error {
+	if f.chunked() {
+		return nil
+	}
+	err := f.ensureData(ctx)
+	if err != nil {
+		return err
+	}
+	data := f.Block.Data
+	f.Chunks = []model.BlockId{}
+	for start := int64(0); start < int64(len(data)); start += f.chunkSize() {
+		id := f.newChunkId()
+		end := min(start+f.chunkSize(), int64(len(data)))
+		resp := f.FileSystem.pushBlock(ctx, model.Block{Id: id, Data: data[start:end]})
+		if resp.Err != nil {
+			f.Chunks = nil
+			return resp.Err
+		}
+		f.Chunks = append(f.Chunks, id)
+	}
+	f.Block.Data = []byte{}
+	f.HasData = false
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"io/fs"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+// Handle is a file as one request opened it. The handle reads and writes its
+// own view of the file, with its own position and buffered writes, so
+// requests on the same file don't wait on each other or on the filesystem's
+// loop while blocks are fetched and pushed. Sync records the view as the file
+// from the loop. The handle adds the request's context, which is what block
+// reads and writes made through it give up with.
+type Handle struct {
+	*File
+	file *File
+	ctx  context.Context
+	mux  sync.Mutex
+}
+
+func (h *Handle) Read(p []byte) (int, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.File.read(h.ctx, p)
+}
+
+func (h *Handle) Write(p []byte) (int, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.File.write(h.ctx, p)
+}
+
+func (h *Handle) Seek(offset int64, whence int) (int64, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.File.Seek(offset, whence)
+}
+
+func (h *Handle) Stat() (fs.FileInfo, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.File.info(), nil
+}
+
+// Readdir lists what the filesystem has cached below the directory, which
+// only the loop may look at
+func (h *Handle) Readdir(count int) (infos []fs.FileInfo, err error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.FileSystem.exclusively(func() { infos, err = h.File.Readdir(count) })
+	return infos, err
+}
+
+// Close pushes anything still buffered, an error means the writes since the
+// last Sync may be lost
+func (h *Handle) Close() error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	err := h.sync()
+	if replaced := h.File.replaced; replaced != nil {
+		// the next handle to sync the file keeps it instead
+		h.FileSystem.exclusively(func() {
+			if h.file.replaced == nil {
+				h.file.replaced = replaced
+			}
+		})
+	}
+	h.File.reset()
+	return err
+}
+
+// Sync pushes what was written so far, keeps what it replaced as a version
+// and records the new size and chunks in the file's directory
+func (h *Handle) Sync() error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.sync()
+}
+
+func (h *Handle) sync() error {
+	view := h.File
+	err := view.flushChunks(h.ctx)
+	if err != nil || (view.replaced == nil && !view.entryDirty) {
+		return err
+	}
+	h.FileSystem.exclusively(func() { err = h.FileSystem.commit(h.ctx, h.file, view) })
+	return err
+}
+
+// DeadProps are those of the shared file, which takes on the patches
+func (h *Handle) DeadProps() (map[xml.Name]webdav.Property, error) {
+	return h.file.DeadProps()
+}
+
+func (h *Handle) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return h.File.patch(h.ctx, h.file, patches)
+}
+
+// The file itself can be used without a handle, as its own view, its block
+// reads and writes then wait as long as they take
+
+func (f *File) Read(p []byte) (int, error) {
+	return f.read(context.Background(), p)
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	return f.write(context.Background(), p)
+}
+
+func (f *File) Close() error {
+	return f.close(context.Background())
+}
+
+func (f *File) Sync() error {
+	return f.sync(context.Background())
+}
+
+func (f *File) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	return f.patch(context.Background(), f, patches)
+}
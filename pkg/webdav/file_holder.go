@@ -50,6 +50,17 @@ func (f *FileHolder) Get(p Path) (*File, bool) {
 	return file, exists
 }
 
+func (f *FileHolder) children(path Path) []*File {
+	children := make([]*File, 0)
+	neededPathLen := len(path) + 1
+	for _, file := range f.byPath {
+		if len(file.Path) == neededPathLen && file.Path.startsWith(path) {
+			children = append(children, file)
+		}
+	}
+	return children
+}
+
 func (f *FileHolder) ToBytes() []byte {
 	result := []byte{}
 	for _, file := range f.byPath {
@@ -61,9 +72,16 @@ func (f *FileHolder) ToBytes() []byte {
 func (f *FileHolder) updateFile(update *File) {
 	toUpdate, exists := f.byBlockId[update.Block.Id]
 	if exists {
-		toUpdate.SizeValue = update.SizeValue
+		// writes not yet recorded in the listing are newer than what it holds
+		if !toUpdate.entryDirty {
+			toUpdate.SizeValue = update.SizeValue
+			toUpdate.Modtime = update.Modtime
+			toUpdate.Ctime = update.Ctime
+			toUpdate.Chunks = update.Chunks
+		}
 		toUpdate.ModeValue = update.ModeValue
-		toUpdate.Modtime = update.Modtime
+		toUpdate.Birthtime = update.Birthtime
+		toUpdate.extensions = update.extensions
 		oldPath := toUpdate.Path
 		toUpdate.Path = update.Path
 		delete(f.byPath, oldPath.toName())
@@ -0,0 +1,134 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"io/fs"
+	"maps"
+	"net/http"
+	"slices"
+	"tealfs/pkg/model"
+
+	"golang.org/x/net/webdav"
+)
+
+// deadPropsTag holds the properties clients set with PROPPATCH, which are
+// kept in the file's record so they travel with its directory
+const deadPropsTag extensionTag = 1
+
+func deadPropsToBytes(props map[xml.Name]webdav.Property) []byte {
+	names := make([]xml.Name, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b xml.Name) int {
+		return cmp.Or(cmp.Compare(a.Space, b.Space), cmp.Compare(a.Local, b.Local))
+	})
+	result := model.IntToBytes(uint32(len(props)))
+	for _, name := range names {
+		prop := props[name]
+		result = append(result, model.StringToBytes(prop.XMLName.Space)...)
+		result = append(result, model.StringToBytes(prop.XMLName.Local)...)
+		result = append(result, model.StringToBytes(prop.Lang)...)
+		result = append(result, model.BytesToBytes(prop.InnerXML)...)
+	}
+	return result
+}
+
+func deadPropsFromBytes(raw []byte) map[xml.Name]webdav.Property {
+	props := make(map[xml.Name]webdav.Property)
+	if len(raw) == 0 {
+		return props
+	}
+	count, remainder := model.IntFromBytes(raw)
+	for range count {
+		var prop webdav.Property
+		prop.XMLName.Space, remainder = model.StringFromBytes(remainder)
+		prop.XMLName.Local, remainder = model.StringFromBytes(remainder)
+		prop.Lang, remainder = model.StringFromBytes(remainder)
+		prop.InnerXML, remainder = model.BytesFromBytes(remainder)
+		props[prop.XMLName] = prop
+	}
+	return props
+}
+
+func (f *File) DeadProps() (map[xml.Name]webdav.Property, error) {
+	var props map[xml.Name]webdav.Property
+	f.FileSystem.exclusively(func() { props = deadPropsFromBytes(f.extensions[deadPropsTag]) })
+	return props, nil
+}
+
+// patch applies the patches to the entry of file as it is in its directory,
+// so properties set through other nodes at the same time are kept
+func (f *File) patch(ctx context.Context, file *File, patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	result := webdav.Propstat{Status: http.StatusOK}
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			result.Props = append(result.Props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+	// the root has no directory to keep its properties in
+	if len(f.Path) == 0 || f.readOnly {
+		result.Status = http.StatusForbidden
+		return []webdav.Propstat{result}, nil
+	}
+
+	var err error
+	f.FileSystem.exclusively(func() { err = f.FileSystem.patchDeadProps(ctx, file, patches) })
+	if err != nil {
+		return nil, err
+	}
+	return []webdav.Propstat{result}, nil
+}
+
+func (f *FileSystem) patchDeadProps(ctx context.Context, file *File, patches []webdav.Proppatch) error {
+	parent, err := f.parentOf(file.Path)
+	if err != nil {
+		return err
+	}
+	return f.updateDir(ctx, parent, func(children []*File) ([]*File, error) {
+		existing := childNamed(children, file.Name())
+		if existing == nil || existing.Block.Id != file.Block.Id {
+			return nil, fs.ErrNotExist
+		}
+		props := deadPropsFromBytes(existing.extensions[deadPropsTag])
+		for _, patch := range patches {
+			for _, prop := range patch.Props {
+				if patch.Remove {
+					delete(props, prop.XMLName)
+				} else {
+					props[prop.XMLName] = prop
+				}
+			}
+		}
+		updated := *existing
+		updated.extensions = maps.Clone(existing.extensions)
+		if updated.extensions == nil {
+			updated.extensions = make(map[extensionTag][]byte)
+		}
+		if len(props) == 0 {
+			delete(updated.extensions, deadPropsTag)
+		} else {
+			updated.extensions[deadPropsTag] = deadPropsToBytes(props)
+		}
+		if len(updated.extensions) == 0 {
+			updated.extensions = nil
+		}
+		return withChild(children, &updated), nil
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav_test
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"tealfs/pkg/model"
+	"tealfs/pkg/webdav"
+	"testing"
+
+	xwebdav "golang.org/x/net/webdav"
+)
+
+func TestDeadPropsAreKeptInTheDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+	writeFile(t, &fsys, "/photo.jpg", "jpeg", 0)
+
+	tag := xml.Name{Space: "http://www.apple.com/webdav_fs/props/", Local: "tags"}
+	label := xml.Name{Space: "urn:example", Local: "label"}
+	patch(t, &fsys, "/photo.jpg", xwebdav.Proppatch{Props: []xwebdav.Property{
+		{XMLName: tag, InnerXML: []byte("holiday")},
+		{XMLName: label, InnerXML: []byte("red")},
+	}})
+	patch(t, &fsys, "/photo.jpg", xwebdav.Proppatch{Remove: true, Props: []xwebdav.Property{{XMLName: label}}})
+
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs2)
+	f, err := fs2.OpenFile(ctx, "/photo.jpg", os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	props, err := f.(xwebdav.DeadPropsHolder).DeadProps()
+	if err != nil || len(props) != 1 || string(props[tag].InnerXML) != "holiday" {
+		t.Error("wrong dead props", props, err)
+	}
+	if contents := readFile(t, &fs2, "/photo.jpg"); contents != "jpeg" {
+		t.Error("contents changed", contents)
+	}
+}
+
+func TestWritesKeepDeadPropsSetThroughAnotherNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs1 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs1)
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs2)
+	writeFile(t, &fs1, "/notes", "first", 0)
+
+	f, err := fs2.OpenFile(ctx, "/notes", os.O_RDWR, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	label := xml.Name{Space: "urn:example", Local: "label"}
+	patch(t, &fs1, "/notes", xwebdav.Proppatch{Props: []xwebdav.Property{{XMLName: label, InnerXML: []byte("red")}}})
+	_, _ = f.Write([]byte("second"))
+	if err = f.Close(); err != nil {
+		t.Error("error closing file", err)
+		return
+	}
+
+	fs3 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs3)
+	f, err = fs3.OpenFile(ctx, "/notes", os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	props, err := f.(xwebdav.DeadPropsHolder).DeadProps()
+	if err != nil || string(props[label].InnerXML) != "red" {
+		t.Error("expected the write to keep the dead props", props, err)
+	}
+	if contents := readFile(t, &fs3, "/notes"); contents != "second" {
+		t.Error("wrong contents", contents)
+	}
+}
+
+func patch(t *testing.T, fsys *webdav.FileSystem, name string, patches ...xwebdav.Proppatch) {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDWR, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	defer f.Close()
+	stats, err := f.(xwebdav.DeadPropsHolder).Patch(patches)
+	if err != nil || len(stats) != 1 || stats[0].Status != http.StatusOK {
+		t.Error("error patching", stats, err)
+	}
+}
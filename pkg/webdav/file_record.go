@@ -0,0 +1,182 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// recordMarker starts every versioned file record. Entries from before it
+// start with a 32 bit size, which begins with this byte too for files of
+// 0xff000000 bytes or more, so isRecord checks what follows it as well.
+const recordMarker = 0xff
+
+// recordVersion is the layout of the fixed fields this node writes. A record
+// is the marker, the version and a length prefixed body ending in tagged
+// extensions, new optional fields are added as extensions rather than as a
+// new version.
+const recordVersion = 1
+
+// extensionTag names an optional field of a record. Fields with tags this
+// node doesn't know are kept and written back as they are.
+type extensionTag uint32
+
+// recordBytes stores the file under name, a full path in the flat index or
+// the file's own name in a directory listing
+func (f *File) recordBytes(name string) []byte {
+	body := model.Int64ToBytes(f.SizeValue)
+	body = append(body, model.IntToBytes(uint32(f.ModeValue))...)
+	body = append(body, timeToBytes(f.Modtime)...)
+	body = append(body, timeToBytes(f.Ctime)...)
+	body = append(body, timeToBytes(f.Birthtime)...)
+	body = append(body, model.StringToBytes(string(f.Block.Id))...)
+	body = append(body, model.StringToBytes(name)...)
+	body = append(body, model.IntToBytes(uint32(len(f.Chunks)))...)
+	for _, chunk := range f.Chunks {
+		body = append(body, model.StringToBytes(string(chunk))...)
+	}
+	tags := make([]extensionTag, 0, len(f.extensions))
+	for tag := range f.extensions {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	for _, tag := range tags {
+		body = append(body, model.IntToBytes(uint32(tag))...)
+		body = append(body, model.BytesToBytes(f.extensions[tag])...)
+	}
+	return append([]byte{recordMarker, recordVersion}, model.BytesToBytes(body)...)
+}
+
+// isRecord is whether raw starts with a record this node can read rather
+// than an entry from before records. Besides the marker the version has to
+// be known and the body has to hold exactly the fields it's made of.
+func isRecord(raw []byte) bool {
+	if len(raw) < 6 || raw[0] != recordMarker || raw[1] == 0 || raw[1] > recordVersion {
+		return false
+	}
+	length, remainder := model.IntFromBytes(raw[2:])
+	if int64(length) > int64(len(remainder)) {
+		return false
+	}
+	return recordBodyValid(remainder[:length])
+}
+
+// recordBodyValid walks the fields of a record body without reading them
+func recordBodyValid(body []byte) bool {
+	skip := func(n int) bool {
+		if n > len(body) {
+			return false
+		}
+		body = body[n:]
+		return true
+	}
+	skipBytes := func() bool {
+		if len(body) < 4 {
+			return false
+		}
+		var length uint32
+		length, body = model.IntFromBytes(body)
+		return skip(int(length))
+	}
+	// size, mode, the three times, the block id and the name
+	if !skip(8+4+3*8) || !skipBytes() || !skipBytes() || len(body) < 4 {
+		return false
+	}
+	var count uint32
+	count, body = model.IntFromBytes(body)
+	for range count {
+		if !skipBytes() {
+			return false
+		}
+	}
+	for len(body) > 0 {
+		if !skip(4) || !skipBytes() {
+			return false
+		}
+	}
+	return true
+}
+
+func recordFromBytes(raw []byte, fileSystem *FileSystem) (*File, string, []byte, error) {
+	if len(raw) < 2 || raw[0] != recordMarker {
+		return nil, "", nil, errors.New("not a file record")
+	}
+	if raw[1] > recordVersion {
+		return nil, "", nil, errors.New("file record is from a newer version")
+	}
+	if !isRecord(raw) {
+		return nil, "", nil, errors.New("file record is corrupt")
+	}
+	body, remainder := model.BytesFromBytes(raw[2:])
+
+	size, body := model.Int64FromBytes(body)
+	mode, body := model.IntFromBytes(body)
+	modtime, body := timeFromBytes(body)
+	ctime, body := timeFromBytes(body)
+	birthtime, body := timeFromBytes(body)
+	blockId, body := model.StringFromBytes(body)
+	name, body := model.StringFromBytes(body)
+	count, body := model.IntFromBytes(body)
+	// a file with contents but no chunks was written before chunking
+	var chunks []model.BlockId
+	for range count {
+		var chunk string
+		chunk, body = model.StringFromBytes(body)
+		chunks = append(chunks, model.BlockId(chunk))
+	}
+	var extensions map[extensionTag][]byte
+	for len(body) > 0 {
+		var tag uint32
+		var value []byte
+		tag, body = model.IntFromBytes(body)
+		value, body = model.BytesFromBytes(body)
+		if extensions == nil {
+			extensions = make(map[extensionTag][]byte)
+		}
+		extensions[extensionTag(tag)] = value
+	}
+
+	return &File{
+		SizeValue:  size,
+		ModeValue:  fs.FileMode(mode),
+		Modtime:    modtime,
+		Ctime:      ctime,
+		Birthtime:  birthtime,
+		Block:      model.Block{Id: model.BlockId(blockId), Data: []byte{}},
+		Chunks:     chunks,
+		FileSystem: fileSystem,
+		extensions: extensions,
+	}, name, remainder, nil
+}
+
+// timeToBytes keeps nanoseconds, the zero time is stored as 0
+func timeToBytes(t time.Time) []byte {
+	if t.IsZero() {
+		return model.Int64ToBytes(0)
+	}
+	return model.Int64ToBytes(t.UnixNano())
+}
+
+func timeFromBytes(raw []byte) (time.Time, []byte) {
+	nanos, remainder := model.Int64FromBytes(raw)
+	if nanos == 0 {
+		return time.Time{}, remainder
+	}
+	return time.Unix(0, nanos), remainder
+}
@@ -16,7 +16,9 @@ package webdav_test
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"os"
 	"tealfs/pkg/model"
 	"tealfs/pkg/webdav"
 	"testing"
@@ -161,3 +163,440 @@ func TestSerialize(t *testing.T) {
 		t.Error("modtime is different", file.ModTime(), fileClone.ModTime())
 	}
 }
+
+func TestChunkedWriteAndRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs)
+
+	f, err := fs.OpenFile(ctx, "/chunky", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Error("error creating file", err)
+		return
+	}
+	data := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if n, err := f.Write(data); err != nil || n != len(data) {
+		t.Error("error writing", n, err)
+		return
+	}
+	file := f.(*webdav.Handle)
+	if len(file.Chunks) != 3 {
+		t.Error("expected three chunks", file.Chunks)
+		return
+	}
+
+	err = f.(*webdav.Handle).Sync()
+	if err != nil {
+		t.Error("error syncing", err)
+		return
+	}
+
+	// rewriting two bytes in the middle is buffered, then only pushes their
+	// chunk, the old version and the listing
+	storage.reset()
+	_, _ = f.Seek(5, io.SeekStart)
+	_, err = f.Write([]byte{50, 60})
+	if err != nil {
+		t.Error("error rewriting", err)
+		return
+	}
+	if _, pushed := storage.counts(); pushed != 0 {
+		t.Error("expected the write to be buffered", pushed)
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		t.Error("error closing", err)
+		return
+	}
+	if _, pushed := storage.counts(); pushed != 3 {
+		t.Error("expected one chunk, the version history and the listing to be pushed", pushed)
+		return
+	}
+
+	fs2 := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs2)
+	f, err = fs2.OpenFile(ctx, "/chunky", os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	storage.reset()
+	_, _ = f.Seek(8, io.SeekStart)
+	tail, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(tail, []byte{8, 9}) {
+		t.Error("wrong tail", tail, err)
+		return
+	}
+	if fetched, _ := storage.counts(); fetched != 1 {
+		t.Error("expected only the last chunk to be fetched", fetched)
+		return
+	}
+	_, _ = f.Seek(0, io.SeekStart)
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(all, []byte{0, 1, 2, 3, 4, 50, 60, 7, 8, 9}) {
+		t.Error("wrong contents", all, err)
+	}
+}
+
+func TestTruncateOnOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs)
+
+	f, _ := fs.OpenFile(ctx, "/shrink", os.O_RDWR|os.O_CREATE, 0666)
+	_, _ = f.Write([]byte{1, 2, 3, 4, 5, 6})
+	_ = f.Close()
+
+	f, err := fs.OpenFile(ctx, "/shrink", os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	_, _ = f.Write([]byte{9})
+	_ = f.Close()
+
+	f, _ = fs.OpenFile(ctx, "/shrink", os.O_RDONLY, 0)
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(all, []byte{9}) {
+		t.Error("expected the old contents to be gone", all, err)
+	}
+}
+
+func TestSingleBlockFileIsChunkedOnWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path, _ := webdav.PathFromName("/old")
+	old := webdav.File{SizeValue: 6, ModeValue: 0666, Modtime: time.Unix(1, 0), Block: model.Block{Id: model.NewBlockId()}, Path: path}
+	legacy := webdav.NewFileHolder()
+	legacy.Add(&old)
+	storage := newVersionedStorage()
+	storage.blocks["fileIndex"] = model.Block{Id: "fileIndex", Data: legacy.ToBytes()}
+	storage.blocks[old.Block.Id] = model.Block{Id: old.Block.Id, Data: []byte{1, 2, 3, 4, 5, 6}}
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs)
+
+	f, err := fs.OpenFile(ctx, "/old", os.O_RDWR, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	_, _ = f.Seek(0, io.SeekEnd)
+	_, err = f.Write([]byte{7})
+	if err != nil {
+		t.Error("error writing", err)
+		return
+	}
+	if len(f.(*webdav.Handle).Chunks) != 2 {
+		t.Error("expected the file to be chunked", f.(*webdav.Handle).Chunks)
+		return
+	}
+	_ = f.Close()
+
+	f, _ = fs.OpenFile(ctx, "/old", os.O_RDONLY, 0)
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(all, []byte{1, 2, 3, 4, 5, 6, 7}) {
+		t.Error("wrong contents", all, err)
+	}
+}
+
+func TestWriteBufferFlushesWhenFull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs)
+
+	f, _ := fs.OpenFile(ctx, "/big", os.O_RDWR|os.O_CREATE, 0666)
+	storage.reset()
+	piece := []byte{1, 2}
+	for range 4 * 7 / len(piece) {
+		_, _ = f.Write(piece)
+	}
+	if _, pushed := storage.counts(); pushed != 0 {
+		t.Error("expected seven chunks to stay buffered", pushed)
+		return
+	}
+	_, _ = f.Write(piece)
+	if _, pushed := storage.counts(); pushed != 8 {
+		t.Error("expected the full buffer to be pushed without the listing", pushed)
+		return
+	}
+	_ = f.Close()
+	if _, pushed := storage.counts(); pushed != 9 {
+		t.Error("expected close to push the listing", pushed)
+	}
+}
+
+func TestBufferedWritesSurviveParentReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs)
+
+	f, _ := fs.OpenFile(ctx, "/big", os.O_RDWR|os.O_CREATE, 0666)
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	_, _ = f.Write(data)
+
+	// creating a sibling reloads the listing that doesn't know about the writes yet
+	other, err := fs.OpenFile(ctx, "/other", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Error("error creating sibling", err)
+		return
+	}
+	_ = other.Close()
+
+	err = f.Close()
+	if err != nil {
+		t.Error("error closing", err)
+		return
+	}
+	f, _ = fs.OpenFile(ctx, "/big", os.O_RDONLY, 0)
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(all, data) {
+		t.Error("wrong contents", all, err)
+	}
+}
+
+func TestCloseReportsFailedFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := webdav.NewFileSystem(model.NewNodeId())
+	mockPushesAndPulls(ctx, &fs)
+	f, _ := fs.OpenFile(ctx, "/doomed", os.O_RDWR|os.O_CREATE, 0666)
+	cancel()
+
+	// nothing answers the push anymore, the file's context gives up on it
+	file := f.(*webdav.Handle)
+	_, err := file.Write([]byte{1})
+	if err != nil {
+		t.Error("expected the write to be buffered", err)
+		return
+	}
+	err = file.Close()
+	if err == nil {
+		t.Error("expected close to report the failed push")
+	}
+}
+
+func TestHandlesKeepTheirOwnContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := webdav.NewFileSystem(model.NewNodeId())
+	mockPushesAndPulls(ctx, &fs)
+	f, _ := fs.OpenFile(ctx, "/shared", os.O_RDWR|os.O_CREATE, 0666)
+
+	// another request opens the same file and goes away
+	otherCtx, otherCancel := context.WithCancel(ctx)
+	other, _ := fs.OpenFile(otherCtx, "/shared", os.O_RDONLY, 0)
+	otherCancel()
+	_, _ = other.Stat()
+
+	_, err := f.Write([]byte{1})
+	if err != nil {
+		t.Error("expected the write to be buffered", err)
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		t.Error("expected close to push with the context of its own request", err)
+	}
+}
+
+func TestHandlesKeepTheirOwnPositionAndWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs)
+
+	f, _ := fs.OpenFile(ctx, "/shared", os.O_RDWR|os.O_CREATE, 0666)
+	_, _ = f.Write([]byte{1, 2, 3, 4, 5, 6})
+	_ = f.Close()
+
+	reader, _ := fs.OpenFile(ctx, "/shared", os.O_RDONLY, 0)
+	writer, _ := fs.OpenFile(ctx, "/shared", os.O_RDWR, 0)
+	_, _ = writer.Seek(4, io.SeekStart)
+	_, _ = writer.Write([]byte{50, 60})
+	start := make([]byte, 2)
+	_, _ = reader.Read(start)
+
+	// closing the reader leaves the writer's buffered writes alone
+	_ = reader.Close()
+	err := writer.Close()
+	if err != nil {
+		t.Error("error closing", err)
+		return
+	}
+	f, _ = fs.OpenFile(ctx, "/shared", os.O_RDONLY, 0)
+	all, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(start, []byte{1, 2}) || !bytes.Equal(all, []byte{1, 2, 3, 4, 50, 60}) {
+		t.Error("wrong contents", start, all, err)
+	}
+}
+
+func TestSlowReadDoesNotHoldUpOtherRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	writeCtx, stopWriting := context.WithCancel(ctx)
+	storage.serve(writeCtx, &fs)
+	f, _ := fs.OpenFile(ctx, "/slow", os.O_RDWR|os.O_CREATE, 0666)
+	_, _ = f.Write([]byte{1, 2, 3})
+	slow := f.(*webdav.Handle).Chunks[0]
+	_ = f.Close()
+	stopWriting()
+
+	// reads of the file's chunk don't get an answer until released
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req := <-fs.ReadReqResp:
+				storage.mux.Lock()
+				block := storage.blocks[req.Req]
+				storage.mux.Unlock()
+				if req.Req == slow {
+					close(reading)
+					go func() {
+						<-release
+						req.Resp <- model.BlockResponse{Block: block}
+					}()
+					continue
+				}
+				req.Resp <- model.BlockResponse{Block: block}
+			case req := <-fs.WriteReqResp:
+				req.Resp <- model.BlockIdResponse{BlockId: req.Req.Id}
+			}
+		}
+	}()
+
+	f, err := fs.OpenFile(ctx, "/slow", os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	read := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(f)
+		read <- data
+	}()
+	<-reading
+
+	stat := make(chan error)
+	go func() {
+		_, err := fs.Stat(ctx, "/slow")
+		stat <- err
+	}()
+	select {
+	case err := <-stat:
+		if err != nil {
+			t.Error("error stat-ing file", err)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected stat to finish while the read waits")
+		return
+	}
+	close(release)
+	if data := <-read; !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Error("wrong contents", data)
+	}
+}
+
+func TestSerializeLargeFileWithNanoseconds(t *testing.T) {
+	path, _ := webdav.PathFromName("/big/file")
+	file := webdav.File{
+		SizeValue: 5 << 30,
+		ModeValue: 0640,
+		Modtime:   time.Date(2200, 1, 2, 3, 4, 5, 6, time.UTC),
+		Ctime:     time.Date(2200, 1, 2, 3, 4, 5, 7, time.UTC),
+		Birthtime: time.Date(2024, 1, 2, 3, 4, 5, 8, time.UTC),
+		Block:     model.Block{Id: model.NewBlockId()},
+		Chunks:    []model.BlockId{model.NewBlockId(), model.NewBlockId()},
+		Path:      path,
+	}
+
+	clone, remainder, err := webdav.FileFromBytes(file.ToBytes(), nil)
+	if err != nil || len(remainder) != 0 {
+		t.Error("error deserializing", err, remainder)
+		return
+	}
+	if clone.SizeValue != file.SizeValue {
+		t.Error("size is different", clone.SizeValue)
+	}
+	if !clone.Modtime.Equal(file.Modtime) || !clone.Ctime.Equal(file.Ctime) || !clone.Birthtime.Equal(file.Birthtime) {
+		t.Error("times are different", clone.Modtime, clone.Ctime, clone.Birthtime)
+	}
+	if len(clone.Chunks) != 2 || clone.Chunks[1] != file.Chunks[1] || !clone.Path.Equals(path) {
+		t.Error("chunks or path are different", clone.Chunks, clone.Path)
+	}
+}
+
+func TestDeserializeUnversionedFile(t *testing.T) {
+	raw := model.IntToBytes(12)
+	raw = append(raw, model.IntToBytes(0644)...)
+	raw = append(raw, model.IntToBytes(1000)...)
+	raw = append(raw, model.StringToBytes("someBlock")...)
+	raw = append(raw, model.StringToBytes("dir/file")...)
+
+	file, _, err := webdav.FileFromBytes(raw, nil)
+	if err != nil {
+		t.Error("error deserializing", err)
+		return
+	}
+	if file.SizeValue != 12 || file.Modtime != time.Unix(1000, 0) || file.Block.Id != "someBlock" || file.Name() != "file" {
+		t.Error("old file record read wrong", file)
+	}
+}
+
+func TestDeserializeUnversionedFileOverFourGigabytes(t *testing.T) {
+	// the size starts with the byte that marks versioned records
+	raw := model.IntToBytes(0xFF000001)
+	raw = append(raw, model.IntToBytes(0644)...)
+	raw = append(raw, model.IntToBytes(1000)...)
+	raw = append(raw, model.StringToBytes("someBlock")...)
+	raw = append(raw, model.StringToBytes("dir/file")...)
+
+	file, _, err := webdav.FileFromBytes(raw, nil)
+	if err != nil {
+		t.Error("error deserializing", err)
+		return
+	}
+	if file.SizeValue != 0xFF000001 || file.Block.Id != "someBlock" || file.Name() != "file" {
+		t.Error("old file record read wrong", file)
+	}
+}
+
+func TestUnknownExtensionsSurviveRewrite(t *testing.T) {
+	body := model.Int64ToBytes(3)
+	body = append(body, model.IntToBytes(0644)...)
+	body = append(body, model.Int64ToBytes(1)...)
+	body = append(body, model.Int64ToBytes(2)...)
+	body = append(body, model.Int64ToBytes(3)...)
+	body = append(body, model.StringToBytes("someBlock")...)
+	body = append(body, model.StringToBytes("file")...)
+	body = append(body, model.IntToBytes(0)...)
+	// a field from a newer node
+	body = append(body, model.IntToBytes(9999)...)
+	body = append(body, model.BytesToBytes([]byte{1, 2, 3})...)
+	raw := append([]byte{0xff, 1}, model.BytesToBytes(body)...)
+
+	file, _, err := webdav.FileFromBytes(raw, nil)
+	if err != nil {
+		t.Error("error deserializing", err)
+		return
+	}
+	if !bytes.Equal(file.ToBytes(), raw) {
+		t.Error("expected the unknown field to be written back")
+	}
+}
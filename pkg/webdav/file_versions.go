@@ -0,0 +1,277 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"tealfs/pkg/model"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// defaultVersionsKept is how many replaced versions of a file are kept when
+// no retention is set
+const defaultVersionsKept = 10
+
+var versionsFormat = []byte{0xff, 'v', 'e', 'r'}
+
+// FileVersion is the contents a file had before they were replaced. Chunks
+// are never written over once recorded, so a version only refers to them.
+type FileVersion struct {
+	Number   int
+	Size     int64
+	Modtime  time.Time
+	Replaced time.Time
+	// chunks is nil for contents written before chunking, which stay in the
+	// file's own block
+	chunks []model.BlockId
+}
+
+func (v FileVersion) sameContents(other FileVersion) bool {
+	return v.chunks != nil && v.Size == other.Size && v.Modtime.Equal(other.Modtime) && slices.Equal(v.chunks, other.chunks)
+}
+
+func versionsBlockId(file *File) model.BlockId {
+	return file.Block.Id + ".versions"
+}
+
+func versionsToBytes(versions []FileVersion) []byte {
+	result := append([]byte{}, versionsFormat...)
+	for _, v := range versions {
+		result = append(result, model.IntToBytes(uint32(v.Number))...)
+		result = append(result, model.Int64ToBytes(v.Size)...)
+		result = append(result, timeToBytes(v.Modtime)...)
+		result = append(result, timeToBytes(v.Replaced)...)
+		result = append(result, model.IntToBytes(uint32(len(v.chunks)))...)
+		for _, chunk := range v.chunks {
+			result = append(result, model.StringToBytes(string(chunk))...)
+		}
+	}
+	return result
+}
+
+func versionsFromBytes(raw []byte) ([]FileVersion, error) {
+	versions := []FileVersion{}
+	if len(raw) == 0 {
+		return versions, nil
+	}
+	if !bytes.HasPrefix(raw, versionsFormat) {
+		return nil, errors.New("not a version list")
+	}
+	remainder := raw[len(versionsFormat):]
+	for len(remainder) > 0 {
+		var v FileVersion
+		var number, count uint32
+		number, remainder = model.IntFromBytes(remainder)
+		v.Number = int(number)
+		v.Size, remainder = model.Int64FromBytes(remainder)
+		v.Modtime, remainder = timeFromBytes(remainder)
+		v.Replaced, remainder = timeFromBytes(remainder)
+		count, remainder = model.IntFromBytes(remainder)
+		for range count {
+			var chunk string
+			chunk, remainder = model.StringFromBytes(remainder)
+			v.chunks = append(v.chunks, model.BlockId(chunk))
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// SetVersionRetention keeps the last count versions of every file, along with
+// any replaced within window
+func (f *FileSystem) SetVersionRetention(count int, window time.Duration) {
+	f.exclusively(func() {
+		f.retention.versionsKept = count
+		f.retention.versionWindow = window
+	})
+}
+
+// retained drops the versions that are neither among the newest nor inside
+// the retention window
+func (f *FileSystem) retained(versions []FileVersion, now time.Time) []FileVersion {
+	result := []FileVersion{}
+	for i, v := range versions {
+		if len(versions)-i <= f.retention.versionsKept || now.Sub(v.Replaced) < f.retention.versionWindow {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// currentVersion describes what file holds now, which is what a write is
+// about to replace
+func currentVersion(file *File) *FileVersion {
+	if file.SizeValue == 0 {
+		return nil
+	}
+	return &FileVersion{
+		Size:    file.SizeValue,
+		Modtime: file.Modtime,
+		chunks:  slices.Clone(file.Chunks),
+	}
+}
+
+func (f *FileSystem) fetchVersions(ctx context.Context, file *File) ([]FileVersion, error) {
+	result := f.fetchLatestBlock(ctx, versionsBlockId(file))
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return versionsFromBytes(result.Block.Data)
+}
+
+// saveVersion adds replaced to the history of file, unless it's already the
+// latest version there because a sync that kept it failed afterwards
+func (f *FileSystem) saveVersion(ctx context.Context, file *File, replaced FileVersion) error {
+	return f.updateBlock(ctx, versionsBlockId(file), func(data []byte) ([]byte, error) {
+		versions, err := versionsFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) > 0 && versions[len(versions)-1].sameContents(replaced) {
+			return data, nil
+		}
+		replaced.Number = 1
+		if len(versions) > 0 {
+			replaced.Number = versions[len(versions)-1].Number + 1
+		}
+		replaced.Replaced = time.Now()
+		versions = f.retained(append(versions, replaced), replaced.Replaced)
+		return versionsToBytes(versions), nil
+	})
+}
+
+// versionedFile finds the file at name, which has to be a regular file
+func (f *FileSystem) versionedFile(ctx context.Context, name string) (*File, error) {
+	p, err := PathFromName(name)
+	if err != nil {
+		return nil, err
+	}
+	if isSnapshotPath(p) {
+		return nil, fs.ErrPermission
+	}
+	err = f.loadParents(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	file, exists := f.fileHolder.Get(p)
+	if !exists || file.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	return file, nil
+}
+
+func (f *FileSystem) findVersion(ctx context.Context, file *File, number int) (FileVersion, error) {
+	versions, err := f.fetchVersions(ctx, file)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Number == number {
+			return v, nil
+		}
+	}
+	return FileVersion{}, fs.ErrNotExist
+}
+
+// restoreVersion makes an old version current again, what it replaces
+// becomes a version of its own
+func (f *FileSystem) restoreVersion(ctx context.Context, name string, number int) error {
+	file, err := f.versionedFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	v, err := f.findVersion(ctx, file, number)
+	if err != nil {
+		return err
+	}
+	err = file.sync(ctx)
+	if err != nil {
+		return err
+	}
+	if current := currentVersion(file); current != nil {
+		err = f.saveVersion(ctx, file, *current)
+		if err != nil {
+			return err
+		}
+	}
+	file.SizeValue = v.Size
+	file.Chunks = slices.Clone(v.chunks)
+	file.Modtime = time.Now()
+	file.Ctime = file.Modtime
+	file.Block.Data = []byte{}
+	file.HasData = false
+	file.loaded = nil
+	file.replaced = nil
+	return f.persistEntry(ctx, file)
+}
+
+func (f *FileSystem) Versions(ctx context.Context, name string) ([]FileVersion, error) {
+	var versions []FileVersion
+	var err error
+	f.exclusively(func() {
+		var file *File
+		file, err = f.versionedFile(ctx, name)
+		if err == nil {
+			versions, err = f.fetchVersions(ctx, file)
+		}
+	})
+	return versions, err
+}
+
+// OpenVersion opens an old version of a file for reading
+func (f *FileSystem) OpenVersion(ctx context.Context, name string, number int) (webdav.File, error) {
+	var version *File
+	var err error
+	f.exclusively(func() {
+		var file *File
+		file, err = f.versionedFile(ctx, name)
+		if err != nil {
+			return
+		}
+		var v FileVersion
+		v, err = f.findVersion(ctx, file, number)
+		if err != nil {
+			return
+		}
+		version = &File{
+			SizeValue:  v.Size,
+			ModeValue:  file.ModeValue,
+			Modtime:    v.Modtime,
+			Ctime:      v.Replaced,
+			Birthtime:  file.Birthtime,
+			Block:      model.Block{Id: file.Block.Id, Data: []byte{}},
+			Chunks:     v.chunks,
+			Path:       file.Path,
+			FileSystem: f,
+			readOnly:   true,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Handle{File: version, file: version, ctx: ctx}, nil
+}
+
+func (f *FileSystem) RestoreVersion(ctx context.Context, name string, number int) error {
+	var err error
+	f.exclusively(func() { err = f.restoreVersion(ctx, name, number) })
+	return err
+}
@@ -0,0 +1,185 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"tealfs/pkg/model"
+	"tealfs/pkg/webdav"
+	"testing"
+)
+
+func TestOverwriteKeepsVersions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/notes", "first draft", 0)
+	f, err := fsys.OpenFile(ctx, "/notes", os.O_RDWR|os.O_TRUNC, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	_, _ = f.Write([]byte("second"))
+	_, _ = f.Write([]byte(" draft"))
+	_ = f.Close()
+	writeFile(t, &fsys, "/notes", "S", 0)
+
+	versions, err := fsys.Versions(ctx, "/notes")
+	if err != nil || len(versions) != 2 {
+		t.Error("expected a version for each overwrite", versions, err)
+		return
+	}
+	if versions[0].Size != 11 || versions[1].Size != 12 {
+		t.Error("wrong version sizes", versions)
+	}
+	if contents := readVersion(t, &fsys, "/notes", versions[0].Number); contents != "first draft" {
+		t.Error("wrong first version", contents)
+	}
+	if contents := readVersion(t, &fsys, "/notes", versions[1].Number); contents != "second draft" {
+		t.Error("wrong second version", contents)
+	}
+
+	err = fsys.RestoreVersion(ctx, "/notes", versions[0].Number)
+	if err != nil {
+		t.Error("error restoring", err)
+		return
+	}
+	fs2 := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs2)
+	if contents := readFile(t, &fs2, "/notes"); contents != "first draft" {
+		t.Error("wrong restored contents", contents)
+	}
+	versions, err = fs2.Versions(ctx, "/notes")
+	if err != nil || len(versions) != 3 {
+		t.Error("expected the restore to keep what it replaced", versions, err)
+		return
+	}
+	if contents := readVersion(t, &fs2, "/notes", versions[2].Number); contents != "Second draft" {
+		t.Error("wrong replaced version", contents)
+	}
+
+	// writing over the restored contents leaves the old version alone
+	writeFile(t, &fs2, "/notes", "F", 0)
+	if contents := readVersion(t, &fs2, "/notes", versions[0].Number); contents != "first draft" {
+		t.Error("restored version changed", contents)
+	}
+}
+
+func TestOverwriteWithVersionsUnreadable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/notes", "first draft", 0)
+	info := fileOrDirExists(t, &fsys, "/notes")
+	file, ok := info.(*webdav.File)
+	if !ok {
+		t.Error("unexpected file info", info)
+		return
+	}
+	// a replica is down and the history of the file was never written
+	storage.mux.Lock()
+	storage.unreadable[file.Block.Id+".versions"] = true
+	storage.mux.Unlock()
+
+	writeFile(t, &fsys, "/notes", "second", 0)
+	fs2 := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs2)
+	if contents := readFile(t, &fs2, "/notes"); contents != "seconddraft" {
+		t.Error("expected the overwrite to go through", contents)
+	}
+}
+
+func TestTruncationWaitsForSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/notes", "first draft", 0)
+	// the request that truncates goes away before the truncation is synced
+	reqCtx, reqCancel := context.WithCancel(ctx)
+	defer reqCancel()
+	f, err := fsys.OpenFile(reqCtx, "/notes", os.O_RDWR|os.O_TRUNC, 0)
+	if err != nil {
+		t.Error("error opening file", err)
+		return
+	}
+	if contents := readFile(t, &fsys, "/notes"); contents != "first draft" {
+		t.Error("expected the truncation to wait for a sync", contents)
+	}
+	reqCancel()
+	if err = f.Close(); err == nil {
+		t.Error("expected close to report the failed sync")
+	}
+	if contents := readFile(t, &fsys, "/notes"); contents != "first draft" {
+		t.Error("expected the contents to survive the failed sync", contents)
+	}
+
+	writeFile(t, &fsys, "/notes", "second", 0)
+	versions, err := fsys.Versions(ctx, "/notes")
+	if err != nil || len(versions) != 1 {
+		t.Error("expected a version for the overwrite", versions, err)
+		return
+	}
+	if contents := readVersion(t, &fsys, "/notes", versions[0].Number); contents != "first draft" {
+		t.Error("wrong version", contents)
+	}
+}
+
+func TestVersionRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+	fsys.SetVersionRetention(2, 0)
+
+	for _, contents := range []string{"1", "2", "3", "4", "5"} {
+		writeFile(t, &fsys, "/counter", contents, 0)
+	}
+	versions, err := fsys.Versions(ctx, "/counter")
+	if err != nil || len(versions) != 2 {
+		t.Error("expected only the newest versions to be kept", versions, err)
+		return
+	}
+	if contents := readVersion(t, &fsys, "/counter", versions[0].Number); contents != "3" {
+		t.Error("wrong oldest version kept", contents)
+	}
+	if _, err = fsys.OpenVersion(ctx, "/counter", 1); err == nil {
+		t.Error("expected the first version to be gone")
+	}
+}
+
+func readVersion(t *testing.T, fsys *webdav.FileSystem, name string, number int) string {
+	f, err := fsys.OpenVersion(context.Background(), name, number)
+	if err != nil {
+		t.Error("error opening version", name, number, err)
+		return ""
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Error("error reading version", name, number, err)
+	}
+	return string(data)
+}
@@ -29,23 +29,45 @@ type FileSystem struct {
 	openFileReq  chan openFileReq
 	removeAllReq chan removeAllReq
 	renameReq    chan renameReq
+	exclusiveReq chan func()
 	ReadReqResp  chan ReadReqResp
 	WriteReqResp chan WriteReqResp
 	nodeId       model.NodeId
+	chunkSize    int64
+	// retention is shared by the copies of the FileSystem, the run loop
+	// works on the one made by the constructor
+	retention *retention
+}
+
+// retention is how long replaced file contents and deleted files are kept
+type retention struct {
+	versionsKept  int
+	versionWindow time.Duration
+	trash         time.Duration
 }
 
 func NewFileSystem(nodeId model.NodeId) FileSystem {
+	return NewFileSystemWithChunkSize(nodeId, defaultChunkSize)
+}
+
+func NewFileSystemWithChunkSize(nodeId model.NodeId, chunkSize int64) FileSystem {
 	filesystem := FileSystem{
 		fileHolder:   NewFileHolder(),
 		mkdirReq:     make(chan mkdirReq),
 		openFileReq:  make(chan openFileReq),
 		removeAllReq: make(chan removeAllReq),
 		renameReq:    make(chan renameReq),
+		exclusiveReq: make(chan func()),
 		ReadReqResp:  make(chan ReadReqResp),
 		WriteReqResp: make(chan WriteReqResp),
 		nodeId:       nodeId,
+		chunkSize:    chunkSize,
+		retention: &retention{
+			versionsKept: defaultVersionsKept,
+			trash:        defaultTrashRetention,
+		},
 	}
-	block := model.Block{Id: model.NewBlockId(), Data: []byte{}}
+	block := model.Block{Id: rootDirBlockId, Data: []byte{}}
 	root := File{
 		SizeValue:  0,
 		ModeValue:  fs.ModeDir,
@@ -69,30 +91,58 @@ type WriteReqResp struct {
 type ReadReqResp struct {
 	Req  model.BlockId
 	Resp chan model.BlockResponse
+	// Latest asks every replica, for blocks that are about to be rewritten
+	Latest bool
 }
 
-func (f *FileSystem) fetchBlock(id model.BlockId) model.BlockResponse {
-	req := id
-	resp := make(chan model.BlockResponse)
-	f.ReadReqResp <- ReadReqResp{req, resp}
-	return <-resp
+// blockRequestTimeout bounds how long a WebDAV request waits on the cluster for one block
+const blockRequestTimeout = 30 * time.Second
+
+func (f *FileSystem) fetchBlock(ctx context.Context, id model.BlockId) model.BlockResponse {
+	return f.readBlock(ctx, ReadReqResp{Req: id})
 }
 
-func (f *FileSystem) immediateChildren(path Path) []*File {
-	children := make([]*File, 0)
-	neededPathLen := len(path) + 1
-	for _, file := range f.fileHolder.AllFiles() {
-		if len(file.Path) == neededPathLen && file.Path.startsWith(path) {
-			children = append(children, file)
-		}
+func (f *FileSystem) fetchLatestBlock(ctx context.Context, id model.BlockId) model.BlockResponse {
+	return f.readBlock(ctx, ReadReqResp{Req: id, Latest: true})
+}
+
+func (f *FileSystem) readBlock(ctx context.Context, req ReadReqResp) model.BlockResponse {
+	ctx, cancel := context.WithTimeout(ctx, blockRequestTimeout)
+	defer cancel()
+	resp := make(chan model.BlockResponse, 1)
+	req.Resp = resp
+	select {
+	case f.ReadReqResp <- req:
+	case <-ctx.Done():
+		return model.BlockResponse{Block: model.Block{Id: req.Req}, Err: ctx.Err()}
+	}
+	select {
+	case r := <-resp:
+		return r
+	case <-ctx.Done():
+		return model.BlockResponse{Block: model.Block{Id: req.Req}, Err: ctx.Err()}
 	}
-	return children
 }
 
-func (f *FileSystem) pushBlock(block model.Block) model.BlockIdResponse {
-	resp := make(chan model.BlockIdResponse)
-	f.WriteReqResp <- WriteReqResp{Req: block, Resp: resp}
-	return <-resp
+func (f *FileSystem) immediateChildren(path Path) []*File {
+	return f.fileHolder.children(path)
+}
+
+func (f *FileSystem) pushBlock(ctx context.Context, block model.Block) model.BlockIdResponse {
+	ctx, cancel := context.WithTimeout(ctx, blockRequestTimeout)
+	defer cancel()
+	resp := make(chan model.BlockIdResponse, 1)
+	select {
+	case f.WriteReqResp <- WriteReqResp{Req: block, Resp: resp}:
+	case <-ctx.Done():
+		return model.BlockIdResponse{BlockId: block.Id, Err: ctx.Err()}
+	}
+	select {
+	case r := <-resp:
+		return r
+	case <-ctx.Done():
+		return model.BlockIdResponse{BlockId: block.Id, Err: ctx.Err()}
+	}
 }
 
 func (f *FileSystem) run() {
@@ -101,15 +151,28 @@ func (f *FileSystem) run() {
 		case req := <-f.mkdirReq:
 			req.respChan <- f.mkdir(&req)
 		case req := <-f.openFileReq:
-			req.respChan <- f.openFile(&req)
+			req.respChan <- f.openView(&req)
 		case req := <-f.removeAllReq:
 			req.respChan <- f.removeAll(&req)
 		case req := <-f.renameReq:
 			req.respChan <- f.rename(&req)
+		case op := <-f.exclusiveReq:
+			op()
 		}
 	}
 }
 
+// exclusively runs op in the run loop, for the operations that aren't part of
+// webdav.FileSystem
+func (f *FileSystem) exclusively(op func()) {
+	done := make(chan struct{})
+	f.exclusiveReq <- func() {
+		op()
+		close(done)
+	}
+	<-done
+}
+
 type mkdirReq struct {
 	ctx      context.Context
 	name     string
@@ -134,8 +197,11 @@ func (f *FileSystem) mkdir(req *mkdirReq) error {
 	if err != nil {
 		return err
 	}
+	if isSnapshotPath(p) {
+		return fs.ErrPermission
+	}
 
-	err = f.fetchFileIndex()
+	err = f.loadParents(req.ctx, p)
 	if err != nil {
 		return err
 	}
@@ -145,23 +211,22 @@ func (f *FileSystem) mkdir(req *mkdirReq) error {
 		return errors.New("path exists")
 	}
 
-	base, err := p.base()
+	parent, err := f.parentOf(p)
 	if err != nil {
 		return err
 	}
-	exists = f.fileHolder.Exists(base)
-	if !exists {
-		return errors.New("invalid path")
-	}
 
 	block := model.Block{
 		Id:   model.NewBlockId(),
 		Data: []byte{},
 	}
+	now := time.Now()
 	dir := File{
 		SizeValue:  0,
 		ModeValue:  fs.ModeDir,
-		Modtime:    time.Now(),
+		Modtime:    now,
+		Ctime:      now,
+		Birthtime:  now,
 		Position:   0,
 		Block:      block,
 		HasData:    false,
@@ -169,13 +234,16 @@ func (f *FileSystem) mkdir(req *mkdirReq) error {
 		FileSystem: f,
 	}
 
-	f.fileHolder.Add(&dir)
-	err = f.persistFileIndex()
-	if err != nil {
-		return err
+	result := f.pushBlock(req.ctx, model.Block{Id: block.Id, Data: dirToBytes(nil)})
+	if result.Err != nil {
+		return result.Err
 	}
-
-	return nil
+	return f.updateDir(req.ctx, parent, func(children []*File) ([]*File, error) {
+		if existing := childNamed(children, dir.Name()); existing != nil && existing.Block.Id != block.Id {
+			return nil, errors.New("path exists")
+		}
+		return withChild(children, &dir), nil
+	})
 }
 
 type removeAllReq struct {
@@ -189,8 +257,11 @@ func (f *FileSystem) removeAll(req *removeAllReq) error {
 	if err != nil {
 		return err
 	}
+	if isSnapshotPath(pathToDelete) {
+		return fs.ErrPermission
+	}
 
-	err = f.fetchFileIndex()
+	err = f.loadParents(req.ctx, pathToDelete)
 	if err != nil {
 		return err
 	}
@@ -199,41 +270,31 @@ func (f *FileSystem) removeAll(req *removeAllReq) error {
 	if !exists {
 		return errors.New("file does not exist")
 	}
-	for _, file := range f.fileHolder.AllFiles() {
-		if file.Path.startsWith(pathToDelete) {
-			f.fileHolder.Delete(file)
+
+	// the root itself stays, only what's in it goes
+	if len(pathToDelete) == 0 {
+		children, _, err := f.fetchDir(req.ctx, baseFile)
+		if err != nil {
+			return err
 		}
+		f.moveToTrash(req.ctx, children)
+		return f.updateDir(req.ctx, baseFile, func(current []*File) ([]*File, error) {
+			for _, child := range children {
+				current = withoutListed(current, child)
+			}
+			return current, nil
+		})
 	}
-	f.fileHolder.Delete(baseFile)
 
-	err = f.persistFileIndex()
+	parent, err := f.parentOf(pathToDelete)
 	if err != nil {
 		return err
 	}
-
-	return nil
-}
-
-func (f *FileSystem) fetchFileIndex() error {
-	result := f.fetchBlock("fileIndex")
-
-	if result.Err != nil {
-		return result.Err
-	}
-
-	return f.fileHolder.UpdateFileHolderFromBytes(result.Block.Data, f)
-}
-
-func (f *FileSystem) persistFileIndex() error {
-	result := f.pushBlock(model.Block{
-		Id:   "fileIndex",
-		Data: f.fileHolder.ToBytes(),
+	// in the trash first, so a failure part way can't lose the file
+	f.moveToTrash(req.ctx, []*File{baseFile})
+	return f.updateDir(req.ctx, parent, func(children []*File) ([]*File, error) {
+		return withoutListed(children, baseFile), nil
 	})
-
-	if result.Err != nil {
-		return result.Err
-	}
-	return nil
 }
 
 func (f *FileSystem) RemoveAll(ctx context.Context, name string) error {
@@ -276,8 +337,15 @@ func (f *FileSystem) rename(req *renameReq) error {
 	if err != nil {
 		return err
 	}
+	if isSnapshotPath(oldPath) || isSnapshotPath(newPath) {
+		return fs.ErrPermission
+	}
 
-	err = f.fetchFileIndex()
+	err = f.loadParents(req.ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	err = f.loadParents(req.ctx, newPath)
 	if err != nil {
 		return err
 	}
@@ -286,7 +354,22 @@ func (f *FileSystem) rename(req *renameReq) error {
 	if !exists {
 		return errors.New("file not found")
 	}
+	oldParent, err := f.parentOf(oldPath)
+	if err != nil {
+		return err
+	}
+	newParent, err := f.parentOf(newPath)
+	if err != nil {
+		return err
+	}
+	oldName := file.Name()
+	if replaced, exists := f.fileHolder.Get(newPath); exists && replaced != file {
+		// what the rename writes over can be restored like a delete
+		f.moveToTrash(req.ctx, []*File{replaced})
+		f.forget(replaced)
+	}
 
+	file.Ctime = time.Now()
 	if file.IsDir() {
 		for _, child := range f.fileHolder.AllFiles() {
 			if child.Path.startsWith(oldPath) {
@@ -301,12 +384,28 @@ func (f *FileSystem) rename(req *renameReq) error {
 		f.fileHolder.Add(file)
 	}
 
-	err = f.persistFileIndex()
+	unlist := func(children []*File) []*File {
+		if existing := childNamed(children, oldName); existing != nil && existing.Block.Id == file.Block.Id {
+			return withoutChild(children, oldName)
+		}
+		return children
+	}
+	if oldParent == newParent {
+		return f.updateDir(req.ctx, newParent, func(children []*File) ([]*File, error) {
+			return withChild(unlist(children), file), nil
+		})
+	}
+
+	// the new parent goes first so a failure part way leaves two names, not none
+	err = f.updateDir(req.ctx, newParent, func(children []*File) ([]*File, error) {
+		return withChild(children, file), nil
+	})
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return f.updateDir(req.ctx, oldParent, func(children []*File) ([]*File, error) {
+		return unlist(children), nil
+	})
 }
 
 func (f *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
@@ -319,5 +418,8 @@ func (f *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error)
 		respChan: respChan,
 	}
 	resp := <-respChan
-	return resp.file, resp.err
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return resp.view, nil
 }
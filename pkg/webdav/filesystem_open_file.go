@@ -35,6 +35,7 @@ type openFileReq struct {
 
 type openFileResp struct {
 	file *File
+	view *File
 	err  error
 }
 
@@ -48,7 +49,42 @@ func (f *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm o
 		respChan: respChan,
 	}
 	resp := <-respChan
-	return resp.file, resp.err
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	return &Handle{File: resp.view, file: resp.file, ctx: ctx}, nil
+}
+
+// openView opens the file along with the view of it a handle works on
+func (f *FileSystem) openView(req *openFileReq) openFileResp {
+	resp := f.openFile(req)
+	if resp.err != nil {
+		return resp
+	}
+	file := resp.file
+	view := file.view()
+	// what an earlier handle failed to keep as a version is kept by this one
+	view.replaced = file.replaced
+	file.replaced = nil
+	// a truncation is only recorded in the directory when the handle syncs,
+	// along with the version it replaced
+	if os.O_TRUNC&req.flag != 0 && !file.IsDir() && file.SizeValue > 0 {
+		if view.replaced == nil {
+			view.replaced = currentVersion(file)
+		}
+		view.SizeValue = 0
+		view.Modtime = time.Now()
+		view.Ctime = view.Modtime
+		view.Chunks = []model.BlockId{}
+		view.Block.Data = []byte{}
+		view.HasData = false
+		view.entryDirty = true
+	}
+	if os.O_APPEND&req.flag != 0 {
+		view.Position = view.SizeValue
+	}
+	resp.view = view
+	return resp
 }
 
 func (f *FileSystem) openFile(req *openFileReq) openFileResp {
@@ -79,7 +115,19 @@ func (f *FileSystem) openFile(req *openFileReq) openFileResp {
 		return openFileResp{err: err}
 	}
 
-	err = f.fetchFileIndex()
+	// snapshots can be read, never changed
+	if isSnapshotPath(path) {
+		if !ro {
+			return openFileResp{err: fs.ErrPermission}
+		}
+		file, err := f.openSnapshotPath(req.ctx, path)
+		if err != nil {
+			return openFileResp{err: err}
+		}
+		return openFileResp{file: file}
+	}
+
+	err = f.loadParents(req.ctx, path)
 	if err != nil {
 		return openFileResp{err: err}
 	}
@@ -91,6 +139,10 @@ func (f *FileSystem) openFile(req *openFileReq) openFileResp {
 		if !exists {
 			return openFileResp{err: fs.ErrNotExist}
 		}
+		err = f.loadDir(req.ctx, file)
+		if err != nil {
+			return openFileResp{err: err}
+		}
 		return openFileResp{file: file}
 	}
 
@@ -104,27 +156,50 @@ func (f *FileSystem) openFile(req *openFileReq) openFileResp {
 		return openFileResp{err: fs.ErrNotExist}
 	}
 
+	if exists && file.IsDir() {
+		err = f.loadDir(req.ctx, file)
+		if err != nil {
+			return openFileResp{err: err}
+		}
+	}
+
 	if !exists {
+		parent, err := f.parentOf(path)
+		if err != nil {
+			return openFileResp{err: fs.ErrNotExist}
+		}
 		block := model.Block{Id: model.NewBlockId(), Data: []byte{}}
-		file = &File{
+		now := time.Now()
+		created := &File{
 			SizeValue:  0,
 			ModeValue:  req.perm,
-			Modtime:    time.Now(),
+			Modtime:    now,
+			Ctime:      now,
+			Birthtime:  now,
 			Position:   0,
 			Block:      block,
 			HasData:    false,
 			Path:       path,
 			FileSystem: f,
 		}
-		f.fileHolder.Add(file)
-		err = f.persistFileIndex()
+		err = f.updateDir(req.ctx, parent, func(children []*File) ([]*File, error) {
+			existing := childNamed(children, created.Name())
+			if existing == nil || existing.Block.Id == block.Id {
+				return withChild(children, created), nil
+			}
+			// another node created it first
+			if failIfExists {
+				return nil, fs.ErrExist
+			}
+			return children, nil
+		})
 		if err != nil {
 			return openFileResp{err: err}
 		}
-	}
-
-	if append {
-		file.Position = file.SizeValue
+		file, exists = f.fileHolder.Get(path)
+		if !exists {
+			return openFileResp{err: fs.ErrNotExist}
+		}
 	}
 	return openFileResp{file: file}
 }
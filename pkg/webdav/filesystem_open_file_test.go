@@ -23,6 +23,7 @@ import (
 	"tealfs/pkg/model"
 	"tealfs/pkg/webdav"
 	"testing"
+	"time"
 )
 
 func TestCreateEmptyFile(t *testing.T) {
@@ -105,6 +106,17 @@ func TestOpenRoot(t *testing.T) {
 	}
 }
 
+func TestOpenFileTimesOut(t *testing.T) {
+	filesystem := webdav.NewFileSystem(model.NewNodeId())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := filesystem.OpenFile(ctx, "/hello-world.txt", os.O_RDONLY, 0444)
+	if err == nil {
+		t.Error("expected open to fail when the cluster never answers")
+	}
+}
+
 func handleFetchBlockReq(ctx context.Context, reqs chan webdav.ReadReqResp, mux *sync.Mutex, data map[model.BlockId][]byte) {
 	for {
 		select {
@@ -155,8 +167,10 @@ func handlePushBlockReq(ctx context.Context, reqs chan webdav.WriteReqResp, mux
 }
 
 func mockPushesAndPulls(ctx context.Context, fs *webdav.FileSystem) {
-	mux := sync.Mutex{}
-	mockStorage := make(map[model.BlockId][]byte)
-	go handleFetchBlockReq(ctx, fs.ReadReqResp, &mux, mockStorage)
-	go handlePushBlockReq(ctx, fs.WriteReqResp, &mux, mockStorage)
+	mockPushesAndPullsWith(ctx, fs, &sync.Mutex{}, make(map[model.BlockId][]byte))
+}
+
+func mockPushesAndPullsWith(ctx context.Context, fs *webdav.FileSystem, mux *sync.Mutex, mockStorage map[model.BlockId][]byte) {
+	go handleFetchBlockReq(ctx, fs.ReadReqResp, mux, mockStorage)
+	go handlePushBlockReq(ctx, fs.WriteReqResp, mux, mockStorage)
 }
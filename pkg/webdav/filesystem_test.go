@@ -17,10 +17,14 @@ package webdav_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
 	"tealfs/pkg/model"
 	"tealfs/pkg/webdav"
 	"testing"
+	"time"
 )
 
 func TestMkdir(t *testing.T) {
@@ -178,6 +182,210 @@ func TestWriteAndRead(t *testing.T) {
 	}
 }
 
+func TestOnlyTouchedDirectoriesAreWritten(t *testing.T) {
+	fs := webdav.NewFileSystem(model.NewNodeId())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mux := sync.Mutex{}
+	storage := make(map[model.BlockId][]byte)
+	pushed := []model.BlockId{}
+	go handleFetchBlockReq(ctx, fs.ReadReqResp, &mux, storage)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req := <-fs.WriteReqResp:
+				mux.Lock()
+				storage[req.Req.Id] = req.Req.Data
+				pushed = append(pushed, req.Req.Id)
+				mux.Unlock()
+				req.Resp <- model.BlockIdResponse{BlockId: req.Req.Id}
+			}
+		}
+	}()
+
+	_ = fs.Mkdir(ctx, "/a", os.ModeDir)
+	_ = fs.Mkdir(ctx, "/b", os.ModeDir)
+	_ = fs.Mkdir(ctx, "/b/c", os.ModeDir)
+	createFileAndCheck(t, &fs, "/a/apple")
+	c := dirBlockId(t, &fs, "/b/c")
+
+	mux.Lock()
+	pushed = pushed[:0]
+	mux.Unlock()
+
+	createFileAndCheck(t, &fs, "/b/c/pear")
+
+	mux.Lock()
+	defer mux.Unlock()
+	if len(pushed) != 1 || pushed[0] != c {
+		t.Error("expected only the parent directory to be written", pushed)
+	}
+}
+
+func TestMigrateFlatFileIndex(t *testing.T) {
+	dirPath, _ := webdav.PathFromName("/dir")
+	filePath, _ := webdav.PathFromName("/dir/file")
+	dir := webdav.File{ModeValue: os.ModeDir, Modtime: time.Unix(1, 0), Block: model.Block{Id: model.NewBlockId()}, Path: dirPath}
+	file := webdav.File{SizeValue: 3, ModeValue: 0666, Modtime: time.Unix(2, 0), Block: model.Block{Id: model.NewBlockId()}, Path: filePath}
+	legacy := webdav.NewFileHolder()
+	legacy.Add(&dir)
+	legacy.Add(&file)
+
+	mux := sync.Mutex{}
+	storage := map[model.BlockId][]byte{"fileIndex": legacy.ToBytes()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := webdav.NewFileSystem(model.NewNodeId())
+	mockPushesAndPullsWith(ctx, &fs, &mux, storage)
+
+	fileExists(t, &fs, "/dir/file")
+	mux.Lock()
+	_, migrated := storage[dir.Block.Id]
+	mux.Unlock()
+	if !migrated {
+		t.Error("expected the directory to get its own block")
+		return
+	}
+
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	mockPushesAndPullsWith(ctx, &fs2, &mux, storage)
+	fileExists(t, &fs2, "/dir/file")
+	dirExists(t, &fs2, "/dir")
+}
+
+func TestConcurrentCreatesThroughTwoNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs1 := webdav.NewFileSystem(model.NewNodeId())
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs1)
+	storage.serve(ctx, &fs2)
+
+	err := fs1.Mkdir(ctx, "/shared", os.ModeDir)
+	if err != nil {
+		t.Error("error creating dir", err)
+		return
+	}
+
+	const perNode = 5
+	wg := sync.WaitGroup{}
+	for i := range perNode {
+		for n, fs := range []*webdav.FileSystem{&fs1, &fs2} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f, err := fs.OpenFile(ctx, fmt.Sprintf("/shared/%d-%d", n, i), os.O_RDWR|os.O_CREATE, 0666)
+				if err != nil {
+					t.Error("error creating file", err)
+					return
+				}
+				_ = f.Close()
+			}()
+		}
+	}
+	wg.Wait()
+
+	fs3 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs3)
+	dir, err := fs3.OpenFile(ctx, "/shared", os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening dir", err)
+		return
+	}
+	children, err := dir.Readdir(0)
+	if err != nil || len(children) != 2*perNode {
+		t.Error("expected every create to survive", len(children), err)
+	}
+}
+
+// versionedStorage refuses conditional writes the way the cluster does.
+// Reads of unreadable blocks fail as if too few replicas were up.
+type versionedStorage struct {
+	mux        sync.Mutex
+	blocks     map[model.BlockId]model.Block
+	unreadable map[model.BlockId]bool
+	version    model.Version
+	fetched    []model.BlockId
+	latest     []model.BlockId
+	pushed     []model.BlockId
+}
+
+func newVersionedStorage() *versionedStorage {
+	return &versionedStorage{
+		blocks:     make(map[model.BlockId]model.Block),
+		unreadable: make(map[model.BlockId]bool),
+	}
+}
+
+func (s *versionedStorage) serve(ctx context.Context, fs *webdav.FileSystem) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case req := <-fs.ReadReqResp:
+				s.mux.Lock()
+				s.fetched = append(s.fetched, req.Req)
+				if req.Latest {
+					s.latest = append(s.latest, req.Req)
+				}
+				block, exists := s.blocks[req.Req]
+				if !exists {
+					block = model.Block{Id: req.Req, Data: []byte{}}
+				}
+				var err error
+				if s.unreadable[req.Req] {
+					err = errors.New("no replica of the block could be read")
+				}
+				s.mux.Unlock()
+				// leave the other node time to get a write in
+				time.Sleep(time.Millisecond)
+				req.Resp <- model.BlockResponse{Block: block, Err: err}
+			case req := <-fs.WriteReqResp:
+				s.mux.Lock()
+				s.pushed = append(s.pushed, req.Req.Id)
+				var err error
+				if req.Req.IfVersion && s.blocks[req.Req.Id].Version > req.Req.Version {
+					err = model.ErrVersionConflict
+				} else {
+					s.version++
+					s.blocks[req.Req.Id] = model.Block{Id: req.Req.Id, Data: req.Req.Data, Version: s.version}
+				}
+				s.mux.Unlock()
+				req.Resp <- model.BlockIdResponse{BlockId: req.Req.Id, Err: err}
+			}
+		}
+	}()
+}
+
+// reset forgets which blocks were fetched and pushed so far
+func (s *versionedStorage) reset() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.fetched = nil
+	s.latest = nil
+	s.pushed = nil
+}
+
+func (s *versionedStorage) counts() (int, int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.fetched), len(s.pushed)
+}
+
+func dirBlockId(t *testing.T, fs *webdav.FileSystem, name string) model.BlockId {
+	info := fileOrDirExists(t, fs, name)
+	dir, ok := info.(*webdav.File)
+	if !ok {
+		t.Error("unexpected file info")
+		return ""
+	}
+	return dir.Block.Id
+}
+
 func fileExists(t *testing.T, fs *webdav.FileSystem, name string) {
 	f := fileOrDirExists(t, fs, name)
 	if f.IsDir() {
@@ -0,0 +1,345 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"tealfs/pkg/model"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// locksBlockId names the block holding the WebDAV locks of the whole cluster
+const locksBlockId model.BlockId = "locks"
+
+var locksFormat = []byte{0xff, 'l', 'c', 'k'}
+
+type clusterLock struct {
+	token   string
+	details webdav.LockDetails
+	// expiry is zero for locks that never expire
+	expiry time.Time
+}
+
+// LockSystem keeps WebDAV locks in a replicated block, so a lock taken
+// through one node holds on every node and survives restarts. Locks being
+// confirmed by a request are only held on the node serving it, and so are
+// the temporary locks NewHandler takes for the length of a single request.
+type LockSystem struct {
+	fileSystem *FileSystem
+	mux        sync.Mutex
+	held       map[string]bool
+	local      map[string]webdav.LockDetails
+}
+
+func NewLockSystem(fileSystem *FileSystem) *LockSystem {
+	return &LockSystem{
+		fileSystem: fileSystem,
+		held:       make(map[string]bool),
+		local:      make(map[string]webdav.LockDetails),
+	}
+}
+
+func locksToBytes(locks []clusterLock) []byte {
+	result := append([]byte{}, locksFormat...)
+	for _, l := range locks {
+		result = append(result, model.StringToBytes(l.token)...)
+		result = append(result, model.StringToBytes(l.details.Root)...)
+		result = append(result, model.Int64ToBytes(int64(l.details.Duration))...)
+		result = append(result, model.StringToBytes(l.details.OwnerXML)...)
+		result = append(result, model.BoolToBytes(l.details.ZeroDepth)...)
+		result = append(result, timeToBytes(l.expiry)...)
+	}
+	return result
+}
+
+func locksFromBytes(raw []byte) ([]clusterLock, error) {
+	locks := []clusterLock{}
+	if len(raw) == 0 {
+		return locks, nil
+	}
+	if !bytes.HasPrefix(raw, locksFormat) {
+		return nil, errors.New("not a lock list")
+	}
+	remainder := raw[len(locksFormat):]
+	for len(remainder) > 0 {
+		var l clusterLock
+		var duration int64
+		l.token, remainder = model.StringFromBytes(remainder)
+		l.details.Root, remainder = model.StringFromBytes(remainder)
+		duration, remainder = model.Int64FromBytes(remainder)
+		l.details.Duration = time.Duration(duration)
+		l.details.OwnerXML, remainder = model.StringFromBytes(remainder)
+		l.details.ZeroDepth, remainder = model.BoolFromBytes(remainder)
+		l.expiry, remainder = timeFromBytes(remainder)
+		locks = append(locks, l)
+	}
+	return locks, nil
+}
+
+func unexpiredLocks(locks []clusterLock, now time.Time) []clusterLock {
+	result := []clusterLock{}
+	for _, l := range locks {
+		if l.expiry.IsZero() || now.Before(l.expiry) {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// fetchLocks reads the locks for a request to check against. A read quorum
+// overlaps the majority every change to the locks waits for, so it doesn't
+// have to ask every replica the way changing them does.
+func (l *LockSystem) fetchLocks(now time.Time) ([]clusterLock, error) {
+	result := l.fileSystem.fetchBlock(context.Background(), locksBlockId)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	locks, err := locksFromBytes(result.Block.Data)
+	if err != nil {
+		return nil, err
+	}
+	return unexpiredLocks(locks, now), nil
+}
+
+// updateLocks applies change to the locks of the cluster, dropping the
+// expired ones on the way
+func (l *LockSystem) updateLocks(now time.Time, change func([]clusterLock) ([]clusterLock, error)) error {
+	return l.fileSystem.updateBlock(context.Background(), locksBlockId, func(data []byte) ([]byte, error) {
+		locks, err := locksFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		locks, err = change(unexpiredLocks(locks, now))
+		if err != nil {
+			return nil, err
+		}
+		return locksToBytes(locks), nil
+	})
+}
+
+func expiryOf(now time.Time, duration time.Duration) time.Time {
+	if duration < 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
+
+// covers is whether name falls under root
+func covers(root string, name string) bool {
+	return root == "/" || strings.HasPrefix(name, root+"/")
+}
+
+// conflicts is whether two locks can't be held at the same time
+func conflicts(a webdav.LockDetails, b webdav.LockDetails) bool {
+	return a.Root == b.Root ||
+		(covers(a.Root, b.Root) && !a.ZeroDepth) ||
+		(covers(b.Root, a.Root) && !b.ZeroDepth)
+}
+
+func (l *LockSystem) conflictsLocally(details webdav.LockDetails) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for _, local := range l.local {
+		if conflicts(local, details) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup finds the lock named by the conditions that applies to name
+func (l *LockSystem) lookup(locks []clusterLock, name string, conditions ...webdav.Condition) *clusterLock {
+	for _, c := range conditions {
+		for i := range locks {
+			lock := &locks[i]
+			if lock.token != c.Token || l.held[lock.token] {
+				continue
+			}
+			if name == lock.details.Root || (!lock.details.ZeroDepth && covers(lock.details.Root, name)) {
+				return lock
+			}
+		}
+	}
+	return nil
+}
+
+func (l *LockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	locks, err := l.fetchLocks(now)
+	if err != nil {
+		return nil, err
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	tokens := []string{}
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		lock := l.lookup(locks, path.Clean("/"+name), conditions...)
+		if lock == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		if len(tokens) == 0 || tokens[0] != lock.token {
+			tokens = append(tokens, lock.token)
+		}
+	}
+	for _, token := range tokens {
+		l.held[token] = true
+	}
+	return func() {
+		l.mux.Lock()
+		defer l.mux.Unlock()
+		for _, token := range tokens {
+			delete(l.held, token)
+		}
+	}, nil
+}
+
+func (l *LockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = path.Clean("/" + details.Root)
+	token := "urn:uuid:" + string(model.NewBlockId())
+	err := l.updateLocks(now, func(locks []clusterLock) ([]clusterLock, error) {
+		for _, lock := range locks {
+			if conflicts(lock.details, details) {
+				return nil, webdav.ErrLocked
+			}
+		}
+		if l.conflictsLocally(details) {
+			return nil, webdav.ErrLocked
+		}
+		return append(locks, clusterLock{
+			token:   token,
+			details: details,
+			expiry:  expiryOf(now, details.Duration),
+		}), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// createTemporary takes a lock for the length of a single request, which
+// only has to keep out the locks of the cluster and not be seen by the
+// other nodes
+func (l *LockSystem) createTemporary(now time.Time, details webdav.LockDetails) (string, error) {
+	details.Root = path.Clean("/" + details.Root)
+	token := "urn:uuid:" + string(model.NewBlockId())
+	locks, err := l.fetchLocks(now)
+	if err != nil {
+		return "", err
+	}
+	for _, lock := range locks {
+		if conflicts(lock.details, details) {
+			return "", webdav.ErrLocked
+		}
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for _, local := range l.local {
+		if conflicts(local, details) {
+			return "", webdav.ErrLocked
+		}
+	}
+	l.local[token] = details
+	return token, nil
+}
+
+// requestLocks is the lock system as seen by a single request. x/net/webdav
+// only creates the locks clients ask for while serving LOCK, the ones it
+// creates for any other method are held until the request is done.
+type requestLocks struct {
+	*LockSystem
+	method string
+}
+
+func (r requestLocks) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	if r.method == "LOCK" {
+		return r.LockSystem.Create(now, details)
+	}
+	return r.createTemporary(now, details)
+}
+
+// NewHandler serves WebDAV from fileSystem, keeping the locks taken for a
+// single request on this node and every other lock in the cluster's locks
+func NewHandler(fileSystem *FileSystem, lockSystem *LockSystem) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler := &webdav.Handler{
+			Prefix:     "/",
+			FileSystem: fileSystem,
+			LockSystem: requestLocks{LockSystem: lockSystem, method: r.Method},
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (l *LockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	if l.isHeld(token) {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	var details webdav.LockDetails
+	err := l.updateLocks(now, func(locks []clusterLock) ([]clusterLock, error) {
+		for i := range locks {
+			if locks[i].token == token {
+				locks[i].details.Duration = duration
+				locks[i].expiry = expiryOf(now, duration)
+				details = locks[i].details
+				return locks, nil
+			}
+		}
+		return nil, webdav.ErrNoSuchLock
+	})
+	return details, err
+}
+
+func (l *LockSystem) Unlock(now time.Time, token string) error {
+	if l.isHeld(token) {
+		return webdav.ErrLocked
+	}
+	if l.unlockLocal(token) {
+		return nil
+	}
+	return l.updateLocks(now, func(locks []clusterLock) ([]clusterLock, error) {
+		for i := range locks {
+			if locks[i].token == token {
+				return append(locks[:i], locks[i+1:]...), nil
+			}
+		}
+		return nil, webdav.ErrNoSuchLock
+	})
+}
+
+func (l *LockSystem) unlockLocal(token string) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	_, ok := l.local[token]
+	delete(l.local, token)
+	return ok
+}
+
+func (l *LockSystem) isHeld(token string) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.held[token]
+}
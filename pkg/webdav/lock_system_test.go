@@ -0,0 +1,206 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"tealfs/pkg/model"
+	"tealfs/pkg/webdav"
+	"testing"
+	"time"
+
+	xwebdav "golang.org/x/net/webdav"
+)
+
+func TestLocksHoldAcrossNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs1 := webdav.NewFileSystem(model.NewNodeId())
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs1)
+	storage.serve(ctx, &fs2)
+	ls1 := webdav.NewLockSystem(&fs1)
+	ls2 := webdav.NewLockSystem(&fs2)
+	now := time.Now()
+
+	token, err := ls1.Create(now, xwebdav.LockDetails{Root: "/docs", Duration: time.Minute})
+	if err != nil {
+		t.Error("error locking", err)
+		return
+	}
+	_, err = ls2.Create(now, xwebdav.LockDetails{Root: "/docs/report.docx", Duration: time.Minute, ZeroDepth: true})
+	if !errors.Is(err, xwebdav.ErrLocked) {
+		t.Error("expected the lock to hold on the other node", err)
+	}
+	_, err = ls2.Create(now, xwebdav.LockDetails{Root: "/", Duration: time.Minute})
+	if !errors.Is(err, xwebdav.ErrLocked) {
+		t.Error("expected a lock on the parent to be refused", err)
+	}
+
+	release, err := ls2.Confirm(now, "/docs/report.docx", "", xwebdav.Condition{Token: token})
+	if err != nil {
+		t.Error("error confirming through the other node", err)
+		return
+	}
+	if err = ls2.Unlock(now, token); !errors.Is(err, xwebdav.ErrLocked) {
+		t.Error("expected a held lock to stay", err)
+	}
+	release()
+	_, err = ls2.Confirm(now, "/other", "", xwebdav.Condition{Token: token})
+	if !errors.Is(err, xwebdav.ErrConfirmationFailed) {
+		t.Error("expected the lock not to cover other paths", err)
+	}
+
+	err = ls2.Unlock(now, token)
+	if err != nil {
+		t.Error("error unlocking", err)
+		return
+	}
+	if err = ls1.Unlock(now, token); !errors.Is(err, xwebdav.ErrNoSuchLock) {
+		t.Error("expected the lock to be gone", err)
+	}
+	_, err = ls1.Create(now, xwebdav.LockDetails{Root: "/docs", Duration: time.Minute})
+	if err != nil {
+		t.Error("error locking again", err)
+	}
+}
+
+func TestOwnerlessInfiniteLockHoldsAcrossNodes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fs1 := webdav.NewFileSystem(model.NewNodeId())
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs1)
+	storage.serve(ctx, &fs2)
+	handler1 := webdav.NewHandler(&fs1, webdav.NewLockSystem(&fs1))
+	handler2 := webdav.NewHandler(&fs2, webdav.NewLockSystem(&fs2))
+
+	// the same details x/net/webdav uses for the locks of single requests
+	lock := func(handler http.Handler) int {
+		body := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+		req := httptest.NewRequest("LOCK", "/report.docx", strings.NewReader(body))
+		req.Header.Set("Timeout", "Infinite")
+		req.Header.Set("Depth", "0")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status := lock(handler1); status != http.StatusOK && status != http.StatusCreated {
+		t.Error("error locking", status)
+		return
+	}
+	if status := lock(handler2); status != http.StatusLocked {
+		t.Error("expected the lock to hold on the other node", status)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/report.docx", strings.NewReader("data"))
+	rec := httptest.NewRecorder()
+	handler2.ServeHTTP(rec, req)
+	if rec.Code != http.StatusLocked {
+		t.Error("expected a write through the other node to be refused", rec.Code)
+	}
+}
+
+func TestLocksExpireUnlessRefreshed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+	ls := webdav.NewLockSystem(&fsys)
+	now := time.Now()
+
+	token, err := ls.Create(now, xwebdav.LockDetails{Root: "/a", Duration: time.Minute})
+	if err != nil {
+		t.Error("error locking", err)
+		return
+	}
+	details, err := ls.Refresh(now.Add(50*time.Second), token, time.Minute)
+	if err != nil || details.Root != "/a" {
+		t.Error("error refreshing", details, err)
+		return
+	}
+	_, err = ls.Create(now.Add(90*time.Second), xwebdav.LockDetails{Root: "/a", Duration: time.Minute})
+	if !errors.Is(err, xwebdav.ErrLocked) {
+		t.Error("expected the refreshed lock to still hold", err)
+	}
+	_, err = ls.Create(now.Add(2*time.Minute), xwebdav.LockDetails{Root: "/a", Duration: time.Minute})
+	if err != nil {
+		t.Error("expected the lock to have expired", err)
+	}
+	if _, err = ls.Refresh(now.Add(2*time.Minute), token, time.Minute); !errors.Is(err, xwebdav.ErrNoSuchLock) {
+		t.Error("expected the expired lock to be gone", err)
+	}
+}
+
+func TestConcurrentWritersDontContendForLocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+	ls := webdav.NewLockSystem(&fsys)
+	handler := webdav.NewHandler(&fsys, ls)
+	storage.reset()
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 8)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("/file%d", i)
+			if i%2 == 0 {
+				name = "/shared"
+			}
+			req := httptest.NewRequest(http.MethodPut, name, strings.NewReader("data"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			statuses[i] = rec.Code
+		}()
+	}
+	wg.Wait()
+
+	for i, status := range statuses {
+		if status != http.StatusCreated && !(i%2 == 0 && (status == http.StatusNoContent || status == http.StatusLocked)) {
+			t.Error("unexpected status for writer", i, status)
+		}
+	}
+	storage.mux.Lock()
+	pushedLocks := slices.Contains(storage.pushed, "locks")
+	readEveryReplica := slices.Contains(storage.latest, "locks")
+	storage.mux.Unlock()
+	if pushedLocks {
+		t.Error("expected the locks of single requests to stay off the cluster's locks")
+	}
+	if readEveryReplica {
+		t.Error("expected single requests to check the locks with a quorum read")
+	}
+
+	// every temporary lock was released
+	_, err := ls.Create(time.Now(), xwebdav.LockDetails{Root: "/", Duration: time.Minute})
+	if err != nil {
+		t.Error("error locking everything", err)
+	}
+}
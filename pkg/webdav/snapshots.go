@@ -0,0 +1,280 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// snapshotsDir is the read only directory at the root where every snapshot
+// can be browsed by name
+const snapshotsDir pathSeg = ".snapshots"
+
+// snapshotsBlockId names the block listing the snapshots of the cluster
+const snapshotsBlockId model.BlockId = "snapshots"
+
+var snapshotsFormat = []byte{0xff, 's', 'n', 'p'}
+
+// Snapshot is a frozen copy of the directories below Path. Files share their
+// chunks with the live tree, which never rewrites a chunk in place once it's
+// recorded in a directory. Each directory is copied as it was when it was
+// read, not all at one point in time, so a snapshot taken while files are
+// being written can hold some directories from before a change and some
+// from after it.
+type Snapshot struct {
+	Name    string
+	Path    string
+	Created time.Time
+	root    model.BlockId
+}
+
+func snapshotsToBytes(snapshots []Snapshot) []byte {
+	result := append([]byte{}, snapshotsFormat...)
+	for _, s := range snapshots {
+		result = append(result, model.StringToBytes(s.Name)...)
+		result = append(result, model.StringToBytes(s.Path)...)
+		result = append(result, timeToBytes(s.Created)...)
+		result = append(result, model.StringToBytes(string(s.root))...)
+	}
+	return result
+}
+
+func snapshotsFromBytes(raw []byte) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	if len(raw) == 0 {
+		return snapshots, nil
+	}
+	if !bytes.HasPrefix(raw, snapshotsFormat) {
+		return nil, errors.New("not a snapshot list")
+	}
+	remainder := raw[len(snapshotsFormat):]
+	for len(remainder) > 0 {
+		var s Snapshot
+		var root string
+		s.Name, remainder = model.StringFromBytes(remainder)
+		s.Path, remainder = model.StringFromBytes(remainder)
+		s.Created, remainder = timeFromBytes(remainder)
+		root, remainder = model.StringFromBytes(remainder)
+		s.root = model.BlockId(root)
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, nil
+}
+
+func isSnapshotPath(p Path) bool {
+	return len(p) > 0 && p[0] == snapshotsDir
+}
+
+// fetchSnapshots reads the snapshot list with a read quorum, only changing
+// it needs every replica
+func (f *FileSystem) fetchSnapshots(ctx context.Context) ([]Snapshot, error) {
+	result := f.fetchBlock(ctx, snapshotsBlockId)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return snapshotsFromBytes(result.Block.Data)
+}
+
+func (f *FileSystem) updateSnapshots(ctx context.Context, change func([]Snapshot) ([]Snapshot, error)) error {
+	return f.updateBlock(ctx, snapshotsBlockId, func(data []byte) ([]byte, error) {
+		snapshots, err := snapshotsFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		snapshots, err = change(snapshots)
+		if err != nil {
+			return nil, err
+		}
+		return snapshotsToBytes(snapshots), nil
+	})
+}
+
+func (f *FileSystem) createSnapshot(ctx context.Context, name string, dirName string) error {
+	if _, err := newPathSeg(name); err != nil {
+		return err
+	}
+	p, err := PathFromName(dirName)
+	if err != nil {
+		return err
+	}
+	if isSnapshotPath(p) {
+		return fs.ErrPermission
+	}
+	snapshots, err := f.fetchSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s.Name == name {
+			return fs.ErrExist
+		}
+	}
+	err = f.loadParents(ctx, p)
+	if err != nil {
+		return err
+	}
+	dir, exists := f.fileHolder.Get(p)
+	if !exists || !dir.IsDir() {
+		return fs.ErrNotExist
+	}
+
+	root, err := f.copyTree(ctx, dir)
+	if err != nil {
+		return err
+	}
+	snapshot := Snapshot{Name: name, Path: "/" + string(p.toName()), Created: time.Now(), root: root}
+	return f.updateSnapshots(ctx, func(snapshots []Snapshot) ([]Snapshot, error) {
+		for _, s := range snapshots {
+			if s.Name == name {
+				return nil, fs.ErrExist
+			}
+		}
+		return append(snapshots, snapshot), nil
+	})
+}
+
+// copyTree writes a copy of the listing of dir and every directory below it,
+// each directory as it was when it was read, and returns the id of the copy.
+// Directories aren't frozen while the copy is made, see Snapshot.
+func (f *FileSystem) copyTree(ctx context.Context, dir *File) (model.BlockId, error) {
+	children, _, err := f.fetchDir(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	copies := make([]*File, 0, len(children))
+	for _, child := range children {
+		frozen := *child
+		if child.IsDir() {
+			frozen.Block.Id, err = f.copyTree(ctx, child)
+			if err != nil {
+				return "", err
+			}
+		}
+		copies = append(copies, &frozen)
+	}
+	id := model.NewBlockId()
+	result := f.pushBlock(ctx, model.Block{Id: id, Data: dirToBytes(copies)})
+	return id, result.Err
+}
+
+// deleteSnapshot only forgets the snapshot, the blocks of its copy stay
+func (f *FileSystem) deleteSnapshot(ctx context.Context, name string) error {
+	return f.updateSnapshots(ctx, func(snapshots []Snapshot) ([]Snapshot, error) {
+		for i, s := range snapshots {
+			if s.Name == name {
+				return append(snapshots[:i], snapshots[i+1:]...), nil
+			}
+		}
+		return nil, fs.ErrNotExist
+	})
+}
+
+// openSnapshotPath finds a file below /.snapshots, walking the frozen
+// listings from the root of the snapshot
+func (f *FileSystem) openSnapshotPath(ctx context.Context, p Path) (*File, error) {
+	snapshots, err := f.fetchSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 1 {
+		listing := make([]*File, 0, len(snapshots))
+		for _, s := range snapshots {
+			listing = append(listing, f.snapshotDir(Path{snapshotsDir, pathSeg(s.Name)}, s.root, s.Created))
+		}
+		dir := f.snapshotDir(p, "", time.Time{})
+		dir.listing = listing
+		return dir, nil
+	}
+
+	var file *File
+	for _, s := range snapshots {
+		if s.Name == string(p[1]) {
+			file = f.snapshotDir(p[:2], s.root, s.Created)
+		}
+	}
+	if file == nil {
+		return nil, fs.ErrNotExist
+	}
+	for _, name := range p[2:] {
+		if !file.IsDir() {
+			return nil, fs.ErrNotExist
+		}
+		children, err := f.snapshotListing(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		file = childNamed(children, string(name))
+		if file == nil {
+			return nil, fs.ErrNotExist
+		}
+	}
+	if file.IsDir() {
+		file.listing, err = f.snapshotListing(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func (f *FileSystem) snapshotListing(ctx context.Context, dir *File) ([]*File, error) {
+	children, _, err := f.fetchDir(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range children {
+		child.readOnly = true
+	}
+	return children, nil
+}
+
+func (f *FileSystem) snapshotDir(p Path, root model.BlockId, created time.Time) *File {
+	return &File{
+		ModeValue:  fs.ModeDir | 0555,
+		Modtime:    created,
+		Ctime:      created,
+		Birthtime:  created,
+		Block:      model.Block{Id: root, Data: []byte{}},
+		Path:       append(Path{}, p...),
+		FileSystem: f,
+		readOnly:   true,
+	}
+}
+
+// CreateSnapshot freezes the directories below dirName under name. It isn't
+// atomic, writes made while it runs may or may not be in the snapshot.
+func (f *FileSystem) CreateSnapshot(ctx context.Context, name string, dirName string) error {
+	var err error
+	f.exclusively(func() { err = f.createSnapshot(ctx, name, dirName) })
+	return err
+}
+
+func (f *FileSystem) DeleteSnapshot(ctx context.Context, name string) error {
+	var err error
+	f.exclusively(func() { err = f.deleteSnapshot(ctx, name) })
+	return err
+}
+
+func (f *FileSystem) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	var err error
+	f.exclusively(func() { snapshots, err = f.fetchSnapshots(ctx) })
+	return snapshots, err
+}
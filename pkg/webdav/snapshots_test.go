@@ -0,0 +1,222 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"tealfs/pkg/model"
+	"tealfs/pkg/webdav"
+	"testing"
+)
+
+func TestSnapshotKeepsOldContents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fsys)
+
+	err := fsys.Mkdir(ctx, "/docs", os.ModeDir)
+	if err != nil {
+		t.Error("error creating dir", err)
+		return
+	}
+	writeFile(t, &fsys, "/docs/a", "one two", 0)
+	writeFile(t, &fsys, "/docs/b", "keep", 0)
+
+	err = fsys.CreateSnapshot(ctx, "before", "/")
+	if err != nil {
+		t.Error("error creating snapshot", err)
+		return
+	}
+
+	writeFile(t, &fsys, "/docs/a", "ONE", 0)
+	err = fsys.RemoveAll(ctx, "/docs/b")
+	if err != nil {
+		t.Error("error removing", err)
+		return
+	}
+	writeFile(t, &fsys, "/docs/c", "new", 0)
+
+	fs2 := webdav.NewFileSystemWithChunkSize(model.NewNodeId(), 4)
+	storage.serve(ctx, &fs2)
+	if contents := readFile(t, &fs2, "/docs/a"); contents != "ONE two" {
+		t.Error("wrong live contents", contents)
+	}
+	if contents := readFile(t, &fs2, "/.snapshots/before/docs/a"); contents != "one two" {
+		t.Error("wrong snapshot contents", contents)
+	}
+	if contents := readFile(t, &fs2, "/.snapshots/before/docs/b"); contents != "keep" {
+		t.Error("wrong snapshot contents", contents)
+	}
+	_, err = fs2.Stat(ctx, "/.snapshots/before/docs/c")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected file created later to be missing from the snapshot", err)
+	}
+
+	names := readdirNames(t, &fs2, "/.snapshots")
+	if len(names) != 1 || names[0] != "before" {
+		t.Error("wrong snapshots listed", names)
+	}
+	names = readdirNames(t, &fs2, "/.snapshots/before/docs")
+	if len(names) != 2 {
+		t.Error("wrong snapshot listing", names)
+	}
+	for _, name := range readdirNames(t, &fs2, "/") {
+		if name == ".snapshots" {
+			t.Error("expected snapshots to be hidden from the root listing")
+		}
+	}
+}
+
+func TestSnapshotIsReadOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/file", "data", 0)
+	err := fsys.CreateSnapshot(ctx, "s", "/")
+	if err != nil {
+		t.Error("error creating snapshot", err)
+		return
+	}
+
+	_, err = fsys.OpenFile(ctx, "/.snapshots/s/file", os.O_RDWR, 0)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("expected opening for write to be refused", err)
+	}
+	_, err = fsys.OpenFile(ctx, "/.snapshots/s/other", os.O_RDWR|os.O_CREATE, 0666)
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Error("expected create to be refused", err)
+	}
+	if err = fsys.Mkdir(ctx, "/.snapshots/s/dir", os.ModeDir); !errors.Is(err, fs.ErrPermission) {
+		t.Error("expected mkdir to be refused", err)
+	}
+	if err = fsys.RemoveAll(ctx, "/.snapshots/s/file"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("expected remove to be refused", err)
+	}
+	if err = fsys.Rename(ctx, "/file", "/.snapshots/s/file"); !errors.Is(err, fs.ErrPermission) {
+		t.Error("expected rename into the snapshot to be refused", err)
+	}
+
+	f, err := fsys.OpenFile(ctx, "/.snapshots/s/file", os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening snapshot file", err)
+		return
+	}
+	if _, err = f.Write([]byte("x")); !errors.Is(err, fs.ErrPermission) {
+		t.Error("expected write to be refused", err)
+	}
+}
+
+func TestSubtreeSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	err := fsys.Mkdir(ctx, "/docs", os.ModeDir)
+	if err != nil {
+		t.Error("error creating dir", err)
+		return
+	}
+	writeFile(t, &fsys, "/docs/a", "a", 0)
+	writeFile(t, &fsys, "/outside", "b", 0)
+
+	err = fsys.CreateSnapshot(ctx, "docs", "/docs")
+	if err != nil {
+		t.Error("error creating snapshot", err)
+		return
+	}
+	if err = fsys.CreateSnapshot(ctx, "docs", "/"); !errors.Is(err, fs.ErrExist) {
+		t.Error("expected snapshot names to be unique", err)
+	}
+	if err = fsys.CreateSnapshot(ctx, "file", "/outside"); err == nil {
+		t.Error("expected snapshot of a file to be refused")
+	}
+
+	names := readdirNames(t, &fsys, "/.snapshots/docs")
+	if len(names) != 1 || names[0] != "a" {
+		t.Error("wrong snapshot listing", names)
+	}
+	snapshots, err := fsys.Snapshots(ctx)
+	if err != nil || len(snapshots) != 1 || snapshots[0].Path != "/docs" {
+		t.Error("wrong snapshots", snapshots, err)
+	}
+
+	err = fsys.DeleteSnapshot(ctx, "docs")
+	if err != nil {
+		t.Error("error deleting snapshot", err)
+		return
+	}
+	_, err = fsys.Stat(ctx, "/.snapshots/docs")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected deleted snapshot to be gone", err)
+	}
+}
+
+func writeFile(t *testing.T, fsys *webdav.FileSystem, name string, contents string, offset int64) {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Error("error opening file", name, err)
+		return
+	}
+	_, _ = f.Seek(offset, io.SeekStart)
+	_, err = f.Write([]byte(contents))
+	if err != nil {
+		t.Error("error writing file", name, err)
+	}
+	if err = f.Close(); err != nil {
+		t.Error("error closing file", name, err)
+	}
+}
+
+func readFile(t *testing.T, fsys *webdav.FileSystem, name string) string {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening file", name, err)
+		return ""
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Error("error reading file", name, err)
+	}
+	return string(data)
+}
+
+func readdirNames(t *testing.T, fsys *webdav.FileSystem, name string) []string {
+	f, err := fsys.OpenFile(context.Background(), name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Error("error opening dir", name, err)
+		return nil
+	}
+	infos, err := f.Readdir(0)
+	if err != nil {
+		t.Error("error reading dir", name, err)
+	}
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
@@ -0,0 +1,256 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"tealfs/pkg/model"
+	"time"
+)
+
+// defaultTrashRetention is how long deleted files can be restored
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPurgeInterval is how often expired files are purged from the trash
+const trashPurgeInterval = time.Hour
+
+// trashBlockId names the block listing what was deleted across the cluster
+const trashBlockId model.BlockId = "trash"
+
+var trashFormat = []byte{0xff, 't', 'r', 's'}
+
+// TrashedFile is a deleted file or directory, which keeps its blocks until
+// it's restored or purged. Purging only drops the entry, the cluster has no
+// way to delete blocks yet, so the space they take isn't reclaimed.
+type TrashedFile struct {
+	Id      string
+	Path    string
+	Deleted time.Time
+	IsDir   bool
+	Size    int64
+	record  []byte
+}
+
+func trashToBytes(trash []TrashedFile) []byte {
+	result := append([]byte{}, trashFormat...)
+	for _, t := range trash {
+		result = append(result, model.StringToBytes(t.Id)...)
+		result = append(result, model.StringToBytes(t.Path)...)
+		result = append(result, timeToBytes(t.Deleted)...)
+		result = append(result, model.BytesToBytes(t.record)...)
+	}
+	return result
+}
+
+func trashFromBytes(raw []byte) ([]TrashedFile, error) {
+	trash := []TrashedFile{}
+	if len(raw) == 0 {
+		return trash, nil
+	}
+	if !bytes.HasPrefix(raw, trashFormat) {
+		return nil, errors.New("not a trash listing")
+	}
+	remainder := raw[len(trashFormat):]
+	for len(remainder) > 0 {
+		var t TrashedFile
+		t.Id, remainder = model.StringFromBytes(remainder)
+		t.Path, remainder = model.StringFromBytes(remainder)
+		t.Deleted, remainder = timeFromBytes(remainder)
+		t.record, remainder = model.BytesFromBytes(remainder)
+		file, _, _, err := recordFromBytes(t.record, nil)
+		if err != nil {
+			return nil, err
+		}
+		t.IsDir = file.IsDir()
+		t.Size = file.SizeValue
+		trash = append(trash, t)
+	}
+	return trash, nil
+}
+
+// SetTrashRetention is how long deleted files are kept before they're purged
+func (f *FileSystem) SetTrashRetention(retention time.Duration) {
+	f.exclusively(func() { f.retention.trash = retention })
+}
+
+// unexpired drops what has been in the trash longer than the retention
+func (f *FileSystem) unexpired(trash []TrashedFile, now time.Time) []TrashedFile {
+	result := []TrashedFile{}
+	for _, t := range trash {
+		if now.Sub(t.Deleted) < f.retention.trash {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func (f *FileSystem) fetchTrash(ctx context.Context) ([]TrashedFile, error) {
+	result := f.fetchLatestBlock(ctx, trashBlockId)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	trash, err := trashFromBytes(result.Block.Data)
+	if err != nil {
+		return nil, err
+	}
+	return f.unexpired(trash, time.Now()), nil
+}
+
+// updateTrash applies change to the trash, purging whatever expired on the way
+func (f *FileSystem) updateTrash(ctx context.Context, change func([]TrashedFile) ([]TrashedFile, error)) error {
+	return f.updateBlock(ctx, trashBlockId, func(data []byte) ([]byte, error) {
+		trash, err := trashFromBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		trash, err = change(f.unexpired(trash, time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		return trashToBytes(trash), nil
+	})
+}
+
+// moveToTrash records files as deleted, before they're taken out of their
+// directories. A delete doesn't wait on the trash, files that can't be
+// recorded are deleted without a way back.
+func (f *FileSystem) moveToTrash(ctx context.Context, files []*File) {
+	if len(files) == 0 {
+		return
+	}
+	now := time.Now()
+	err := f.updateTrash(ctx, func(trash []TrashedFile) ([]TrashedFile, error) {
+		for _, file := range files {
+			trash = append(trash, TrashedFile{
+				Id:      string(model.NewBlockId()),
+				Path:    "/" + string(file.Path.toName()),
+				Deleted: now,
+				record:  file.recordBytes(file.Name()),
+			})
+		}
+		return trash, nil
+	})
+	if err != nil {
+		fmt.Println("deleting", len(files), "files without keeping them in the trash:", err)
+	}
+}
+
+// restoreFromTrash puts a deleted file back where it was, which its
+// directory has to still exist for
+func (f *FileSystem) restoreFromTrash(ctx context.Context, id string) error {
+	trash, err := f.fetchTrash(ctx)
+	if err != nil {
+		return err
+	}
+	var trashed *TrashedFile
+	for i := range trash {
+		if trash[i].Id == id {
+			trashed = &trash[i]
+		}
+	}
+	if trashed == nil {
+		return fs.ErrNotExist
+	}
+
+	p, err := PathFromName(trashed.Path)
+	if err != nil {
+		return err
+	}
+	err = f.loadParents(ctx, p)
+	if err != nil {
+		return err
+	}
+	parent, err := f.parentOf(p)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+	file, _, _, err := recordFromBytes(trashed.record, f)
+	if err != nil {
+		return err
+	}
+	file.Path = p
+	err = f.updateDir(ctx, parent, func(children []*File) ([]*File, error) {
+		if childNamed(children, file.Name()) != nil {
+			return nil, fs.ErrExist
+		}
+		return withChild(children, file), nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.updateTrash(ctx, func(trash []TrashedFile) ([]TrashedFile, error) {
+		return withoutTrashed(trash, id), nil
+	})
+}
+
+func withoutTrashed(trash []TrashedFile, id string) []TrashedFile {
+	result := make([]TrashedFile, 0, len(trash))
+	for _, t := range trash {
+		if t.Id != id {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func (f *FileSystem) Trash(ctx context.Context) ([]TrashedFile, error) {
+	var trash []TrashedFile
+	var err error
+	f.exclusively(func() { trash, err = f.fetchTrash(ctx) })
+	return trash, err
+}
+
+func (f *FileSystem) RestoreFromTrash(ctx context.Context, id string) error {
+	var err error
+	f.exclusively(func() { err = f.restoreFromTrash(ctx, id) })
+	return err
+}
+
+// PurgeTrash drops what has been in the trash longer than the retention.
+// Every node purges, so the trash is only rewritten when something expired.
+func (f *FileSystem) PurgeTrash(ctx context.Context) error {
+	var err error
+	f.exclusively(func() {
+		result := f.fetchLatestBlock(ctx, trashBlockId)
+		if result.Err != nil {
+			err = result.Err
+			return
+		}
+		var trash []TrashedFile
+		trash, err = trashFromBytes(result.Block.Data)
+		if err != nil || len(f.unexpired(trash, time.Now())) == len(trash) {
+			return
+		}
+		err = f.updateTrash(ctx, func(trash []TrashedFile) ([]TrashedFile, error) {
+			return trash, nil
+		})
+	})
+	return err
+}
+
+// EmptyTrash purges everything deleted so far
+func (f *FileSystem) EmptyTrash(ctx context.Context) error {
+	var err error
+	f.exclusively(func() {
+		err = f.updateTrash(ctx, func([]TrashedFile) ([]TrashedFile, error) {
+			return []TrashedFile{}, nil
+		})
+	})
+	return err
+}
@@ -0,0 +1,214 @@
+// Copyright (C) 2025 Adam Hess
+//
+// This program is free software: you can redistribute it and/or modify it under
+// the terms of the GNU Affero General Public License as published by the Free
+// Software Foundation, version 3.
+//
+// This program is distributed in the hope that it will be useful, but WITHOUT
+// ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+// FITNESS FOR A PARTICULAR PURPOSE. See the GNU Affero General Public License
+// for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package webdav_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"tealfs/pkg/model"
+	"tealfs/pkg/webdav"
+	"testing"
+	"time"
+)
+
+func TestRemovedFilesGoToTrash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	err := fsys.Mkdir(ctx, "/dir", os.ModeDir)
+	if err != nil {
+		t.Error("error creating dir", err)
+		return
+	}
+	writeFile(t, &fsys, "/dir/a", "apple", 0)
+	err = fsys.RemoveAll(ctx, "/dir")
+	if err != nil {
+		t.Error("error removing dir", err)
+		return
+	}
+	if _, err = fsys.Stat(ctx, "/dir/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected the dir to be gone", err)
+	}
+
+	fs2 := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fs2)
+	trash, err := fs2.Trash(ctx)
+	if err != nil || len(trash) != 1 {
+		t.Error("expected the dir in the trash", trash, err)
+		return
+	}
+	if trash[0].Path != "/dir" || !trash[0].IsDir || trash[0].Deleted.IsZero() {
+		t.Error("wrong trash entry", trash[0])
+	}
+
+	err = fs2.RestoreFromTrash(ctx, trash[0].Id)
+	if err != nil {
+		t.Error("error restoring", err)
+		return
+	}
+	if contents := readFile(t, &fsys, "/dir/a"); contents != "apple" {
+		t.Error("wrong restored contents", contents)
+	}
+	trash, err = fsys.Trash(ctx)
+	if err != nil || len(trash) != 0 {
+		t.Error("expected the trash to be empty after restoring", trash, err)
+	}
+}
+
+func TestRemoveWithTrashUnreadable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/x", "x", 0)
+	// a replica is down and nothing was ever trashed
+	storage.mux.Lock()
+	storage.unreadable["trash"] = true
+	storage.mux.Unlock()
+
+	err := fsys.RemoveAll(ctx, "/x")
+	if err != nil {
+		t.Error("expected the delete to go through", err)
+		return
+	}
+	if _, err = fsys.Stat(ctx, "/x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected the file to be gone", err)
+	}
+}
+
+func TestRenameOverFileTrashesIt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/draft", "new", 0)
+	writeFile(t, &fsys, "/report", "old", 0)
+	err := fsys.Rename(ctx, "/draft", "/report")
+	if err != nil {
+		t.Error("error renaming", err)
+		return
+	}
+	if contents := readFile(t, &fsys, "/report"); contents != "new" {
+		t.Error("wrong contents after rename", contents)
+	}
+
+	trash, err := fsys.Trash(ctx)
+	if err != nil || len(trash) != 1 || trash[0].Path != "/report" {
+		t.Error("expected the replaced file in the trash", trash, err)
+		return
+	}
+	_ = fsys.RemoveAll(ctx, "/report")
+	err = fsys.RestoreFromTrash(ctx, trash[0].Id)
+	if err != nil {
+		t.Error("error restoring", err)
+		return
+	}
+	if contents := readFile(t, &fsys, "/report"); contents != "old" {
+		t.Error("expected the replaced contents back", contents)
+	}
+}
+
+func TestRestoreDoesNotReplace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/x", "old", 0)
+	_ = fsys.RemoveAll(ctx, "/x")
+	writeFile(t, &fsys, "/x", "new", 0)
+
+	trash, _ := fsys.Trash(ctx)
+	if len(trash) != 1 {
+		t.Error("expected one file in the trash", trash)
+		return
+	}
+	err := fsys.RestoreFromTrash(ctx, trash[0].Id)
+	if !errors.Is(err, fs.ErrExist) {
+		t.Error("expected restore over an existing file to be refused", err)
+	}
+	if contents := readFile(t, &fsys, "/x"); contents != "new" {
+		t.Error("wrong contents", contents)
+	}
+}
+
+func TestTrashIsPurged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := newVersionedStorage()
+	fsys := webdav.NewFileSystem(model.NewNodeId())
+	storage.serve(ctx, &fsys)
+
+	writeFile(t, &fsys, "/a", "a", 0)
+	writeFile(t, &fsys, "/b", "b", 0)
+	err := fsys.RemoveAll(ctx, "/")
+	if err != nil {
+		t.Error("error emptying the root", err)
+		return
+	}
+	trash, _ := fsys.Trash(ctx)
+	if len(trash) != 2 {
+		t.Error("expected everything in the root in the trash", trash)
+		return
+	}
+	err = fsys.EmptyTrash(ctx)
+	if err != nil {
+		t.Error("error emptying trash", err)
+		return
+	}
+	trash, _ = fsys.Trash(ctx)
+	if len(trash) != 0 {
+		t.Error("expected the trash to be empty", trash)
+	}
+
+	fsys.SetTrashRetention(time.Millisecond)
+	writeFile(t, &fsys, "/c", "c", 0)
+	_ = fsys.RemoveAll(ctx, "/c")
+	time.Sleep(5 * time.Millisecond)
+	err = fsys.PurgeTrash(ctx)
+	if err != nil {
+		t.Error("error purging", err)
+		return
+	}
+	trash, _ = fsys.Trash(ctx)
+	if len(trash) != 0 {
+		t.Error("expected expired files to be purged", trash)
+	}
+
+	// nothing left to expire
+	storage.reset()
+	err = fsys.PurgeTrash(ctx)
+	if err != nil {
+		t.Error("error purging", err)
+		return
+	}
+	storage.mux.Lock()
+	rewritten := slices.Contains(storage.pushed, "trash")
+	storage.mux.Unlock()
+	if rewritten {
+		t.Error("expected a purge with nothing expired to leave the trash alone")
+	}
+}
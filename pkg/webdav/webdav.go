@@ -18,32 +18,34 @@ import (
 	"context"
 	"net/http"
 	"tealfs/pkg/model"
-
-	"golang.org/x/net/webdav"
+	"time"
 )
 
 type Webdav struct {
-	webdavMgrGets      chan model.BlockId
-	webdavMgrPuts      chan model.Block
+	webdavMgrGets      chan model.GetBlockReq
+	webdavMgrPuts      chan model.PutBlockReq
 	mgrWebdavGets      chan model.BlockResponse
 	mgrWebdavPuts      chan model.BlockIdResponse
 	mgrWebdavIsPrimary chan bool
 	fileSystem         FileSystem
 	nodeId             model.NodeId
-	pendingReads       map[model.BlockId]chan model.BlockResponse
-	pendingPuts        map[model.BlockId]chan model.BlockIdResponse
-	lockSystem         webdav.LockSystem
+	pendingReads       map[model.ReqId][]chan model.BlockResponse
+	inflightReads      map[model.BlockId]model.ReqId
+	pendingPuts        map[model.ReqId]chan model.BlockIdResponse
+	lockSystem         *LockSystem
 	bindAddress        string
+	adminPassword      string
 	server             *http.Server
 }
 
 func New(
 	nodeId model.NodeId,
-	webdavMgrGets chan model.BlockId,
-	webdavMgrPuts chan model.Block,
+	webdavMgrGets chan model.GetBlockReq,
+	webdavMgrPuts chan model.PutBlockReq,
 	mgrWebdavGets chan model.BlockResponse,
 	mgrWebdavPuts chan model.BlockIdResponse,
 	bindAddress string,
+	adminPassword string,
 	ctx context.Context,
 ) Webdav {
 	w := Webdav{
@@ -53,11 +55,13 @@ func New(
 		mgrWebdavPuts: mgrWebdavPuts,
 		fileSystem:    NewFileSystem(nodeId),
 		nodeId:        nodeId,
-		pendingReads:  make(map[model.BlockId]chan model.BlockResponse),
-		pendingPuts:   make(map[model.BlockId]chan model.BlockIdResponse),
-		lockSystem:    webdav.NewMemLS(),
+		pendingReads:  make(map[model.ReqId][]chan model.BlockResponse),
+		inflightReads: make(map[model.BlockId]model.ReqId),
+		pendingPuts:   make(map[model.ReqId]chan model.BlockIdResponse),
 		bindAddress:   bindAddress,
+		adminPassword: adminPassword,
 	}
+	w.lockSystem = NewLockSystem(&w.fileSystem)
 	w.start(ctx)
 	return w
 }
@@ -65,19 +69,28 @@ func New(
 func (w *Webdav) start(ctx context.Context) {
 	go w.eventLoop(ctx)
 
-	handler := &webdav.Handler{
-		Prefix:     "/",
-		FileSystem: &w.fileSystem,
-		LockSystem: w.lockSystem,
-	}
-
 	mux := http.NewServeMux()
-	mux.Handle("/", handler)
+	mux.Handle("/", NewHandler(&w.fileSystem, w.lockSystem))
+	w.handleAdmin(mux)
 	w.server = &http.Server{
 		Addr:    w.bindAddress,
 		Handler: mux,
 	}
 	go w.server.ListenAndServe()
+	go w.purgeTrash(ctx)
+}
+
+func (w *Webdav) purgeTrash(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = w.fileSystem.PurgeTrash(ctx)
+		}
+	}
 }
 
 func (w *Webdav) eventLoop(ctx context.Context) {
@@ -85,24 +98,39 @@ func (w *Webdav) eventLoop(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			w.server.Shutdown(context.Background())
+			return
 		case r := <-w.mgrWebdavGets:
-			ch, ok := w.pendingReads[r.Block.Id]
-			if ok {
+			for _, ch := range w.pendingReads[r.ReqId] {
 				ch <- r
-				delete(w.pendingReads, r.Block.Id)
+			}
+			delete(w.pendingReads, r.ReqId)
+			if w.inflightReads[r.Block.Id] == r.ReqId {
+				delete(w.inflightReads, r.Block.Id)
 			}
 		case r := <-w.mgrWebdavPuts:
-			ch, ok := w.pendingPuts[r.BlockId]
+			ch, ok := w.pendingPuts[r.ReqId]
 			if ok {
 				ch <- r
-				delete(w.pendingPuts, r.BlockId)
+				delete(w.pendingPuts, r.ReqId)
 			}
+			// reads that start after the write should not share an older fetch
+			delete(w.inflightReads, r.BlockId)
 		case r := <-w.fileSystem.ReadReqResp:
-			w.webdavMgrGets <- r.Req
-			w.pendingReads[r.Req] = r.Resp
+			// a read of the latest version can't settle for one already in flight
+			if reqId, ok := w.inflightReads[r.Req]; ok && !r.Latest {
+				w.pendingReads[reqId] = append(w.pendingReads[reqId], r.Resp)
+				continue
+			}
+			reqId := model.NewReqId()
+			if !r.Latest {
+				w.inflightReads[r.Req] = reqId
+			}
+			w.pendingReads[reqId] = []chan model.BlockResponse{r.Resp}
+			w.webdavMgrGets <- model.GetBlockReq{ReqId: reqId, BlockId: r.Req, Latest: r.Latest}
 		case r := <-w.fileSystem.WriteReqResp:
-			w.webdavMgrPuts <- r.Req
-			w.pendingPuts[r.Req.Id] = r.Resp
+			reqId := model.NewReqId()
+			w.pendingPuts[reqId] = r.Resp
+			w.webdavMgrPuts <- model.PutBlockReq{ReqId: reqId, Block: r.Req}
 		}
 	}
 }
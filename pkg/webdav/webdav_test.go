@@ -17,9 +17,12 @@ package webdav_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"tealfs/pkg/model"
 	"tealfs/pkg/webdav"
@@ -29,8 +32,8 @@ import (
 
 func TestCreateFile(t *testing.T) {
 	nodeId := model.NewNodeId()
-	webdavMgrGets := make(chan model.BlockId)
-	webdavMgrPuts := make(chan model.Block)
+	webdavMgrGets := make(chan model.GetBlockReq)
+	webdavMgrPuts := make(chan model.PutBlockReq)
 	mgrWebdavGets := make(chan model.BlockResponse)
 	mgrWebdavPuts := make(chan model.BlockIdResponse)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -41,7 +44,7 @@ func TestCreateFile(t *testing.T) {
 	go handleWebdavMgrGets(ctx, webdavMgrGets, mgrWebdavGets, &mux, mockStorage)
 	go handleWebdavMgrPuts(ctx, webdavMgrPuts, mgrWebdavPuts, &mux, mockStorage)
 
-	_ = webdav.New(nodeId, webdavMgrGets, webdavMgrPuts, mgrWebdavGets, mgrWebdavPuts, "localhost:7654", ctx)
+	_ = webdav.New(nodeId, webdavMgrGets, webdavMgrPuts, mgrWebdavGets, mgrWebdavPuts, "localhost:7654", "secret", ctx)
 	time.Sleep(1 * time.Second) //FIXME, need a better way to wait for listener to start
 
 	_, err := propFind("http://localhost:7654/")
@@ -90,6 +93,185 @@ func TestCreateFile(t *testing.T) {
 	}
 }
 
+func TestFileVersionsOverHttp(t *testing.T) {
+	nodeId := model.NewNodeId()
+	webdavMgrGets := make(chan model.GetBlockReq)
+	webdavMgrPuts := make(chan model.PutBlockReq)
+	mgrWebdavGets := make(chan model.BlockResponse)
+	mgrWebdavPuts := make(chan model.BlockIdResponse)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mux := sync.Mutex{}
+	mockStorage := make(map[model.BlockId][]byte)
+	go handleWebdavMgrGets(ctx, webdavMgrGets, mgrWebdavGets, &mux, mockStorage)
+	go handleWebdavMgrPuts(ctx, webdavMgrPuts, mgrWebdavPuts, &mux, mockStorage)
+
+	_ = webdav.New(nodeId, webdavMgrGets, webdavMgrPuts, mgrWebdavGets, mgrWebdavPuts, "localhost:7655", "secret", ctx)
+	time.Sleep(1 * time.Second) //FIXME, need a better way to wait for listener to start
+
+	url := "http://localhost:7655/report.txt"
+	client := &http.Client{}
+	for _, content := range []string{"draft", "final"} {
+		req, _ := http.NewRequest("PUT", url, bytes.NewBufferString(content))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error("error putting report", err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	versionsUrl := "http://localhost:7655/.tealfs/versions?path=/report.txt"
+	resp, err := http.Get(versionsUrl)
+	if err != nil {
+		t.Error("error listing versions", err)
+		return
+	}
+	versions := []webdav.FileVersion{}
+	err = json.NewDecoder(resp.Body).Decode(&versions)
+	resp.Body.Close()
+	if err != nil || len(versions) != 1 {
+		t.Error("expected one old version", versions, err)
+		return
+	}
+
+	resp, err = http.Get(versionsUrl + "&version=" + strconv.Itoa(versions[0].Number))
+	if err != nil {
+		t.Error("error getting version", err)
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "draft" {
+		t.Error("wrong version contents", string(body))
+		return
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, versionsUrl+"&version="+strconv.Itoa(versions[0].Number), nil)
+	req.SetBasicAuth("admin", "secret")
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Error("error restoring version", err)
+		return
+	}
+	resp.Body.Close()
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Error("error getting report", err)
+		return
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "draft" {
+		t.Error("expected the old version back", string(body))
+	}
+}
+
+func TestAdminEndpointsNeedPassword(t *testing.T) {
+	nodeId := model.NewNodeId()
+	webdavMgrGets := make(chan model.GetBlockReq)
+	webdavMgrPuts := make(chan model.PutBlockReq)
+	mgrWebdavGets := make(chan model.BlockResponse)
+	mgrWebdavPuts := make(chan model.BlockIdResponse)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mux := sync.Mutex{}
+	mockStorage := make(map[model.BlockId][]byte)
+	go handleWebdavMgrGets(ctx, webdavMgrGets, mgrWebdavGets, &mux, mockStorage)
+	go handleWebdavMgrPuts(ctx, webdavMgrPuts, mgrWebdavPuts, &mux, mockStorage)
+
+	_ = webdav.New(nodeId, webdavMgrGets, webdavMgrPuts, mgrWebdavGets, mgrWebdavPuts, "localhost:7657", "secret", ctx)
+	time.Sleep(1 * time.Second) //FIXME, need a better way to wait for listener to start
+
+	client := &http.Client{}
+	requests := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodPost, "http://localhost:7657/.tealfs/snapshots?name=daily"},
+		{http.MethodDelete, "http://localhost:7657/.tealfs/snapshots?name=daily"},
+		{http.MethodPost, "http://localhost:7657/.tealfs/versions?path=/report.txt&version=1"},
+		{http.MethodPost, "http://localhost:7657/.tealfs/trash?id=1"},
+		{http.MethodDelete, "http://localhost:7657/.tealfs/trash"},
+	}
+	for _, r := range requests {
+		for _, password := range []string{"", "wrong"} {
+			req, _ := http.NewRequest(r.method, r.url, nil)
+			if password != "" {
+				req.SetBasicAuth("admin", password)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error("error sending", r.method, r.url, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Error("expected", r.method, r.url, "to need the admin password, got", resp.StatusCode)
+			}
+		}
+	}
+
+	resp, err := http.Get("http://localhost:7657/.tealfs/trash")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Error("expected the trash to be listed without a password", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func TestPropPatchShowsInPropFind(t *testing.T) {
+	nodeId := model.NewNodeId()
+	webdavMgrGets := make(chan model.GetBlockReq)
+	webdavMgrPuts := make(chan model.PutBlockReq)
+	mgrWebdavGets := make(chan model.BlockResponse)
+	mgrWebdavPuts := make(chan model.BlockIdResponse)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mux := sync.Mutex{}
+	mockStorage := make(map[model.BlockId][]byte)
+	go handleWebdavMgrGets(ctx, webdavMgrGets, mgrWebdavGets, &mux, mockStorage)
+	go handleWebdavMgrPuts(ctx, webdavMgrPuts, mgrWebdavPuts, &mux, mockStorage)
+
+	_ = webdav.New(nodeId, webdavMgrGets, webdavMgrPuts, mgrWebdavGets, mgrWebdavPuts, "localhost:7656", "secret", ctx)
+	time.Sleep(1 * time.Second) //FIXME, need a better way to wait for listener to start
+
+	url := "http://localhost:7656/notes.txt"
+	client := &http.Client{}
+	req, _ := http.NewRequest("PUT", url, bytes.NewBufferString("notes"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Error("error putting notes", err)
+		return
+	}
+	resp.Body.Close()
+
+	body := `<?xml version="1.0"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:example">
+  <D:set><D:prop><Z:color>blue</Z:color></D:prop></D:set>
+</D:propertyupdate>`
+	req, _ = http.NewRequest("PROPPATCH", url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusMultiStatus {
+		t.Error("error patching notes", err)
+		return
+	}
+	resp.Body.Close()
+
+	found, err := propFind(url)
+	if err != nil {
+		t.Error("error finding props", err)
+		return
+	}
+	if !strings.Contains(found, "urn:example") || !strings.Contains(found, ">blue<") {
+		t.Error("expected the dead property in the listing", found)
+	}
+}
+
 func propFind(url string) (string, error) {
 	req, err := http.NewRequest("PROPFIND", url, nil)
 	if err != nil {
@@ -115,19 +297,21 @@ func propFind(url string) (string, error) {
 	return string(body), nil
 }
 
-func handleWebdavMgrGets(ctx context.Context, channel chan model.BlockId, respChan chan model.BlockResponse, mux *sync.Mutex, data map[model.BlockId][]byte) {
+func handleWebdavMgrGets(ctx context.Context, channel chan model.GetBlockReq, respChan chan model.BlockResponse, mux *sync.Mutex, data map[model.BlockId][]byte) {
 	for {
 		select {
 		case req := <-channel:
 			mux.Lock()
-			blockData, exists := data[req]
+			blockData, exists := data[req.BlockId]
 			if exists {
 				respChan <- model.BlockResponse{
-					Block: model.Block{Id: req, Data: blockData},
+					Block: model.Block{Id: req.BlockId, Data: blockData},
+					ReqId: req.ReqId,
 				}
 			} else {
 				respChan <- model.BlockResponse{
-					Block: model.Block{Id: req, Data: []byte{}},
+					Block: model.Block{Id: req.BlockId, Data: []byte{}},
+					ReqId: req.ReqId,
 				}
 			}
 			mux.Unlock()
@@ -137,15 +321,15 @@ func handleWebdavMgrGets(ctx context.Context, channel chan model.BlockId, respCh
 	}
 }
 
-func handleWebdavMgrPuts(ctx context.Context, channel chan model.Block, result chan model.BlockIdResponse, mux *sync.Mutex, data map[model.BlockId][]byte) {
+func handleWebdavMgrPuts(ctx context.Context, channel chan model.PutBlockReq, result chan model.BlockIdResponse, mux *sync.Mutex, data map[model.BlockId][]byte) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case req := <-channel:
 			mux.Lock()
-			data[req.Id] = req.Data
-			result <- model.BlockIdResponse{BlockId: req.Id}
+			data[req.Block.Id] = req.Block.Data
+			result <- model.BlockIdResponse{BlockId: req.Block.Id, ReqId: req.ReqId}
 			mux.Unlock()
 		}
 	}
@@ -27,31 +27,47 @@ import (
 	"tealfs/pkg/webdav"
 )
 
+const usage = "<storage path> <webdav address> <ui address> <node address> <free bytes> [tls dir]"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "certs" {
+		os.Exit(certsCommand(os.Args[2:]))
+	}
+
 	if len(os.Args) < 6 {
-		fmt.Fprintln(os.Stderr, os.Args[0], "<storage path> <webdav address> <ui address> <node address> <free bytes>")
+		fmt.Fprintln(os.Stderr, os.Args[0], usage)
 		os.Exit(1)
 	}
 
 	val, err := strconv.ParseUint(os.Args[5], 10, 32)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, os.Args[0], "<storage path> <webdav address> <ui address> <node address> <free bytes>")
+		fmt.Fprintln(os.Stderr, os.Args[0], usage)
 		os.Exit(1)
 	}
 
 	freeBytes := uint32(val)
 
-	_ = startTealFs(os.Args[1], os.Args[2], os.Args[3], os.Args[4], freeBytes, context.Background())
+	var provider conns.ConnectionProvider = &conns.TcpConnectionProvider{}
+	if len(os.Args) > 6 {
+		provider, err = conns.NewTlsConnectionProvider(os.Args[6])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error loading certificates:", err)
+			os.Exit(1)
+		}
+	}
+
+	_ = startTealFs(os.Args[1], os.Args[2], os.Args[3], os.Args[4], freeBytes, provider, context.Background())
 }
 
-func startTealFs(storagePath string, webdavAddress string, uiAddress string, nodeAddress string, freeBytes uint32, ctx context.Context) error {
+func startTealFs(storagePath string, webdavAddress string, uiAddress string, nodeAddress string, freeBytes uint32, provider conns.ConnectionProvider, ctx context.Context) error {
 	m := mgr.NewWithChanSize(2, nodeAddress, storagePath, &disk.DiskFileOps{}, model.Mirrored, freeBytes)
 	_ = conns.NewConns(
 		m.ConnsMgrStatuses,
 		m.ConnsMgrReceives,
 		m.MgrConnsConnectTos,
 		m.MgrConnsSends,
-		&conns.TcpConnectionProvider{},
+		m.MgrConnsDisconnects,
+		provider,
 		nodeAddress,
 		m.NodeId,
 		ctx,
@@ -63,8 +79,17 @@ func startTealFs(storagePath string, webdavAddress string, uiAddress string, nod
 		m.MgrDiskReads,
 		m.DiskMgrWrites,
 		m.DiskMgrReads,
+		m.MgrDiskInventories,
+		m.DiskMgrInventories,
+		m.MgrDiskHints,
+		m.DiskMgrHints,
+		m.MgrDiskHintDeletes,
 	)
-	_ = ui.NewUi(m.UiMgrConnectTos, m.MgrUiStatuses, &ui.HttpHtmlOps{}, uiAddress, ctx)
+	adminPassword, err := mgr.ReadAdminPassword(storagePath, &disk.DiskFileOps{})
+	if err != nil {
+		return err
+	}
+	_ = ui.NewUi(m.UiMgrConnectTos, m.UiMgrJoinTokens, m.MgrUiStatuses, &ui.HttpHtmlOps{}, adminPassword, uiAddress, ctx)
 	_ = webdav.New(
 		m.NodeId,
 		m.WebdavMgrGets,
@@ -72,9 +97,10 @@ func startTealFs(storagePath string, webdavAddress string, uiAddress string, nod
 		m.MgrWebdavGets,
 		m.MgrWebdavPuts,
 		webdavAddress,
+		adminPassword,
 		ctx,
 	)
-	err := m.Start()
+	err = m.Start()
 	if err != nil {
 		return err
 	}